@@ -0,0 +1,75 @@
+package blockchain
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// LoadOrCreateNodeKey reads the node's RSA private key from a PKCS#1 PEM file
+// at path. If the file does not exist, a new 2048-bit key is generated and
+// written there, readable only by the owner.
+func LoadOrCreateNodeKey(path string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		encoded := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+		if err := os.WriteFile(path, encoded, 0600); err != nil {
+			return nil, fmt.Errorf("writing node key: %v", err)
+		}
+		return key, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading node key: %v", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("node key %s is not PEM encoded", path)
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing node key: %v", err)
+	}
+	return key, nil
+}
+
+// PublicKeyPEM returns the address of key: its public key as a PKCS#1 PEM
+// block, the same form users sign up with.
+func PublicKeyPEM(key *rsa.PrivateKey) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&key.PublicKey)}))
+}
+
+// Sign signs message the way VerifySignature checks it: RSA PKCS#1 v1.5 over
+// its SHA-256, Base64 encoded.
+func Sign(message string, key *rsa.PrivateKey) (string, error) {
+	hashed := sha256.Sum256([]byte(message))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
+
+// Prove returns key's VRF proof over message. It is the Sign signature:
+// RSA PKCS#1 v1.5 is deterministic and only one signature verifies for a key
+// and message, so the holder of key cannot pick among proofs, and hashing the
+// proof gives randomness nobody could predict without the key.
+func Prove(message string, key *rsa.PrivateKey) (string, error) {
+	return Sign(message, key)
+}
+
+// VerifyProof checks a proof made by Prove against the address that made it.
+func VerifyProof(message string, proof string, address string) (bool, error) {
+	return VerifySignature(message, proof, address)
+}
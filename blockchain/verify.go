@@ -0,0 +1,181 @@
+package blockchain
+
+import (
+	"fmt"
+	"indicartcoin/structs"
+)
+
+// ChainError describes the first problem VerifyChain found.
+type ChainError struct {
+	BlockIndex    int    `json:"blockIndex"`
+	TransactionId string `json:"transactionId,omitempty"`
+	Reason        string `json:"reason"`
+}
+
+func (e *ChainError) Error() string {
+	if e.TransactionId != "" {
+		return fmt.Sprintf("block %d, transaction %s: %s", e.BlockIndex, e.TransactionId, e.Reason)
+	}
+	return fmt.Sprintf("block %d: %s", e.BlockIndex, e.Reason)
+}
+
+// VerifyChain walks blocks in order and checks that indexes are consecutive
+// starting at 1, that every PrevHash matches the previous block's Hash, that
+// header versions never go back and structured timestamps always increase,
+// that every Merkle root and stored Hash can be recomputed, and that the
+// proposer's signature and every transaction signature are valid. Block 1 must link to genesis, or have no
+// PrevHash if genesis is nil. It returns a *ChainError for the first broken
+// link, or nil.
+func VerifyChain(blocks []*structs.Block, genesis *structs.Block, chainID string) error {
+	prev := genesis
+	for i, block := range blocks {
+		if err := VerifyBlock(block, prev, chainID); err != nil {
+			return err
+		}
+		prev = blocks[i]
+	}
+	return nil
+}
+
+// VerifyBlock checks a single block against its parent: the genesis block, or
+// nil for the first block of a chain without one. It is used both for stored
+// blocks and for blocks received from other nodes. chainID is the one
+// proposers and version 1 and later transactions signed for. From
+// structs.NonceBlockVersion on, every transaction must carry a nonce and no
+// transaction ID may appear twice. A priced ArtTransfer must also be signed
+// by its buyer (see structs.Transaction.NeedsBuyerSignature).
+func VerifyBlock(block *structs.Block, prev *structs.Block, chainID string) error {
+	if err := VerifyHeader(block, prev, chainID); err != nil {
+		return err
+	}
+
+	if block.Version >= structs.MerkleBlockVersion {
+		if root := structs.ComputeMerkleRoot(block.Transactions); root != block.MerkleRoot {
+			return &ChainError{BlockIndex: block.Index, Reason: fmt.Sprintf("merkle root %s does not match transactions (computed %s)", block.MerkleRoot, root)}
+		}
+	} else if computed := block.CalculateHash(); computed != block.Hash && legacyHash(block) != block.Hash {
+		return &ChainError{BlockIndex: block.Index, Reason: fmt.Sprintf("stored hash %s does not match computed hash %s", block.Hash, computed)}
+	}
+
+	ids := make(map[string]bool, len(block.Transactions))
+	for _, tx := range block.Transactions {
+		if block.Version >= structs.NonceBlockVersion {
+			if tx.Version < structs.NoncedTransactionVersion {
+				return &ChainError{BlockIndex: block.Index, TransactionId: tx.TransactionId, Reason: fmt.Sprintf("version %d transaction has no nonce", tx.Version)}
+			}
+			if ids[tx.TransactionId] {
+				return &ChainError{BlockIndex: block.Index, TransactionId: tx.TransactionId, Reason: "transaction appears twice in the block"}
+			}
+			ids[tx.TransactionId] = true
+		}
+		valid, err := VerifySignature(tx.SignedMessage(chainID), tx.Signature, tx.From)
+		if !valid || err != nil {
+			reason := "invalid signature"
+			if err != nil {
+				reason = "invalid signature: " + err.Error()
+			}
+			return &ChainError{BlockIndex: block.Index, TransactionId: tx.TransactionId, Reason: reason}
+		}
+		if tx.NeedsBuyerSignature() {
+			valid, err := VerifySignature(tx.SignedMessage(chainID), tx.BuyerSignature, tx.To)
+			if !valid || err != nil {
+				reason := "invalid buyer signature"
+				if err != nil {
+					reason = "invalid buyer signature: " + err.Error()
+				}
+				return &ChainError{BlockIndex: block.Index, TransactionId: tx.TransactionId, Reason: reason}
+			}
+		}
+	}
+	return nil
+}
+
+// VerifyHeader checks everything about a block that does not need its
+// transactions: the link to its parent, the header version and timestamp,
+// the hash, the proposer's signature and VRF proof. It is what a node can check of the
+// headers it downloads before the blocks themselves. Version 0 hashes cover
+// the transactions, so their hash is left to VerifyBlock.
+func VerifyHeader(block *structs.Block, prev *structs.Block, chainID string) error {
+	if prev == nil {
+		if block.Index != 1 {
+			return &ChainError{BlockIndex: block.Index, Reason: fmt.Sprintf("first block has index %d, expected 1", block.Index)}
+		}
+		if block.PrevHash != "" {
+			return &ChainError{BlockIndex: block.Index, Reason: fmt.Sprintf("first block has prev hash %q, expected none", block.PrevHash)}
+		}
+	} else {
+		if block.Index != prev.Index+1 {
+			return &ChainError{BlockIndex: block.Index, Reason: fmt.Sprintf("index follows block %d", prev.Index)}
+		}
+		if block.PrevHash != prev.Hash {
+			return &ChainError{BlockIndex: block.Index, Reason: fmt.Sprintf("prev hash %s does not match hash %s of block %d", block.PrevHash, prev.Hash, prev.Index)}
+		}
+		// The genesis block is not a sealed header, so chains that predate
+		// structured headers may still follow it.
+		if prev.Index > 0 && block.Version < prev.Version {
+			return &ChainError{BlockIndex: block.Index, Reason: fmt.Sprintf("header version %d follows version %d", block.Version, prev.Version)}
+		}
+		// Older blocks have free-form timestamps; only structured ones are
+		// held to increasing time.
+		if block.Version >= structs.HeaderBlockVersion {
+			if prevTime, ok := prev.Time(); ok && block.TimestampNanos <= prevTime.UnixNano() {
+				return &ChainError{BlockIndex: block.Index, Reason: fmt.Sprintf("timestamp %d is not after block %d's %d", block.TimestampNanos, prev.Index, prevTime.UnixNano())}
+			}
+		}
+	}
+
+	switch {
+	case block.Version > structs.VRFBlockVersion || block.Version < structs.LegacyBlockVersion:
+		return &ChainError{BlockIndex: block.Index, Reason: fmt.Sprintf("unknown header version %d", block.Version)}
+	case block.Version >= structs.HeaderBlockVersion && block.Timestamp != "":
+		return &ChainError{BlockIndex: block.Index, Reason: fmt.Sprintf("version %d block carries a text timestamp", block.Version)}
+	case block.Version < structs.SignedBlockVersion && (block.Proposer != "" || block.Signature != ""):
+		return &ChainError{BlockIndex: block.Index, Reason: fmt.Sprintf("version %d block carries a proposer", block.Version)}
+	case block.Version < structs.VRFBlockVersion && block.VRFProof != "":
+		return &ChainError{BlockIndex: block.Index, Reason: fmt.Sprintf("version %d block carries a VRF proof", block.Version)}
+	case block.Version == structs.LegacyBlockVersion && block.MerkleRoot != "":
+		return &ChainError{BlockIndex: block.Index, Reason: "version 0 block carries a merkle root"}
+	}
+
+	if block.Version >= structs.MerkleBlockVersion {
+		if computed := block.CalculateHash(); computed != block.Hash {
+			return &ChainError{BlockIndex: block.Index, Reason: fmt.Sprintf("stored hash %s does not match computed hash %s", block.Hash, computed)}
+		}
+	}
+
+	if block.Version >= structs.SignedBlockVersion {
+		if block.Proposer == "" {
+			return &ChainError{BlockIndex: block.Index, Reason: "missing proposer"}
+		}
+		valid, err := VerifySignature(block.SigningPayload(chainID), block.Signature, block.Proposer)
+		if !valid || err != nil {
+			reason := "invalid proposer signature"
+			if err != nil {
+				reason = "invalid proposer signature: " + err.Error()
+			}
+			return &ChainError{BlockIndex: block.Index, Reason: reason}
+		}
+	}
+
+	if block.Version >= structs.VRFBlockVersion {
+		valid, err := VerifyProof(block.VRFPayload(chainID, prev), block.VRFProof, block.Proposer)
+		if !valid || err != nil {
+			reason := "invalid VRF proof"
+			if err != nil {
+				reason = "invalid VRF proof: " + err.Error()
+			}
+			return &ChainError{BlockIndex: block.Index, Reason: reason}
+		}
+	}
+	return nil
+}
+
+// legacyHash reproduces hashes written before AddBlock set PrevHash ahead of
+// hashing, when the previous hash was not part of the hashed record. Only
+// LegacyBlockVersion blocks can be that old. The
+// PrevHash link itself is still checked separately.
+func legacyHash(block *structs.Block) string {
+	unlinked := *block
+	unlinked.PrevHash = ""
+	return unlinked.CalculateHash()
+}
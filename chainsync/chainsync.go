@@ -0,0 +1,348 @@
+package chainsync
+
+import (
+	"errors"
+	"fmt"
+	"indicartcoin/blockchain"
+	"indicartcoin/database"
+	"indicartcoin/p2p"
+	"indicartcoin/structs"
+	"log"
+	"sync"
+	"time"
+)
+
+// Sync states reported in Status.
+const (
+	// StateWaiting is the state at startup until the first peer connects or
+	// startupWait passes. The node may be behind without knowing it yet.
+	StateWaiting = "waiting"
+	// StateSyncing means blocks are being downloaded from a peer.
+	StateSyncing = "syncing"
+	// StateSynced means no connected peer has a longer chain the node can
+	// adopt; new blocks arrive by gossip.
+	StateSynced = "synced"
+)
+
+const (
+	checkInterval = 2 * time.Second
+	startupWait   = 15 * time.Second
+)
+
+// Status describes the sync progress.
+type Status struct {
+	State        string     `json:"state"`
+	Peer         string     `json:"peer,omitempty"` // node ID of the peer being synced from
+	StartHeight  int        `json:"startHeight"`    // the node's height when the sync began
+	Height       int        `json:"height"`
+	TargetHeight int        `json:"targetHeight"` // the peer's height
+	Progress     float64    `json:"progress"`     // 0 to 1
+	StartedAt    *time.Time `json:"startedAt,omitempty"`
+	LastError    string     `json:"lastError,omitempty"`
+}
+
+// Syncer catches the node up with its peers. Whenever a connected peer is
+// ahead, it downloads that peer's headers from the last block both share,
+// checks them with blockchain.VerifyHeader, then downloads the blocks in
+// ranges and hands each to database.ImportBlock, which verifies it in full
+// and applies it. Once no peer is ahead the node relies on gossip again.
+type Syncer struct {
+	node *p2p.Node
+
+	mu     sync.Mutex
+	status Status
+	// stale holds peers whose chain the node did not adopt, with the height
+	// they had then. They are not synced from again until they grow.
+	stale map[string]int
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New returns a Syncer. With waitForPeers set, Syncing reports true until a
+// peer has connected and been caught up with, or startupWait passed without
+// one, so the node does not seal blocks on a chain it is about to replace.
+func New(waitForPeers bool) *Syncer {
+	state := StateSynced
+	if waitForPeers {
+		state = StateWaiting
+	}
+	return &Syncer{
+		status: Status{State: state},
+		stale:  make(map[string]int),
+		wake:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// Start begins syncing from node's peers in the background.
+func (s *Syncer) Start(node *p2p.Node) {
+	s.node = node
+	go s.run()
+}
+
+// Stop asks the syncer to exit and waits until it has. A range being applied
+// is finished first.
+func (s *Syncer) Stop() {
+	close(s.stop)
+	<-s.done
+}
+
+// Wake makes the syncer look for a peer that is ahead right away.
+func (s *Syncer) Wake() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Status returns the current sync progress.
+func (s *Syncer) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := s.status
+	status.Height = database.Height()
+	return status
+}
+
+// Syncing reports whether the node is catching up, or still waiting to learn
+// whether it has to.
+func (s *Syncer) Syncing() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.status.State != StateSynced
+}
+
+// ReceiveBlock hands a gossiped block to database.ReceiveBlock. A block whose
+// parent is unknown means a peer is ahead, so the syncer is woken.
+func (s *Syncer) ReceiveBlock(block *structs.Block) error {
+	err := database.ReceiveBlock(block)
+	if errors.Is(err, database.ErrUnknownParent) {
+		s.Wake()
+	}
+	return err
+}
+
+func (s *Syncer) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	started := time.Now()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+
+		peer := s.bestPeer()
+		if peer == nil {
+			s.mu.Lock()
+			if s.status.State == StateSyncing || (s.status.State == StateWaiting && time.Since(started) >= startupWait) {
+				s.status.State = StateSynced
+				s.status.Peer = ""
+			}
+			s.mu.Unlock()
+			continue
+		}
+		s.syncFrom(peer)
+	}
+}
+
+// bestPeer returns the connected peer with the highest chain above the
+// node's, ignoring stale peers, or nil. Once a peer has connected the node
+// no longer waits for others before it considers itself synced.
+func (s *Syncer) bestPeer() *p2p.Peer {
+	height := database.Height()
+	var best *p2p.Peer
+	bestHeight := height
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	peers := s.node.Peers()
+	if len(peers) > 0 && s.status.State == StateWaiting {
+		s.status.State = StateSyncing
+	}
+	for _, peer := range peers {
+		info := peer.Info()
+		if staleHeight, found := s.stale[info.NodeID]; found && info.Height <= staleHeight {
+			continue
+		}
+		if info.Height > bestHeight {
+			best, bestHeight = peer, info.Height
+		}
+	}
+	return best
+}
+
+// syncFrom downloads and applies the peer's chain up to its height. A peer
+// that sends invalid headers or blocks is banned.
+func (s *Syncer) syncFrom(peer *p2p.Peer) {
+	info := peer.Info()
+	startHeight := database.Height()
+	s.mu.Lock()
+	if s.status.State != StateSyncing || s.status.Peer != info.NodeID {
+		now := time.Now().UTC()
+		s.status = Status{State: StateSyncing, Peer: info.NodeID, StartHeight: startHeight, StartedAt: &now}
+	}
+	s.status.TargetHeight = info.Height
+	s.mu.Unlock()
+	log.Printf("Syncing from peer %s (node %s) at height %d, %d blocks ahead", info.Address, info.NodeID, info.Height, info.Height-startHeight)
+
+	err := s.download(peer)
+	height := database.Height()
+	info = peer.Info()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.TargetHeight = info.Height
+	s.status.Progress = progress(s.status.StartHeight, height, info.Height)
+	if err != nil {
+		s.status.LastError = err.Error()
+		log.Printf("Sync from peer %s stopped at height %d: %v", info.Address, height, err)
+		if p2p.IsInvalid(err) {
+			peer.Misbehaved(p2p.BanScore, err.Error())
+		}
+	}
+	if height <= startHeight {
+		// Whatever kept the node from adopting the peer's chain, such as a
+		// lighter branch, a fork too deep to reorg or an unresponsive peer,
+		// is not tried again until the peer's chain grows.
+		s.stale[info.NodeID] = info.Height
+		return
+	}
+	if err == nil && height >= info.Height {
+		log.Printf("Caught up with peer %s at height %d", info.Address, height)
+	}
+}
+
+// download fetches headers from the last block the node shares with the peer
+// up to the peer's tip and applies the matching blocks as it goes.
+func (s *Syncer) download(peer *p2p.Peer) error {
+	fork, err := findFork(peer)
+	if err != nil {
+		return err
+	}
+	prev := database.BlockAt(fork)
+	next := fork + 1
+	for next <= peer.Info().Height {
+		select {
+		case <-s.stop:
+			return errors.New("stopped")
+		default:
+		}
+
+		headers, err := peer.GetHeaders(next, p2p.MaxHeadersPerRequest)
+		if err != nil {
+			return err
+		}
+		if len(headers) == 0 {
+			return nil
+		}
+		if next == fork+1 && prev != nil && headers[0].PrevHash != prev.Hash {
+			return fmt.Errorf("peer's chain leaves the main chain more than %d blocks back", database.ChainConfig.MaxReorgDepth)
+		}
+		for i := range headers {
+			if err := blockchain.VerifyHeader(&headers[i], prev, database.ChainConfig.ChainID); err != nil {
+				return p2p.Invalid(fmt.Errorf("invalid header: %w", err))
+			}
+			if err := database.CheckClock(&headers[i], time.Now()); err != nil {
+				return err
+			}
+			prev = &headers[i]
+		}
+
+		for start := 0; start < len(headers); start += p2p.MaxBlocksPerRequest {
+			end := min(start+p2p.MaxBlocksPerRequest, len(headers))
+			if err := s.applyRange(peer, headers[start:end]); err != nil {
+				return err
+			}
+		}
+		next = headers[len(headers)-1].Index + 1
+	}
+	return nil
+}
+
+// applyRange downloads the blocks for headers, checks each matches its
+// header, and passes them to database.ImportBlock in order. Blocks the node
+// already has on its main chain are not downloaded.
+func (s *Syncer) applyRange(peer *p2p.Peer, headers []structs.Block) error {
+	for len(headers) > 0 {
+		if main := database.BlockAt(headers[0].Index); main == nil || main.Hash != headers[0].Hash {
+			break
+		}
+		headers = headers[1:]
+	}
+	if len(headers) == 0 {
+		return nil
+	}
+
+	blocks, err := peer.GetBlocks(headers[0].Index, len(headers))
+	if err != nil {
+		return err
+	}
+	if len(blocks) < len(headers) {
+		return fmt.Errorf("peer sent %d of %d blocks from %d", len(blocks), len(headers), headers[0].Index)
+	}
+	for i, block := range blocks {
+		if block.Hash != headers[i].Hash {
+			return p2p.Invalid(fmt.Errorf("block %d has hash %s, its header %s", headers[i].Index, block.Hash, headers[i].Hash))
+		}
+		if err := database.ImportBlock(block); err != nil && !errors.Is(err, database.ErrKnownBlock) {
+			if errors.Is(err, database.ErrUnknownParent) {
+				return err
+			}
+			if p2p.IsInvalid(err) {
+				return p2p.Invalid(fmt.Errorf("invalid block: %w", err))
+			}
+			return fmt.Errorf("applying block %d: %w", block.Index, err)
+		}
+	}
+
+	height := database.Height()
+	info := peer.Info()
+	s.mu.Lock()
+	s.status.TargetHeight = info.Height
+	s.status.Progress = progress(s.status.StartHeight, height, info.Height)
+	done := s.status.Progress
+	s.mu.Unlock()
+	log.Printf("Synced to block %d of %d from peer %s (%.0f%%)", height, info.Height, info.Address, 100*done)
+	return nil
+}
+
+// findFork returns the index of the highest main-chain block the peer has
+// too, checking the tip first and then further back in growing steps, at most
+// MaxReorgDepth blocks deep. Block 0 is the genesis, which the handshake
+// already matched.
+func findFork(peer *p2p.Peer) (int, error) {
+	height := database.Height()
+	lowest := max(height-database.ChainConfig.MaxReorgDepth, 0)
+	for index, step := height, 1; index > lowest; index, step = index-step, step*2 {
+		headers, err := peer.GetHeaders(index, 1)
+		if err != nil {
+			return 0, err
+		}
+		if len(headers) == 1 {
+			if main := database.BlockAt(index); main != nil && main.Hash == headers[0].Hash {
+				return index, nil
+			}
+		}
+	}
+	return lowest, nil
+}
+
+// progress returns how far height is from start to target, between 0 and 1.
+func progress(start, height, target int) float64 {
+	if target <= start {
+		return 1
+	}
+	return min(max(float64(height-start)/float64(target-start), 0), 1)
+}
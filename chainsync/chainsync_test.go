@@ -0,0 +1,214 @@
+package chainsync
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"indicartcoin/blockchain"
+	"indicartcoin/config"
+	"indicartcoin/database"
+	"indicartcoin/forkchoice"
+	"indicartcoin/p2p"
+	"indicartcoin/sqldatabase"
+	"indicartcoin/state"
+	"indicartcoin/structs"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// startChain resets the database package to an empty chain on a new SQLite
+// store, the way main does at startup, with balances as the stored balances.
+func startChain(t *testing.T, balances map[string]structs.Amount) {
+	t.Helper()
+	store, err := sqldatabase.OpenSQLite(filepath.Join(t.TempDir(), "chain.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	if _, err := store.Migrate(false); err != nil {
+		t.Fatal(err)
+	}
+	for address, balance := range balances {
+		store.UpdateBalance(address, balance)
+	}
+
+	sqldatabase.UseStore(store)
+	database.ChainConfig = config.Default().Chain
+	database.ChainConfig.ChainID = "test"
+	// Every validator's turn has come as soon as the parent is sealed.
+	database.ChainConfig.BlockInterval = 0
+	database.NodeKey = newKey(t)
+	database.Peers = nil
+	database.Blockchain = structs.Blockchain{Mutex: &sync.Mutex{}, Blocks: []*structs.Block{}}
+	database.SideBlocks = forkchoice.NewTree()
+	database.AppState = &state.State{
+		Balances:     sqldatabase.LoadBalances(),
+		Nonces:       map[string]uint64{},
+		ArtOwnership: map[string]structs.ArtOwnership{},
+		Stakes:       map[string]structs.Amount{},
+		ChainID:      "test",
+	}
+}
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// request is a headers or blocks request a peer served.
+type request struct {
+	headers     bool
+	from, count int
+}
+
+// TestSyncFromLongerChain starts a node holding the first blocks of a peer's
+// chain and checks it catches up: headers for the missing blocks come first,
+// blocks are only asked for once their headers have arrived, and the blocks
+// the node already has are not downloaded again.
+func TestSyncFromLongerChain(t *testing.T) {
+	const shared, length = 3, 2*p2p.MaxBlocksPerRequest + 5
+	proposerKey, aliceKey := newKey(t), newKey(t)
+	proposer, alice := blockchain.PublicKeyPEM(proposerKey), blockchain.PublicKeyPEM(aliceKey)
+	startChain(t, map[string]structs.Amount{alice: 100})
+
+	// The peer's chain pays bob from alice in every fifth block.
+	chain := structs.Blockchain{Mutex: &sync.Mutex{}}
+	var nonce uint64
+	for i := 1; i <= length; i++ {
+		var txs []structs.Transaction
+		if i%5 == 0 {
+			tx := structs.Transaction{TransactionId: "to-bob-" + strconv.FormatUint(nonce, 10), Type: structs.CoinTransfer, From: alice, To: "bob", Amount: 2, Fee: 1, Nonce: nonce, Version: structs.NoncedTransactionVersion}
+			var err error
+			if tx.Signature, err = blockchain.Sign(tx.SignedMessage("test"), aliceKey); err != nil {
+				t.Fatal(err)
+			}
+			txs = append(txs, tx)
+			nonce++
+		}
+		_, err := chain.AddBlock(txs, proposer,
+			func(block, parent *structs.Block) (string, error) {
+				return blockchain.Prove(block.VRFPayload("test", parent), proposerKey)
+			},
+			func(block *structs.Block) (string, error) {
+				return blockchain.Sign(block.SigningPayload("test"), proposerKey)
+			})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, block := range chain.Blocks[:shared] {
+		if err := database.ImportBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var mu sync.Mutex
+	var requests []request
+	blockRange := func(from, count int) []*structs.Block {
+		if from < 1 || count < 1 || from > len(chain.Blocks) {
+			return nil
+		}
+		return chain.Blocks[from-1 : min(from-1+count, len(chain.Blocks))]
+	}
+	peer := p2p.NewNode("test", "", p2p.Options{}, p2p.Handlers{
+		Height: func() int { return length },
+		Headers: func(from, count int) []structs.Block {
+			mu.Lock()
+			requests = append(requests, request{true, from, count})
+			mu.Unlock()
+			var headers []structs.Block
+			for _, block := range blockRange(from, count) {
+				headers = append(headers, block.Header())
+			}
+			return headers
+		},
+		Blocks: func(from, count int) []*structs.Block {
+			mu.Lock()
+			requests = append(requests, request{false, from, count})
+			mu.Unlock()
+			return blockRange(from, count)
+		},
+	})
+	server := httptest.NewServer(peer)
+	defer server.Close()
+	defer peer.Close()
+
+	syncer := New(false)
+	node := p2p.NewNode("test", "", p2p.Options{}, p2p.Handlers{
+		Height:  database.Height,
+		Block:   syncer.ReceiveBlock,
+		Headers: database.Headers,
+		Blocks:  database.BlockRange,
+	})
+	defer node.Close()
+	syncer.Start(node)
+	defer syncer.Stop()
+	if _, err := node.Connect("ws" + strings.TrimPrefix(server.URL, "http")); err != nil {
+		t.Fatal(err)
+	}
+	syncer.Wake()
+
+	waitFor(t, "the node to reach the peer's height", func() bool { return database.Height() == length })
+	waitFor(t, "the syncer to report synced", func() bool { return syncer.Status().State == StateSynced })
+
+	for i, block := range chain.Blocks {
+		if main := database.BlockAt(i + 1); main == nil || main.Hash != block.Hash {
+			t.Fatalf("block %d is %v, want the peer's %s", i+1, main, block.Hash)
+		}
+	}
+	if want := length / 5 * 2; database.AppState.Balances["bob"] != structs.Amount(want) {
+		t.Errorf("bob has %s after the sync, want %d units", database.AppState.Balances["bob"], want)
+	}
+	if database.AppState.Nonces[alice] != nonce {
+		t.Errorf("alice's nonce is %d after the sync, want %d", database.AppState.Nonces[alice], nonce)
+	}
+	if status := syncer.Status(); status.Progress != 1 || status.StartHeight != shared || status.TargetHeight != length {
+		t.Errorf("status %+v, want progress 1 from %d to %d", status, shared, length)
+	}
+
+	// Blocks are fetched from past the shared blocks on, each after its
+	// header and no more than MaxBlocksPerRequest at a time.
+	mu.Lock()
+	defer mu.Unlock()
+	headersTo, blocksTo := 0, shared
+	for _, r := range requests {
+		if r.headers {
+			if r.count > 1 {
+				headersTo = max(headersTo, min(r.from+r.count-1, length))
+			}
+			continue
+		}
+		if r.from != blocksTo+1 {
+			t.Errorf("blocks requested from %d, want from %d", r.from, blocksTo+1)
+		}
+		if r.count > p2p.MaxBlocksPerRequest {
+			t.Errorf("%d blocks requested at once, want at most %d", r.count, p2p.MaxBlocksPerRequest)
+		}
+		blocksTo = r.from + r.count - 1
+		if blocksTo > headersTo {
+			t.Errorf("blocks up to %d requested with headers only up to %d", blocksTo, headersTo)
+		}
+	}
+	if blocksTo != length {
+		t.Errorf("blocks requested up to %d, want %d", blocksTo, length)
+	}
+}
@@ -0,0 +1,375 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the node configuration. It is read from a JSON file, then
+// individual values can be overridden with INDICARTCOIN_* environment
+// variables (see envOverrides).
+type Config struct {
+	Storage StorageConfig `json:"storage"`
+	Server  ServerConfig  `json:"server"`
+	Chain   ChainConfig   `json:"chain"`
+	Mempool MempoolConfig `json:"mempool"`
+	P2P     P2PConfig     `json:"p2p"`
+}
+
+type StorageConfig struct {
+	Backend    string `json:"backend"`    // "mysql", "sqlite" or "memory"
+	MySQLDSN   string `json:"mysqlDsn"`   // used by the mysql backend
+	SQLitePath string `json:"sqlitePath"` // used by the sqlite backend
+}
+
+type ServerConfig struct {
+	ListenAddr    string   `json:"listenAddr"`
+	FetchInterval Duration `json:"fetchInterval"` // how often users and the art summary are reloaded from storage
+	NodeKeyPath   string   `json:"nodeKeyPath"`   // PEM RSA key the node signs with; created if missing
+}
+
+type ChainConfig struct {
+	ChainID                 string   `json:"chainId"`     // signed into every version 1 transaction
+	GenesisPath             string   `json:"genesisPath"` // genesis file; its chain ID and params replace the ones here
+	MaxTransactionsPerBlock int      `json:"maxTransactionsPerBlock"`
+	MaxBlockBytes           int      `json:"maxBlockBytes"`       // total JSON size of a block's transactions
+	BlockInterval           Duration `json:"blockInterval"`       // longest wait between blocks
+	MaxClockDrift           Duration `json:"maxClockDrift"`       // how far ahead of this node's clock a received block may be stamped
+	ProduceEmptyBlocks      bool     `json:"produceEmptyBlocks"`  // seal a block on schedule even with an empty pool
+	ConfirmationDepth       int      `json:"confirmationDepth"`   // blocks, counting its own, before a transaction is Confirmed
+	MaxReorgDepth           int      `json:"maxReorgDepth"`       // most main-chain blocks a heavier branch may replace
+	RewardDecayConstant     float64  `json:"rewardDecayConstant"` // exponential decay of validator fee shares
+	AmountDecimals          int      `json:"amountDecimals"`      // decimal places of one coin; fixed for the life of a chain
+	VerifyOnStartup         bool     `json:"verifyOnStartup"`     // refuse to start if the stored chain is broken
+}
+
+type MempoolConfig struct {
+	MaxTransactions int      `json:"maxTransactions"` // the lowest-fee transaction is evicted beyond this
+	TTL             Duration `json:"ttl"`             // how long a transaction may wait; "0s" keeps it forever
+}
+
+type P2PConfig struct {
+	Peers          []string `json:"peers"`          // ws:// or wss:// URLs of other nodes' /p2p endpoints
+	RedialInterval Duration `json:"redialInterval"` // wait before reconnecting to a peer that dropped
+	ExternalURL    string   `json:"externalUrl"`    // ws:// or wss:// URL other nodes can reach this one's /p2p at, if any
+	MaxOutbound    int      `json:"maxOutbound"`    // connections to dial, static peers included
+	BanDuration    Duration `json:"banDuration"`    // how long a misbehaving peer is kept away
+}
+
+// Duration is a time.Duration written as a Go duration string ("1s", "500ms").
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"1s\": %v", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Default returns the configuration a node starts with when no file or
+// environment variable overrides it: a single node keeping its chain in a
+// SQLite file in the working directory. MySQL needs a DSN, so it has to be
+// chosen explicitly.
+func Default() Config {
+	return Config{
+		Storage: StorageConfig{
+			Backend:    "sqlite",
+			SQLitePath: "indicartcoin.db",
+		},
+		Server: ServerConfig{
+			ListenAddr:    ":8080",
+			FetchInterval: Duration(time.Second),
+			NodeKeyPath:   "node_key.pem",
+		},
+		Chain: ChainConfig{
+			ChainID:                 "indicartcoin-local",
+			MaxTransactionsPerBlock: 5,
+			MaxBlockBytes:           1 << 20,
+			BlockInterval:           Duration(5 * time.Second),
+			MaxClockDrift:           Duration(time.Second),
+			ConfirmationDepth:       6,
+			MaxReorgDepth:           100,
+			RewardDecayConstant:     0.5,
+			AmountDecimals:          9,
+			VerifyOnStartup:         true,
+		},
+		Mempool: MempoolConfig{
+			MaxTransactions: 5000,
+			TTL:             Duration(time.Hour),
+		},
+		P2P: P2PConfig{
+			RedialInterval: Duration(5 * time.Second),
+			MaxOutbound:    8,
+			BanDuration:    Duration(24 * time.Hour),
+		},
+	}
+}
+
+// Load builds the configuration from the defaults, the JSON file at path (if
+// path is not empty) and the environment, and validates the result.
+func Load(path string) (Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, fmt.Errorf("reading config file: %v", err)
+		}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&cfg); err != nil {
+			return cfg, fmt.Errorf("parsing config file %s: %v", path, err)
+		}
+	}
+
+	if err := cfg.applyEnv(os.LookupEnv); err != nil {
+		return cfg, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// envOverride maps one environment variable onto a config field.
+type envOverride struct {
+	name  string
+	apply func(cfg *Config, value string) error
+}
+
+var envOverrides = []envOverride{
+	{"INDICARTCOIN_STORAGE_BACKEND", func(cfg *Config, v string) error {
+		cfg.Storage.Backend = v
+		return nil
+	}},
+	{"INDICARTCOIN_MYSQL_DSN", func(cfg *Config, v string) error {
+		cfg.Storage.MySQLDSN = v
+		return nil
+	}},
+	{"INDICARTCOIN_SQLITE_PATH", func(cfg *Config, v string) error {
+		cfg.Storage.SQLitePath = v
+		return nil
+	}},
+	{"INDICARTCOIN_LISTEN_ADDR", func(cfg *Config, v string) error {
+		cfg.Server.ListenAddr = v
+		return nil
+	}},
+	{"INDICARTCOIN_FETCH_INTERVAL", func(cfg *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		cfg.Server.FetchInterval = Duration(d)
+		return err
+	}},
+	{"INDICARTCOIN_NODE_KEY_PATH", func(cfg *Config, v string) error {
+		cfg.Server.NodeKeyPath = v
+		return nil
+	}},
+	{"INDICARTCOIN_CHAIN_ID", func(cfg *Config, v string) error {
+		cfg.Chain.ChainID = v
+		return nil
+	}},
+	{"INDICARTCOIN_GENESIS_PATH", func(cfg *Config, v string) error {
+		cfg.Chain.GenesisPath = v
+		return nil
+	}},
+	{"INDICARTCOIN_MAX_TRANSACTIONS_PER_BLOCK", func(cfg *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		cfg.Chain.MaxTransactionsPerBlock = n
+		return err
+	}},
+	{"INDICARTCOIN_MAX_BLOCK_BYTES", func(cfg *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		cfg.Chain.MaxBlockBytes = n
+		return err
+	}},
+	{"INDICARTCOIN_BLOCK_INTERVAL", func(cfg *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		cfg.Chain.BlockInterval = Duration(d)
+		return err
+	}},
+	{"INDICARTCOIN_MAX_CLOCK_DRIFT", func(cfg *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		cfg.Chain.MaxClockDrift = Duration(d)
+		return err
+	}},
+	{"INDICARTCOIN_PRODUCE_EMPTY_BLOCKS", func(cfg *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		cfg.Chain.ProduceEmptyBlocks = b
+		return err
+	}},
+	{"INDICARTCOIN_CONFIRMATION_DEPTH", func(cfg *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		cfg.Chain.ConfirmationDepth = n
+		return err
+	}},
+	{"INDICARTCOIN_MAX_REORG_DEPTH", func(cfg *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		cfg.Chain.MaxReorgDepth = n
+		return err
+	}},
+	{"INDICARTCOIN_REWARD_DECAY_CONSTANT", func(cfg *Config, v string) error {
+		f, err := strconv.ParseFloat(v, 64)
+		cfg.Chain.RewardDecayConstant = f
+		return err
+	}},
+	{"INDICARTCOIN_AMOUNT_DECIMALS", func(cfg *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		cfg.Chain.AmountDecimals = n
+		return err
+	}},
+	{"INDICARTCOIN_VERIFY_ON_STARTUP", func(cfg *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		cfg.Chain.VerifyOnStartup = b
+		return err
+	}},
+	{"INDICARTCOIN_MEMPOOL_MAX_TRANSACTIONS", func(cfg *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		cfg.Mempool.MaxTransactions = n
+		return err
+	}},
+	{"INDICARTCOIN_MEMPOOL_TTL", func(cfg *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		cfg.Mempool.TTL = Duration(d)
+		return err
+	}},
+	{"INDICARTCOIN_P2P_PEERS", func(cfg *Config, v string) error {
+		cfg.P2P.Peers = nil
+		for _, peer := range strings.Split(v, ",") {
+			if peer = strings.TrimSpace(peer); peer != "" {
+				cfg.P2P.Peers = append(cfg.P2P.Peers, peer)
+			}
+		}
+		return nil
+	}},
+	{"INDICARTCOIN_P2P_REDIAL_INTERVAL", func(cfg *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		cfg.P2P.RedialInterval = Duration(d)
+		return err
+	}},
+	{"INDICARTCOIN_P2P_EXTERNAL_URL", func(cfg *Config, v string) error {
+		cfg.P2P.ExternalURL = v
+		return nil
+	}},
+	{"INDICARTCOIN_P2P_MAX_OUTBOUND", func(cfg *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		cfg.P2P.MaxOutbound = n
+		return err
+	}},
+	{"INDICARTCOIN_P2P_BAN_DURATION", func(cfg *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		cfg.P2P.BanDuration = Duration(d)
+		return err
+	}},
+}
+
+func (cfg *Config) applyEnv(lookup func(string) (string, bool)) error {
+	for _, override := range envOverrides {
+		value, ok := lookup(override.name)
+		if !ok {
+			continue
+		}
+		if err := override.apply(cfg, strings.TrimSpace(value)); err != nil {
+			return fmt.Errorf("invalid %s: %v", override.name, err)
+		}
+	}
+	return nil
+}
+
+// Validate reports every invalid setting at once.
+func (cfg Config) Validate() error {
+	var errs []error
+
+	switch cfg.Storage.Backend {
+	case "mysql":
+		if cfg.Storage.MySQLDSN == "" {
+			errs = append(errs, errors.New("storage.mysqlDsn is required for the mysql backend"))
+		}
+	case "sqlite":
+		if cfg.Storage.SQLitePath == "" {
+			errs = append(errs, errors.New("storage.sqlitePath is required for the sqlite backend"))
+		}
+	case "memory":
+	default:
+		errs = append(errs, fmt.Errorf("storage.backend %q is not one of mysql, sqlite, memory", cfg.Storage.Backend))
+	}
+
+	if cfg.Server.ListenAddr == "" {
+		errs = append(errs, errors.New("server.listenAddr is required"))
+	}
+	if cfg.Server.FetchInterval <= 0 {
+		errs = append(errs, errors.New("server.fetchInterval must be positive"))
+	}
+	if cfg.Server.NodeKeyPath == "" {
+		errs = append(errs, errors.New("server.nodeKeyPath is required"))
+	}
+	if cfg.Chain.ChainID == "" || strings.ContainsRune(cfg.Chain.ChainID, 0) {
+		errs = append(errs, errors.New("chain.chainId must be a non-empty string without NUL bytes"))
+	}
+	if cfg.Chain.MaxTransactionsPerBlock <= 0 {
+		errs = append(errs, errors.New("chain.maxTransactionsPerBlock must be positive"))
+	}
+	if cfg.Chain.MaxBlockBytes <= 0 {
+		errs = append(errs, errors.New("chain.maxBlockBytes must be positive"))
+	}
+	if cfg.Chain.BlockInterval <= 0 {
+		errs = append(errs, errors.New("chain.blockInterval must be positive"))
+	}
+	if cfg.Chain.MaxClockDrift < 0 || cfg.Chain.MaxClockDrift >= cfg.Chain.BlockInterval {
+		errs = append(errs, errors.New("chain.maxClockDrift must not be negative and must be shorter than chain.blockInterval"))
+	}
+	if cfg.Chain.ConfirmationDepth <= 0 {
+		errs = append(errs, errors.New("chain.confirmationDepth must be positive"))
+	}
+	if cfg.Chain.MaxReorgDepth <= 0 {
+		errs = append(errs, errors.New("chain.maxReorgDepth must be positive"))
+	}
+	if cfg.Chain.RewardDecayConstant < 0 {
+		errs = append(errs, errors.New("chain.rewardDecayConstant must not be negative"))
+	}
+	if cfg.Chain.AmountDecimals < 0 || cfg.Chain.AmountDecimals > 18 {
+		errs = append(errs, errors.New("chain.amountDecimals must be between 0 and 18"))
+	}
+	if cfg.Mempool.MaxTransactions <= 0 {
+		errs = append(errs, errors.New("mempool.maxTransactions must be positive"))
+	}
+	if cfg.Mempool.TTL < 0 {
+		errs = append(errs, errors.New("mempool.ttl must not be negative"))
+	}
+	for _, peer := range cfg.P2P.Peers {
+		if !strings.HasPrefix(peer, "ws://") && !strings.HasPrefix(peer, "wss://") {
+			errs = append(errs, fmt.Errorf("p2p.peers entry %q must be a ws:// or wss:// URL", peer))
+		}
+	}
+	if cfg.P2P.RedialInterval <= 0 {
+		errs = append(errs, errors.New("p2p.redialInterval must be positive"))
+	}
+	if url := cfg.P2P.ExternalURL; url != "" && !strings.HasPrefix(url, "ws://") && !strings.HasPrefix(url, "wss://") {
+		errs = append(errs, fmt.Errorf("p2p.externalUrl %q must be a ws:// or wss:// URL", url))
+	}
+	if cfg.P2P.MaxOutbound < len(cfg.P2P.Peers) {
+		errs = append(errs, fmt.Errorf("p2p.maxOutbound must be at least the %d configured peers", len(cfg.P2P.Peers)))
+	}
+	if cfg.P2P.BanDuration <= 0 {
+		errs = append(errs, errors.New("p2p.banDuration must be positive"))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
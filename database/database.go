@@ -1,17 +1,35 @@
 package database
 
 import (
+	"crypto/rsa"
+	"errors"
 	"fmt"
+	"indicartcoin/blockchain"
+	"indicartcoin/config"
+	"indicartcoin/genesis"
+	"indicartcoin/mempool"
+	"indicartcoin/p2p"
 	"indicartcoin/sqldatabase"
 	"indicartcoin/state"
 	"indicartcoin/structs"
-	"math"
-	"math/rand"
-	"sort"
+	"indicartcoin/validator"
+	"log"
+	"strconv"
 	"sync"
+	"time"
 )
 
-const MaxTransactionsPerBlock = 5
+// ChainConfig holds the block size and reward parameters. main replaces the
+// defaults with the loaded node configuration before serving requests.
+var ChainConfig = config.Default().Chain
+
+// NodeKey is the key this node signs with, loaded by main from
+// server.nodeKeyPath.
+var NodeKey *rsa.PrivateKey
+
+// Genesis is the genesis the node was started from, or nil if it was started
+// without a genesis file.
+var Genesis *genesis.Genesis
 
 // Initialize blockchain
 var Blockchain = structs.Blockchain{
@@ -22,137 +40,408 @@ var AppState = &state.State{}
 
 var UserDatabase map[string][]string
 
-// Initialize validators
-var Validators []structs.Validator
+// StateMutex serializes every change to AppState, Mempool and the chain, so
+// a transaction is checked and admitted against the state it will apply to.
+var StateMutex sync.Mutex
 
-var PendingTransactions []structs.Transaction
-
-var Transactions []structs.Transaction
+// Mempool holds the transactions waiting for a block. main sizes it from the
+// node configuration.
+var Mempool = mempool.New(config.Default().Mempool.MaxTransactions, time.Duration(config.Default().Mempool.TTL))
 
 var ArtSummary map[string]structs.ArtOwnershipSummary
 
-func AddTransaction(tx structs.Transaction, blockchain *structs.Blockchain, vals []structs.Validator) {
-	PendingTransactions = append(PendingTransactions, tx)
+// Peers connects the node to other nodes; main sets it. Transactions
+// admitted to the mempool and blocks sealed or received are gossiped to them.
+var Peers *p2p.Node
+
+// PoolChanged receives a value whenever a transaction enters the mempool, so
+// the block producer can seal a full block without waiting for its timer.
+var PoolChanged = make(chan struct{}, 1)
+
+// AddTransaction puts a validated transaction into the mempool. Blocks are
+// built from the pool by SealBlock. Callers hold StateMutex.
+func AddTransaction(tx structs.Transaction) error {
+	if size := mempool.TransactionSize(tx); size > ChainConfig.MaxBlockBytes {
+		return fmt.Errorf("transaction is %d bytes, blocks hold at most %d", size, ChainConfig.MaxBlockBytes)
+	}
+	if receipt, _ := sqldatabase.LoadReceipt(tx.TransactionId); receipt != nil && receipt.Status != structs.Failed {
+		return fmt.Errorf("transaction %s is already in block %d", tx.TransactionId, receipt.BlockIndex)
+	}
+	evicted, err := Mempool.Add(tx, time.Now())
+	if errors.Is(err, mempool.ErrDuplicate) {
+		return fmt.Errorf("%w: %w", p2p.ErrKnown, err)
+	} else if err != nil {
+		return err
+	}
+	for _, dropped := range evicted {
+		log.Printf("Evicting transaction %s from the pool for a higher fee", dropped.TransactionId)
+		dropTransaction(dropped.TransactionId, "evicted from the pool by a higher-fee transaction")
+	}
 	if tx.Type == structs.ArtUpload {
 		sqldatabase.AddArtOwnership(tx.ArtOwnership)
 	}
-	if tx.Type == structs.ArtUpdate {
-		err := sqldatabase.UpdateArtOwnership(tx.ArtOwnership.Id, tx.ArtOwnership)
-		if err != nil {
-			fmt.Println("ArtOwnership Updated Succcessfully")
-		}
-	}
 	//update sql database
 	sqldatabase.AddPendingTransaction(tx)
+	if len(evicted) > 0 {
+		AppState.ResetPendingNonces(Mempool.Transactions())
+	} else {
+		AppState.ReserveNonce(tx)
+	}
+	if Peers != nil {
+		Peers.BroadcastTransaction(tx)
+	}
 
-	if len(PendingTransactions) >= MaxTransactionsPerBlock {
-		newBlock := blockchain.AddBlock(PendingTransactions, vals)
-		//update sql database
-		sqldatabase.AddBlock(newBlock)
-		finalizeValidation(vals, PendingTransactions)
-		finalizeTransaction(PendingTransactions, newBlock)
-		PendingTransactions = sqldatabase.LoadPendingTransactions() // Load New Pending Transactions
+	select {
+	case PoolChanged <- struct{}{}:
+	default:
 	}
+	return nil
 }
 
-func finalizeValidation(vals []structs.Validator, transactions []structs.Transaction) {
-	// Calculate the total fees from all transactions
-	totalFees := 0.0
-	for _, tx := range transactions {
-		totalFees += tx.Fee
+// SubmitTransaction checks a transaction received from a client or a peer
+// against the current state and adds it to the mempool. A bad signature is
+// marked with p2p.Invalid, as only a misbehaving peer relays one; one the node
+// already holds, pooled or mined, wraps p2p.ErrKnown.
+func SubmitTransaction(tx structs.Transaction) error {
+	StateMutex.Lock()
+	defer StateMutex.Unlock()
+
+	// A transaction we already hold would fail the nonce check below; peers
+	// relaying it are only late, not misbehaving.
+	if _, pooled := Mempool.Get(tx.TransactionId); pooled {
+		return fmt.Errorf("%w: %w", p2p.ErrKnown, mempool.ErrDuplicate)
+	}
+	if receipt, _ := sqldatabase.LoadReceipt(tx.TransactionId); receipt != nil && receipt.Status != structs.Failed {
+		return fmt.Errorf("%w: transaction %s is already in block %d", p2p.ErrKnown, tx.TransactionId, receipt.BlockIndex)
+	}
+	if valid, err := AppState.IsValidTransaction(tx); !valid {
+		if errors.Is(err, state.ErrInvalidSignature) {
+			return p2p.Invalid(err)
+		}
+		return err
 	}
+	return AddTransaction(tx)
+}
 
-	// Sort validators by stake and a pinch of randomness
-	sort.Slice(vals, func(i, j int) bool {
-		randomFactorI := 1 + (rand.Float64()-0.5)/10.0
-		randomFactorJ := 1 + (rand.Float64()-0.5)/10.0
-		return float64(vals[i].Stake)*randomFactorI > float64(vals[j].Stake)*randomFactorJ
-	})
+// Height returns the number of blocks on the main chain.
+func Height() int {
+	Blockchain.Mutex.Lock()
+	defer Blockchain.Mutex.Unlock()
+
+	return len(Blockchain.Blocks)
+}
+
+// BlockAt returns the main-chain block at index, the genesis block for 0, or
+// nil if there is none.
+func BlockAt(index int) *structs.Block {
+	Blockchain.Mutex.Lock()
+	defer Blockchain.Mutex.Unlock()
 
-	// Exponential decay parameters
-	decayConstant := 0.5 // You can adjust this value
+	return mainBlock(index)
+}
 
-	// Calculate the normalization constant
-	normalizationConstant := 0.0
-	for i := 0; i < len(vals); i++ {
-		normalizationConstant += math.Exp(-decayConstant * float64(i))
+// BlockRange returns up to count main-chain blocks from index from on, for
+// peers catching up.
+func BlockRange(from, count int) []*structs.Block {
+	Blockchain.Mutex.Lock()
+	defer Blockchain.Mutex.Unlock()
+
+	if from < 1 || count < 1 || from > len(Blockchain.Blocks) {
+		return nil
 	}
+	end := min(from-1+count, len(Blockchain.Blocks))
+	return append([]*structs.Block(nil), Blockchain.Blocks[from-1:end]...)
+}
 
-	// Distribute rewards
-	for i, _ := range vals {
-		// Calculate reward using normalized exponential decay formula
-		portion := math.Exp(-decayConstant*float64(i)) / normalizationConstant
-		reward := totalFees * portion
+// Headers returns the headers of up to count main-chain blocks from index
+// from on.
+func Headers(from, count int) []structs.Block {
+	blocks := BlockRange(from, count)
+	headers := make([]structs.Block, 0, len(blocks))
+	for _, block := range blocks {
+		headers = append(headers, block.Header())
+	}
+	return headers
+}
+
+// BlockFull reports whether the mempool holds enough transactions, by count
+// or by size, to fill a block.
+func BlockFull() bool {
+	return Mempool.Len() >= ChainConfig.MaxTransactionsPerBlock || Mempool.Bytes() >= ChainConfig.MaxBlockBytes
+}
 
-		// Update validator reward
-		AppState.Balances[vals[i].Address] += float64(reward)
+// SealBlock builds the next block from the best transactions in the mempool,
+// within the block's transaction and byte limits, and commits it. Pooled
+// transactions that no longer apply are dropped. If nothing is left to mine
+// a block is only sealed when allowEmpty is set. SealBlock returns the new
+// block, or nil if none was sealed.
+func SealBlock(allowEmpty bool) *structs.Block {
+	StateMutex.Lock()
+	defer StateMutex.Unlock()
 
-		//update sql Database
-		sqldatabase.UpdateBalance(vals[i].Address, float64(reward))
-		sqldatabase.DeleteValidator(vals[i].Address)
+	// Run the best transactions through the state transition first, so the
+	// block only holds transactions that still apply in order.
+	_, applied, rejected := AppState.Transition(Mempool.Select(ChainConfig.MaxTransactionsPerBlock, ChainConfig.MaxBlockBytes))
+	for _, txErr := range rejected {
+		log.Printf("Dropping transaction %s from the pool: %v", txErr.TransactionId, txErr)
+		Mempool.Remove(txErr.TransactionId)
+		dropTransaction(txErr.TransactionId, txErr.Error())
+	}
+	defer AppState.ResetPendingNonces(Mempool.Transactions())
+	if len(applied) == 0 && !allowEmpty {
+		return nil
+	}
+
+	proposer := blockchain.PublicKeyPEM(NodeKey)
+	newBlock, err := Blockchain.AddBlock(applied, proposer, proveBlock, signBlock)
+	if err != nil {
+		log.Println("Error signing block:", err)
+		return nil
+	}
+	commit, previous, err := applyBlock(newBlock)
+
+	// Everything the block changes is written in one database transaction. If
+	// that fails, the node forgets the block too, and its transactions stay
+	// in the pool for the next one.
+	if err == nil {
+		if err = sqldatabase.CommitBlock(*commit); err != nil {
+			restoreState(previous)
+		}
 	}
-	Validators = sqldatabase.LoadValidators()
+	if err != nil {
+		log.Println("Error committing block:", err)
+		Blockchain.RemoveLastBlock(newBlock)
+		return nil
+	}
+	for _, tx := range commit.Transactions {
+		Mempool.Remove(tx.TransactionId)
+	}
+	if Peers != nil {
+		Peers.BroadcastBlock(newBlock)
+	}
+	return newBlock
 }
 
-func finalizeTransaction(transactions []structs.Transaction, block *structs.Block) {
-	for _, tx := range transactions {
-		ApplyTransaction(tx, block)
+// RestoreMempool refills the mempool with the pending transactions kept in the
+// database, e.g. after a restart. Their TTL starts again from now.
+func RestoreMempool(pending []structs.Transaction, now time.Time) {
+	StateMutex.Lock()
+	defer StateMutex.Unlock()
+
+	for _, tx := range pending {
+		if _, err := Mempool.Add(tx, now); err != nil {
+			log.Printf("Dropping stored pending transaction %s: %v", tx.TransactionId, err)
+			dropTransaction(tx.TransactionId, err.Error())
+		}
 	}
+	AppState.ResetPendingNonces(Mempool.Transactions())
 }
 
-func ApplyTransaction(tx structs.Transaction, block *structs.Block) {
+// ExpireTransactions drops transactions whose mempool TTL ran out.
+func ExpireTransactions(now time.Time) {
+	StateMutex.Lock()
+	defer StateMutex.Unlock()
 
-	switch tx.Type {
-	case structs.ArtTransfer:
-		// Transfer ownership of the art
-		artownership, err := sqldatabase.FetchArtOwnershipByArtID(tx.ArtID)
-		if err != nil {
-			fmt.Println("An error occured: ", err.Error())
+	expired := Mempool.Expire(now)
+	for _, tx := range expired {
+		log.Printf("Transaction %s expired in the pool", tx.TransactionId)
+		dropTransaction(tx.TransactionId, "expired in the pool")
+	}
+	if len(expired) > 0 {
+		AppState.ResetPendingNonces(Mempool.Transactions())
+	}
+}
+
+// applyBlock checks that block was sealed in its proposer's turn (see
+// checkTurn), applies its transactions and pays out its fees to the
+// validators staked before it. The changes go to a copy of the state, which
+// replaces AppState's maps; the state from before the block is returned so
+// the caller can put it back if the commit cannot be stored. On error
+// AppState is left as it was.
+func applyBlock(block *structs.Block) (*sqldatabase.BlockCommit, state.State, error) {
+	previous := *AppState
+	vals := AppState.Validators()
+	parent, err := parentOf(block)
+	if err == nil {
+		err = checkTurn(block, parent, vals)
+	}
+	if err != nil {
+		return nil, previous, err
+	}
+	restoreState(*AppState.Snapshot())
+	commit := &sqldatabase.BlockCommit{
+		Block:        block,
+		Balances:     make(map[string]structs.Amount),
+		Nonces:       make(map[string]uint64),
+		ArtOwnership: make(map[string]structs.ArtOwnership),
+		Stakes:       make(map[string]structs.Amount),
+	}
+	if err := finalizeTransaction(block.Transactions, commit); err != nil {
+		restoreState(previous)
+		return nil, previous, err
+	}
+	if len(block.Transactions) > 0 {
+		finalizeValidation(block.Proposer, block.Seed(), vals, block.Transactions, commit)
+	}
+	commit.Undo = blockUndo(commit, previous)
+	return commit, previous, nil
+}
+
+// restoreState makes saved's balances, art ownership, nonces and stakes
+// AppState's.
+func restoreState(saved state.State) {
+	AppState.Balances = saved.Balances
+	AppState.ArtOwnership = saved.ArtOwnership
+	AppState.Nonces = saved.Nonces
+	AppState.Stakes = saved.Stakes
+}
+
+// blockUndo records what commit overwrites, taking the values from previous,
+// the state before the block.
+func blockUndo(commit *sqldatabase.BlockCommit, previous state.State) sqldatabase.BlockUndo {
+	undo := sqldatabase.BlockUndo{
+		Balances:     make(map[string]structs.Amount, len(commit.Balances)),
+		Nonces:       make(map[string]uint64, len(commit.Nonces)),
+		ArtOwnership: make(map[string]structs.ArtOwnership, len(commit.ArtOwnership)),
+	}
+	for address := range commit.Balances {
+		undo.Balances[address] = previous.Balances[address]
+	}
+	for address := range commit.Nonces {
+		undo.Nonces[address] = previous.Nonces[address]
+	}
+	for artID, art := range commit.ArtOwnership {
+		if before, exists := previous.ArtOwnership[artID]; exists {
+			art = before
+		} else {
+			art.Status = structs.Pending
 		}
-		artownership.ArtOwner = tx.To
-		artownership.Status = structs.Confirmed
-		//update sql database
-		sqldatabase.UpdateArtOwnership(tx.ArtID, *artownership)
-
-		AppState.Balances[tx.From] += float64(tx.Amount)
-		//update sql database
-		sqldatabase.UpdateBalance(tx.From, AppState.Balances[tx.From])
-
-		AppState.Balances[tx.To] -= float64(tx.Amount)
-		//update sql database
-		sqldatabase.UpdateBalance(tx.To, AppState.Balances[tx.To])
-	case structs.ArtUpload:
-		//AppState.ArtOwnership = sqldatabase.LoadArtOwnership()
-		artownership, err := sqldatabase.FetchArtOwnershipByArtID(tx.ArtID)
-		if err != nil {
-			fmt.Println("An error occured: ", err.Error())
+		undo.ArtOwnership[artID] = art
+	}
+	if len(commit.Stakes) > 0 {
+		undo.Stakes = make(map[string]structs.Amount, len(commit.Stakes))
+		for address := range commit.Stakes {
+			undo.Stakes[address] = previous.Stakes[address]
 		}
-		artownership.Status = structs.Confirmed
-		//AppState.ArtOwnership[tx.ArtID] = artownership
-		sqldatabase.UpdateArtOwnership(tx.ArtID, *artownership)
-	case structs.ArtUpdate:
-		artownership, err := sqldatabase.FetchArtOwnershipByArtID(tx.ArtID)
-		if err != nil {
-			fmt.Println("An error occured: ", err.Error())
+	}
+	return undo
+}
+
+// signBlock signs a block's header with the node key.
+func signBlock(block *structs.Block) (string, error) {
+	return blockchain.Sign(block.SigningPayload(ChainConfig.ChainID), NodeKey)
+}
+
+// proveBlock makes the node key's VRF proof for a block following parent.
+func proveBlock(block, parent *structs.Block) (string, error) {
+	return blockchain.Prove(block.VRFPayload(ChainConfig.ChainID, parent), NodeKey)
+}
+
+// finalizeValidation pays the block's fees out to the proposer and the
+// validators staked before it (see validator.Rewards).
+func finalizeValidation(proposer string, seed string, vals []structs.Validator, transactions []structs.Transaction, commit *sqldatabase.BlockCommit) {
+	// Calculate the total fees from all transactions
+	totalFees := structs.Amount(0)
+	for _, tx := range transactions {
+		totalFees += tx.Fee
+	}
+
+	// Distribute rewards
+	for _, reward := range validator.Rewards(proposer, seed, vals, totalFees, ChainConfig.RewardDecayConstant) {
+		AppState.Balances[reward.Address] += reward.Amount
+
+		commit.Balances[reward.Address] = AppState.Balances[reward.Address]
+	}
+}
+
+// dropTransaction removes a transaction that will not be mined from the
+// pending table and records a failed receipt saying why.
+func dropTransaction(transactionId string, reason string) {
+	sqldatabase.DeletePendingTransaction(transactionId)
+	sqldatabase.AddReceipt(structs.Receipt{
+		TransactionId: transactionId,
+		Status:        structs.Failed,
+		Error:         reason,
+		RecordedAt:    time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+func finalizeTransaction(transactions []structs.Transaction, commit *sqldatabase.BlockCommit) error {
+	for _, tx := range transactions {
+		if err := ApplyTransaction(tx, commit); err != nil {
+			return err
 		}
-		artownership.Status = structs.Confirmed
-		sqldatabase.UpdateArtOwnership(tx.ArtID, *artownership)
-
-	case structs.CoinTransfer:
-		AppState.Balances[tx.To] += tx.Amount
-		AppState.Balances[tx.From] -= tx.Fee
-		if AppState.Balances[tx.To] != AppState.Balances[tx.From] {
-			AppState.Balances[tx.From] -= tx.Amount
+	}
+	return nil
+}
+
+// ApplyTransaction applies tx to AppState and adds its effects to the block's
+// commit: the rows it changes, its move from the pending pool into the block
+// and its receipt. The transactions were already checked by the state
+// transition in block order, so an error here means the block is unusable.
+func ApplyTransaction(tx structs.Transaction, commit *sqldatabase.BlockCommit) error {
+	changes := touchedValues(tx)
+	if err := AppState.Apply(tx); err != nil {
+		return err
+	}
+	receipt := structs.Receipt{
+		TransactionId: tx.TransactionId,
+		Status:        structs.Completed,
+		BlockIndex:    commit.Block.Index,
+		BlockHash:     commit.Block.Hash,
+		Position:      len(commit.Transactions),
+		RecordedAt:    time.Now().UTC().Format(time.RFC3339),
+	}
+	for i, after := range touchedValues(tx) {
+		if changes[i].After != after.After {
+			receipt.Changes = append(receipt.Changes, structs.StateChange{Kind: after.Kind, Key: after.Key, Before: changes[i].After, After: after.After})
 		}
+	}
 
-		sqldatabase.UpdateBalance(tx.To, AppState.Balances[tx.To])
-		sqldatabase.UpdateBalance(tx.From, AppState.Balances[tx.From])
+	switch tx.Type {
+	case structs.ArtUpload, structs.ArtTransfer, structs.ArtUpdate:
+		commit.ArtOwnership[tx.ArtID] = AppState.ArtOwnership[tx.ArtID]
+	}
+	commit.Balances[tx.From] = AppState.Balances[tx.From]
+	if tx.To != tx.From {
+		commit.Balances[tx.To] = AppState.Balances[tx.To]
 	}
+	if tx.Version >= structs.NoncedTransactionVersion {
+		commit.Nonces[tx.From] = AppState.Nonces[tx.From]
+	}
+	if tx.Type == structs.Stake {
+		commit.Stakes[tx.From] = AppState.Stakes[tx.From]
+	}
+
 	tx.Status = structs.Completed
-	sqldatabase.AddTransaction(tx, block.Index)
-	sqldatabase.DeletePendingTransaction(tx.TransactionId)
+	commit.Transactions = append(commit.Transactions, tx)
+	commit.Receipts = append(commit.Receipts, receipt)
+	return nil
+}
 
-	// Update balances, rewards, etc. (if applicable)
+// touchedValues reads the current value of everything tx can change, always
+// in the same order, as StateChanges with only After set. An uploaded piece
+// only has an owner once its upload is mined.
+func touchedValues(tx structs.Transaction) []structs.StateChange {
+	values := []structs.StateChange{
+		{Kind: structs.BalanceChange, Key: tx.From, After: AppState.Balances[tx.From].String()},
+	}
+	if tx.To != "" && tx.To != tx.From {
+		values = append(values, structs.StateChange{Kind: structs.BalanceChange, Key: tx.To, After: AppState.Balances[tx.To].String()})
+	}
+	if tx.Version >= structs.NoncedTransactionVersion {
+		values = append(values, structs.StateChange{Kind: structs.NonceChange, Key: tx.From, After: strconv.FormatUint(AppState.Nonces[tx.From], 10)})
+	}
+	if tx.Type == structs.Stake {
+		values = append(values, structs.StateChange{Kind: structs.StakeChange, Key: tx.From, After: AppState.Stakes[tx.From].String()})
+	} else if tx.Type != structs.CoinTransfer {
+		owner := ""
+		if art, exists := AppState.ArtOwnership[tx.ArtID]; exists && art.Status != structs.Pending {
+			owner = art.ArtOwner
+		}
+		values = append(values, structs.StateChange{Kind: structs.ArtOwnerChange, Key: tx.ArtID, After: owner})
+	}
+	return values
 }
 
 func CreateBalanceTableEntry(publicKey string) {
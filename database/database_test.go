@@ -0,0 +1,238 @@
+package database
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"indicartcoin/blockchain"
+	"indicartcoin/config"
+	"indicartcoin/forkchoice"
+	"indicartcoin/p2p"
+	"indicartcoin/sqldatabase"
+	"indicartcoin/state"
+	"indicartcoin/structs"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// breakingStore commits every block with its transactions listed twice, so
+// the SQL commit fails at the second copy, after the block row, the first
+// copy and its pending-pool deletion have been written.
+type breakingStore struct {
+	*sqldatabase.SQLStore
+}
+
+func (s breakingStore) CommitBlock(commit sqldatabase.BlockCommit) error {
+	commit.Transactions = append(commit.Transactions, commit.Transactions...)
+	return s.SQLStore.CommitBlock(commit)
+}
+
+func openTestStore(t *testing.T, path string) *sqldatabase.SQLStore {
+	t.Helper()
+	s, err := sqldatabase.OpenSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Migrate(false); err != nil {
+		s.Close()
+		t.Fatal(err)
+	}
+	return s
+}
+
+// startNode resets the package state to an empty chain on store, the way
+// main does at startup, with the stored balances, nonces and pending pool.
+func startNode(t *testing.T, store sqldatabase.Store, key *rsa.PrivateKey) {
+	t.Helper()
+	sqldatabase.UseStore(store)
+	ChainConfig = config.Default().Chain
+	ChainConfig.ChainID = "test"
+	NodeKey = key
+	Peers = nil
+	Blockchain = structs.Blockchain{Mutex: &sync.Mutex{}, Blocks: []*structs.Block{}}
+	SideBlocks = forkchoice.NewTree()
+	AppState = &state.State{
+		Balances:     sqldatabase.LoadBalances(),
+		Nonces:       sqldatabase.LoadNonces(),
+		ArtOwnership: map[string]structs.ArtOwnership{},
+		Stakes:       map[string]structs.Amount{},
+		ChainID:      ChainConfig.ChainID,
+	}
+	Mempool.Remove(transactionIds(Mempool.Transactions())...)
+	RestoreMempool(sqldatabase.LoadPendingTransactions(), time.Now())
+}
+
+func transactionIds(txs []structs.Transaction) []string {
+	ids := make([]string, 0, len(txs))
+	for _, tx := range txs {
+		ids = append(ids, tx.TransactionId)
+	}
+	return ids
+}
+
+// sealedBy builds the block after parent the way another node with key would
+// seal it, stamped at.
+func sealedBy(t *testing.T, key *rsa.PrivateKey, parent *structs.Block, txs []structs.Transaction, at time.Time) *structs.Block {
+	t.Helper()
+	block := &structs.Block{
+		Version:        structs.VRFBlockVersion,
+		Proposer:       blockchain.PublicKeyPEM(key),
+		Index:          1,
+		TimestampNanos: at.UnixNano(),
+		Transactions:   txs,
+		MerkleRoot:     structs.ComputeMerkleRoot(txs),
+	}
+	if parent != nil {
+		block.Index = parent.Index + 1
+		block.PrevHash = parent.Hash
+	}
+	var err error
+	if block.VRFProof, err = blockchain.Prove(block.VRFPayload(ChainConfig.ChainID, parent), key); err != nil {
+		t.Fatal(err)
+	}
+	block.Hash = block.CalculateHash()
+	if block.Signature, err = blockchain.Sign(block.SigningPayload(ChainConfig.ChainID), key); err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+func TestSealBlockCommitFailureLeavesNothing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chain.db")
+	nodeKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	senderKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender := blockchain.PublicKeyPEM(senderKey)
+
+	store := openTestStore(t, path)
+	store.UpdateBalance(sender, 10)
+	startNode(t, breakingStore{store}, nodeKey)
+
+	tx := structs.Transaction{
+		TransactionId: "tx-1",
+		Type:          structs.CoinTransfer,
+		From:          sender,
+		To:            "bob",
+		Amount:        4,
+		Fee:           1,
+		Version:       structs.NoncedTransactionVersion,
+	}
+	if tx.Signature, err = blockchain.Sign(tx.SignedMessage("test"), senderKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := SubmitTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+
+	if block := SealBlock(false); block != nil {
+		t.Fatalf("sealed block %d although its commit failed", block.Index)
+	}
+	if Height() != 0 {
+		t.Errorf("height %d after the failed commit, want 0", Height())
+	}
+	if AppState.Balances[sender] != 10 || AppState.Balances["bob"] != 0 || AppState.Nonces[sender] != 0 {
+		t.Errorf("state moved on after the failed commit: balances %v, nonces %v", AppState.Balances, AppState.Nonces)
+	}
+	if _, pooled := Mempool.Get(tx.TransactionId); !pooled {
+		t.Error("transaction left the pool after the failed commit")
+	}
+
+	// Restart on the same file with a working store: nothing of the block
+	// was kept, and it can be sealed again.
+	store.Close()
+	store = openTestStore(t, path)
+	defer func() { store.Close() }()
+	startNode(t, store, nodeKey)
+	if blocks, _ := sqldatabase.LoadAllBlocks(); len(blocks) != 0 {
+		t.Errorf("stored blocks %v after the failed commit, want none", blocks)
+	}
+	if receipt, _ := sqldatabase.LoadReceipt(tx.TransactionId); receipt != nil {
+		t.Errorf("receipt %v after the failed commit, want none", receipt)
+	}
+	if balances := sqldatabase.LoadBalances(); len(balances) != 1 || balances[sender] != 10 {
+		t.Errorf("stored balances %v after the failed commit, want only the sender's 10", balances)
+	}
+	if nonces := sqldatabase.LoadNonces(); len(nonces) != 0 {
+		t.Errorf("stored nonces %v after the failed commit, want none", nonces)
+	}
+	if pending := sqldatabase.LoadPendingTransactions(); len(pending) != 1 {
+		t.Errorf("stored pending pool %v after the failed commit, want tx-1", pending)
+	}
+
+	block := SealBlock(false)
+	if block == nil {
+		t.Fatal("no block sealed on the working store")
+	}
+	store.Close()
+	store = openTestStore(t, path)
+	startNode(t, store, nodeKey)
+	if blocks, _ := sqldatabase.LoadAllBlocks(); len(blocks) != 1 || blocks[0].Hash != block.Hash {
+		t.Errorf("stored blocks %v, want block %s", blocks, block.Hash)
+	}
+	if receipt, _ := sqldatabase.LoadReceipt(tx.TransactionId); receipt == nil || receipt.BlockHash != block.Hash {
+		t.Errorf("receipt %v, want one for block %s", receipt, block.Hash)
+	}
+	proposer := blockchain.PublicKeyPEM(nodeKey)
+	if balances := sqldatabase.LoadBalances(); balances[sender] != 5 || balances["bob"] != 4 || balances[proposer] != 1 {
+		t.Errorf("stored balances %v, want sender 5, bob 4 and the proposer 1", balances)
+	}
+	if nonces := sqldatabase.LoadNonces(); nonces[sender] != 1 {
+		t.Errorf("stored nonces %v, want the sender's 1", nonces)
+	}
+	if pending := sqldatabase.LoadPendingTransactions(); len(pending) != 0 {
+		t.Errorf("stored pending pool %v, want it empty", pending)
+	}
+}
+
+// TestEarlyOutOfTurnBlockRefused has a proposer that is not a validator, and
+// so has its turn a block interval after the tip, stamp its block with a time
+// in that turn and send it right away. The stamp is too far ahead of the
+// node's clock; stamped now instead, the block is out of turn.
+func TestEarlyOutOfTurnBlockRefused(t *testing.T) {
+	nodeKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := openTestStore(t, filepath.Join(t.TempDir(), "chain.db"))
+	defer store.Close()
+	startNode(t, store, nodeKey)
+	ChainConfig.BlockInterval = config.Duration(time.Hour)
+	AppState.Stakes["validator"] = 10
+
+	tip := SealBlock(true)
+	if tip == nil {
+		t.Fatal("no block sealed")
+	}
+	sealed, _ := tip.Time()
+
+	early := sealedBy(t, otherKey, tip, nil, sealed.Add(time.Hour))
+	err = ReceiveBlock(early)
+	if !errors.Is(err, ErrFutureBlock) {
+		t.Fatalf("block stamped in its turn an hour ahead: got error %v, want ErrFutureBlock", err)
+	}
+	if p2p.IsInvalid(err) {
+		t.Error("a block from the future marks its sender as misbehaving, but clocks differ")
+	}
+	if SideBlocks.Get(early.Hash) != nil || Height() != 1 {
+		t.Errorf("block from the future was kept: height %d", Height())
+	}
+
+	now := sealedBy(t, otherKey, tip, nil, time.Now())
+	if err := ReceiveBlock(now); !errors.Is(err, ErrOutOfTurn) || !p2p.IsInvalid(err) {
+		t.Fatalf("block stamped before its turn: got error %v, want an invalid ErrOutOfTurn", err)
+	}
+	if Height() != 1 {
+		t.Errorf("height %d after the out-of-turn block, want 1", Height())
+	}
+}
@@ -0,0 +1,239 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"indicartcoin/blockchain"
+	"indicartcoin/forkchoice"
+	"indicartcoin/p2p"
+	"indicartcoin/sqldatabase"
+	"indicartcoin/structs"
+	"log"
+	"time"
+)
+
+// SideBlocks holds the valid blocks that are not on the main chain, so a
+// branch can be adopted as soon as it outweighs the main chain.
+var SideBlocks = forkchoice.NewTree()
+
+// ErrUnknownParent is returned by ReceiveBlock for a block whose parent the
+// node does not have. The parent has to be received first.
+var ErrUnknownParent = errors.New("unknown parent block")
+
+// ErrKnownBlock is returned by ReceiveBlock for a block the node already has,
+// on the main chain or a side branch.
+var ErrKnownBlock = fmt.Errorf("block %w", p2p.ErrKnown)
+
+// ReceiveBlock takes a block sealed by another node. The block is checked
+// against its parent with blockchain.VerifyBlock and kept in SideBlocks. If
+// the branch it ends is heavier than the main chain after the block they
+// share (see forkchoice.Heavier), the node switches to it: the main-chain
+// blocks past that point are reverted, the branch is applied, and both are
+// stored in one database transaction. Transactions that only the reverted
+// blocks held go back to the mempool. A block extending the tip is the
+// simplest such branch. New valid blocks are gossiped to Peers. Errors for
+// blocks that fail verification, or branches that do not apply, are marked
+// with p2p.Invalid so the peer that sent them is banned. Blocks stamped too
+// far ahead of the node's clock are refused (see CheckClock).
+func ReceiveBlock(block *structs.Block) error {
+	if err := ImportBlock(block); err != nil {
+		return err
+	}
+	if Peers != nil {
+		Peers.BroadcastBlock(block)
+	}
+	return nil
+}
+
+// ImportBlock is ReceiveBlock without gossiping the block, for blocks
+// downloaded from a peer while catching up.
+func ImportBlock(block *structs.Block) error {
+	StateMutex.Lock()
+	defer StateMutex.Unlock()
+
+	if SideBlocks.Get(block.Hash) != nil {
+		return ErrKnownBlock
+	}
+	if main := mainBlock(block.Index); main != nil && main.Hash == block.Hash {
+		return ErrKnownBlock
+	}
+	if err := CheckClock(block, time.Now()); err != nil {
+		return err
+	}
+	parent, err := parentOf(block)
+	if err != nil {
+		return err
+	}
+	if err := blockchain.VerifyBlock(block, parent, ChainConfig.ChainID); err != nil {
+		return p2p.Invalid(err)
+	}
+	height := len(Blockchain.Blocks)
+	if block.Index <= height-ChainConfig.MaxReorgDepth {
+		return fmt.Errorf("block %d is more than %d blocks below the tip at %d", block.Index, ChainConfig.MaxReorgDepth, height)
+	}
+	SideBlocks.Prune(height - ChainConfig.MaxReorgDepth)
+	SideBlocks.Add(block)
+
+	branch := SideBlocks.Branch(block)
+	fork := branch[0].Index - 1
+	if base := mainBlock(fork); (base == nil && branch[0].PrevHash != "") || (base != nil && base.Hash != branch[0].PrevHash) {
+		// The branch hangs off a block that was pruned.
+		return nil
+	}
+	if forkchoice.Heavier(branch, Blockchain.Blocks[fork:], validatorStakes()) {
+		if depth := height - fork; depth > ChainConfig.MaxReorgDepth {
+			return fmt.Errorf("switching to block %d would revert %d blocks, at most %d may be", block.Index, depth, ChainConfig.MaxReorgDepth)
+		}
+		if err := switchBranch(fork, branch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mainBlock returns the main-chain block at index, the genesis block for 0,
+// or nil if there is none.
+func mainBlock(index int) *structs.Block {
+	if index == 0 {
+		return Blockchain.Genesis
+	}
+	if index < 1 || index > len(Blockchain.Blocks) {
+		return nil
+	}
+	return Blockchain.Blocks[index-1]
+}
+
+// parentOf finds block's parent on the main chain or in SideBlocks. The first
+// block of a chain without a genesis block has no parent and gets nil.
+func parentOf(block *structs.Block) (*structs.Block, error) {
+	if block.Index == 1 && Blockchain.Genesis == nil && block.PrevHash == "" {
+		return nil, nil
+	}
+	if parent := mainBlock(block.Index - 1); parent != nil && parent.Hash == block.PrevHash {
+		return parent, nil
+	}
+	if parent := SideBlocks.Get(block.PrevHash); parent != nil && parent.Index == block.Index-1 {
+		return parent, nil
+	}
+	return nil, fmt.Errorf("%w %s for block %d", ErrUnknownParent, block.PrevHash, block.Index)
+}
+
+// validatorStakes returns the stake of every validator at the tip by address.
+// Callers hold StateMutex.
+func validatorStakes() map[string]structs.Amount {
+	stakes := make(map[string]structs.Amount, len(AppState.Stakes))
+	for address, stake := range AppState.Stakes {
+		stakes[address] = stake
+	}
+	return stakes
+}
+
+// switchBranch replaces the main-chain blocks after fork with branch, whose
+// first block follows block fork. Each replaced block is reverted from its
+// undo record, newest first, then the branch is applied block by block. If a
+// branch block does not apply it is dropped with its descendants and nothing
+// changes. Callers hold StateMutex.
+func switchBranch(fork int, branch []*structs.Block) error {
+	reverted := Blockchain.Blocks[fork:]
+	start := *AppState
+	restoreState(*AppState.Snapshot())
+
+	// Walking back newest first, the last value written for a key is the one
+	// from before the oldest reverted block that changed it.
+	restore := sqldatabase.BlockUndo{
+		Balances:     make(map[string]structs.Amount),
+		Nonces:       make(map[string]uint64),
+		ArtOwnership: make(map[string]structs.ArtOwnership),
+		Stakes:       make(map[string]structs.Amount),
+	}
+	for i := len(reverted) - 1; i >= 0; i-- {
+		undo, err := sqldatabase.LoadBlockUndo(reverted[i].Index)
+		if err == nil && undo == nil {
+			err = errors.New("block has no undo record")
+		}
+		if err != nil {
+			restoreState(start)
+			return fmt.Errorf("reverting block %d: %v", reverted[i].Index, err)
+		}
+		for address, balance := range undo.Balances {
+			AppState.Balances[address] = balance
+			restore.Balances[address] = balance
+		}
+		for address, nonce := range undo.Nonces {
+			AppState.Nonces[address] = nonce
+			restore.Nonces[address] = nonce
+		}
+		for artID, art := range undo.ArtOwnership {
+			AppState.ArtOwnership[artID] = art
+			restore.ArtOwnership[artID] = art
+		}
+		for address, stake := range undo.Stakes {
+			AppState.Stakes[address] = stake
+			restore.Stakes[address] = stake
+		}
+	}
+
+	commits := make([]sqldatabase.BlockCommit, 0, len(branch))
+	included := make(map[string]bool)
+	for _, block := range branch {
+		commit, _, err := applyBlock(block)
+		if err != nil {
+			restoreState(start)
+			SideBlocks.RemoveDescendants(block.Hash)
+			return p2p.Invalid(fmt.Errorf("applying block %d: %w", block.Index, err))
+		}
+		commits = append(commits, *commit)
+		for _, tx := range commit.Transactions {
+			included[tx.TransactionId] = true
+		}
+	}
+
+	var orphaned []structs.Transaction
+	for _, block := range reverted {
+		for _, tx := range block.Transactions {
+			if !included[tx.TransactionId] {
+				tx.Status = structs.Pending
+				orphaned = append(orphaned, tx)
+			}
+		}
+	}
+
+	err := sqldatabase.CommitReorg(sqldatabase.Reorg{ForkIndex: fork, Restore: restore, Orphaned: orphaned, Commits: commits})
+	if err != nil {
+		restoreState(start)
+		return err
+	}
+
+	Blockchain.Mutex.Lock()
+	Blockchain.Blocks = append(Blockchain.Blocks[:fork:fork], branch...)
+	Blockchain.Mutex.Unlock()
+	for _, block := range branch {
+		SideBlocks.Remove(block.Hash)
+	}
+	for _, block := range reverted {
+		SideBlocks.Add(block)
+	}
+	SideBlocks.Prune(len(Blockchain.Blocks) - ChainConfig.MaxReorgDepth)
+
+	for txId := range included {
+		Mempool.Remove(txId)
+	}
+	now := time.Now()
+	for _, tx := range orphaned {
+		evicted, err := Mempool.Add(tx, now)
+		if err != nil {
+			log.Printf("Dropping orphaned transaction %s: %v", tx.TransactionId, err)
+			dropTransaction(tx.TransactionId, err.Error())
+		}
+		for _, dropped := range evicted {
+			log.Printf("Evicting transaction %s from the pool for an orphaned one", dropped.TransactionId)
+			dropTransaction(dropped.TransactionId, "evicted from the pool by a higher-fee transaction")
+		}
+	}
+	AppState.ResetPendingNonces(Mempool.Transactions())
+
+	if len(reverted) > 0 {
+		log.Printf("Switched to block %d %s: reverted %d blocks, applied %d, %d transactions back in the pool", branch[len(branch)-1].Index, branch[len(branch)-1].Hash, len(reverted), len(branch), len(orphaned))
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package database
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"indicartcoin/blockchain"
+	"indicartcoin/sqldatabase"
+	"indicartcoin/structs"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestReorgToHeavierBranch seals a block with one sender's transfer, then
+// receives a longer branch from the same parent with another sender's
+// transfer. The node must switch: the first transfer is undone and goes back
+// to the pool, the branch's is applied, and storage agrees with memory.
+func TestReorgToHeavierBranch(t *testing.T) {
+	keys := make([]*rsa.PrivateKey, 4)
+	for i := range keys {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[i] = key
+	}
+	nodeKey, otherKey, aliceKey, daveKey := keys[0], keys[1], keys[2], keys[3]
+	node, other := blockchain.PublicKeyPEM(nodeKey), blockchain.PublicKeyPEM(otherKey)
+	alice, dave := blockchain.PublicKeyPEM(aliceKey), blockchain.PublicKeyPEM(daveKey)
+
+	store := openTestStore(t, filepath.Join(t.TempDir(), "chain.db"))
+	defer store.Close()
+	store.UpdateBalance(alice, 100)
+	store.UpdateBalance(dave, 50)
+	startNode(t, store, nodeKey)
+
+	signed := func(tx structs.Transaction, key *rsa.PrivateKey) structs.Transaction {
+		tx.Type = structs.CoinTransfer
+		tx.Version = structs.NoncedTransactionVersion
+		var err error
+		if tx.Signature, err = blockchain.Sign(tx.SignedMessage("test"), key); err != nil {
+			t.Fatal(err)
+		}
+		return tx
+	}
+	toBob := signed(structs.Transaction{TransactionId: "to-bob", From: alice, To: "bob", Amount: 5, Fee: 1}, aliceKey)
+	toCarol := signed(structs.Transaction{TransactionId: "to-carol", From: dave, To: "carol", Amount: 7, Fee: 2}, daveKey)
+
+	if err := SubmitTransaction(toBob); err != nil {
+		t.Fatal(err)
+	}
+	mined := SealBlock(false)
+	if mined == nil {
+		t.Fatal("no block sealed")
+	}
+	if AppState.Balances["bob"] != 5 || AppState.Nonces[alice] != 1 {
+		t.Fatalf("state after the main block: balances %v, nonces %v", AppState.Balances, AppState.Nonces)
+	}
+
+	// The branch forks at the genesis. Its first block only ties with the
+	// main chain, so the node stays; the second makes it heavier.
+	first := sealedBy(t, otherKey, nil, []structs.Transaction{toCarol}, time.Now())
+	if err := ReceiveBlock(first); err != nil {
+		t.Fatal(err)
+	}
+	if tip := BlockAt(1); tip.Hash != mined.Hash {
+		t.Fatalf("switched to the branch at a tie: tip %s", tip.Hash)
+	}
+	second := sealedBy(t, otherKey, first, nil, time.Now())
+	if err := ReceiveBlock(second); err != nil {
+		t.Fatal(err)
+	}
+
+	if Height() != 2 || BlockAt(1).Hash != first.Hash || BlockAt(2).Hash != second.Hash {
+		t.Fatalf("main chain after the reorg is %d blocks, block 1 %s, want the branch", Height(), BlockAt(1).Hash)
+	}
+	if SideBlocks.Get(mined.Hash) == nil {
+		t.Error("reverted block is not kept as a side block")
+	}
+
+	wantBalances := map[string]structs.Amount{alice: 100, "bob": 0, dave: 41, "carol": 7, other: 2, node: 0}
+	stored := sqldatabase.LoadBalances()
+	for address, want := range wantBalances {
+		if AppState.Balances[address] != want {
+			t.Errorf("balance of %.40q is %s after the reorg, want %s", address, AppState.Balances[address], want)
+		}
+		if stored[address] != want {
+			t.Errorf("stored balance of %.40q is %s after the reorg, want %s", address, stored[address], want)
+		}
+	}
+	wantNonces := map[string]uint64{alice: 0, dave: 1}
+	storedNonces := sqldatabase.LoadNonces()
+	for address, want := range wantNonces {
+		if AppState.Nonces[address] != want || storedNonces[address] != want {
+			t.Errorf("nonce of %.40q is %d, stored %d after the reorg, want %d", address, AppState.Nonces[address], storedNonces[address], want)
+		}
+	}
+
+	// The reverted transfer waits for the next block again; the branch's is
+	// mined and has a receipt for its new block.
+	if _, pooled := Mempool.Get(toBob.TransactionId); !pooled {
+		t.Error("transaction of the reverted block is not back in the pool")
+	}
+	if pending := sqldatabase.LoadPendingTransactions(); len(pending) != 1 || pending[0].TransactionId != toBob.TransactionId {
+		t.Errorf("stored pending pool %v after the reorg, want only %s", pending, toBob.TransactionId)
+	}
+	if AppState.NextNonce(alice) != 1 {
+		t.Errorf("next nonce for alice is %d with her transfer pooled again, want 1", AppState.NextNonce(alice))
+	}
+	if receipt, _ := sqldatabase.LoadReceipt(toCarol.TransactionId); receipt == nil || receipt.BlockHash != first.Hash {
+		t.Errorf("receipt %v for the branch's transfer, want one for block %s", receipt, first.Hash)
+	}
+	if receipt, _ := sqldatabase.LoadReceipt(toBob.TransactionId); receipt != nil && receipt.BlockHash == mined.Hash {
+		t.Errorf("receipt %v still points at the reverted block", receipt)
+	}
+
+	// The pooled transfer is mined on top of the branch.
+	if block := SealBlock(false); block == nil || block.Index != 3 {
+		t.Fatalf("sealed %v after the reorg, want block 3", block)
+	}
+	if AppState.Balances["bob"] != 5 || AppState.Nonces[alice] != 1 {
+		t.Errorf("state after mining the pooled transfer: balances %v, nonces %v", AppState.Balances, AppState.Nonces)
+	}
+}
@@ -0,0 +1,135 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"indicartcoin/blockchain"
+	"indicartcoin/structs"
+	"indicartcoin/validator"
+	"time"
+)
+
+// ProposerTurn is one validator's place in line to propose the next block.
+type ProposerTurn struct {
+	Address   string         `json:"address"`
+	Stake     structs.Amount `json:"stake"`
+	Turn      int            `json:"turn"`      // 0 for the selected proposer
+	NotBefore time.Time      `json:"notBefore"` // when the validator may seal if those before it did not
+}
+
+// Proposers describes who may seal the block after the tip.
+type Proposers struct {
+	Index     int            `json:"index"` // of the next block
+	Seed      string         `json:"seed"`  // the tip's seed, see structs.Block.Seed
+	Proposers []ProposerTurn `json:"proposers"`
+	// NodeTurn is this node's place in line; the number of validators if the
+	// node is not one, as anyone may seal once every validator had its turn.
+	NodeTurn      int       `json:"nodeTurn"`
+	NodeNotBefore time.Time `json:"nodeNotBefore"`
+}
+
+// ErrOutOfTurn is returned for a block sealed before its proposer's turn.
+var ErrOutOfTurn = errors.New("proposer out of turn")
+
+// ErrFutureBlock is returned for a block stamped further ahead of this node's
+// clock than chain.maxClockDrift allows.
+var ErrFutureBlock = errors.New("block timestamp in the future")
+
+// NextProposers ranks the validators staked at the tip for the block after it
+// with validator.Rank. Validator n in line may seal it n block intervals
+// after the tip was sealed, so the selected proposer goes first and the
+// chain moves on if it is offline.
+func NextProposers() Proposers {
+	Blockchain.Mutex.Lock()
+	tip := mainBlock(len(Blockchain.Blocks))
+	Blockchain.Mutex.Unlock()
+	StateMutex.Lock()
+	vals := AppState.Validators()
+	StateMutex.Unlock()
+
+	next := Proposers{Index: 1, Proposers: []ProposerTurn{}}
+	if tip != nil {
+		next.Index = tip.Index + 1
+		next.Seed = tip.Seed()
+	}
+	address := ""
+	if NodeKey != nil {
+		address = blockchain.PublicKeyPEM(NodeKey)
+	}
+	ranked := validator.Rank(vals, next.Seed)
+	next.NodeTurn = len(ranked)
+	for turn, val := range ranked {
+		next.Proposers = append(next.Proposers, ProposerTurn{Address: val.Address, Stake: val.Stake, Turn: turn, NotBefore: notBefore(tip, turn)})
+		if val.Address == address {
+			next.NodeTurn = turn
+		}
+	}
+	next.NodeNotBefore = notBefore(tip, next.NodeTurn)
+	return next
+}
+
+// notBefore is when the proposer in place turn may seal the block after
+// parent: turn block intervals after parent was sealed. It is the zero time
+// if parent has no timestamp.
+func notBefore(parent *structs.Block, turn int) time.Time {
+	if parent == nil {
+		return time.Time{}
+	}
+	parentTime, ok := parent.Time()
+	if !ok {
+		return time.Time{}
+	}
+	return parentTime.Add(time.Duration(turn) * time.Duration(ChainConfig.BlockInterval))
+}
+
+// checkTurn makes sure block, which follows parent, was sealed no earlier
+// than its proposer's turn among vals, the validators staked at parent. An
+// address without stake has its turn after every validator. Blocks before
+// structs.VRFBlockVersion have no seed to rank by and are not checked.
+func checkTurn(block, parent *structs.Block, vals []structs.Validator) error {
+	if block.Version < structs.VRFBlockVersion {
+		return nil
+	}
+	seed := ""
+	if parent != nil {
+		seed = parent.Seed()
+	}
+	ranked := validator.Rank(vals, seed)
+	turn := len(ranked)
+	for i, val := range ranked {
+		if val.Address == block.Proposer {
+			turn = i
+			break
+		}
+	}
+	if turn == 0 {
+		return nil
+	}
+	sealed, _ := block.Time()
+	if earliest := notBefore(parent, turn); sealed.Before(earliest) {
+		return fmt.Errorf("%w: block %d sealed at %s by proposer %d in line, whose turn starts at %s", ErrOutOfTurn, block.Index, sealed.UTC().Format(time.RFC3339Nano), turn, earliest.UTC().Format(time.RFC3339Nano))
+	}
+	return nil
+}
+
+// CheckClock makes sure block was not stamped more than chain.maxClockDrift
+// after now. Without it a proposer could stamp a block with a time in its turn
+// and send it out before that turn came. Nodes' clocks differ, so the error
+// does not mark the sender as misbehaving; the block can be received again
+// once its time has come.
+func CheckClock(block *structs.Block, now time.Time) error {
+	sealed, ok := block.Time()
+	if !ok {
+		return nil
+	}
+	if latest := now.Add(time.Duration(ChainConfig.MaxClockDrift)); sealed.After(latest) {
+		return fmt.Errorf("%w: block %d sealed at %s, %s ahead of this node's clock", ErrFutureBlock, block.Index, sealed.UTC().Format(time.RFC3339Nano), sealed.Sub(now))
+	}
+	return nil
+}
+
+// MayPropose reports whether it is this node's turn to seal the next block
+// at now (see NextProposers).
+func MayPropose(now time.Time) bool {
+	return !now.Before(NextProposers().NodeNotBefore)
+}
@@ -0,0 +1,125 @@
+package forkchoice
+
+import (
+	"indicartcoin/structs"
+	"math/big"
+	"sync"
+)
+
+// Tree holds valid blocks that are not on the main chain, keyed by hash:
+// competing blocks received from other nodes and main-chain blocks a reorg
+// replaced. Each block's parent is either on the main chain or in the tree,
+// so any block in it leads back to the main chain through Branch.
+type Tree struct {
+	mu     sync.Mutex
+	blocks map[string]*structs.Block
+}
+
+// NewTree returns an empty Tree.
+func NewTree() *Tree {
+	return &Tree{blocks: make(map[string]*structs.Block)}
+}
+
+// Add stores block, replacing any block with the same hash.
+func (t *Tree) Add(block *structs.Block) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.blocks[block.Hash] = block
+}
+
+// Get returns the block with the given hash, or nil.
+func (t *Tree) Get(hash string) *structs.Block {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.blocks[hash]
+}
+
+// Remove forgets the block with the given hash.
+func (t *Tree) Remove(hash string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.blocks, hash)
+}
+
+// RemoveDescendants forgets the block with the given hash and every block
+// built on it, for example once it turned out to be invalid.
+func (t *Tree) RemoveDescendants(hash string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	removed := map[string]bool{hash: true}
+	delete(t.blocks, hash)
+	for found := true; found; {
+		found = false
+		for blockHash, block := range t.blocks {
+			if removed[block.PrevHash] {
+				removed[blockHash] = true
+				delete(t.blocks, blockHash)
+				found = true
+			}
+		}
+	}
+}
+
+// Prune forgets every block at or below index. Branches forking that deep
+// could not be adopted any more.
+func (t *Tree) Prune(index int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for hash, block := range t.blocks {
+		if block.Index <= index {
+			delete(t.blocks, hash)
+		}
+	}
+}
+
+// Len returns the number of blocks in the tree.
+func (t *Tree) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return len(t.blocks)
+}
+
+// Branch follows parent links from tip through the tree and returns the
+// blocks, oldest first, ending with tip. The first block's parent is not in
+// the tree; it is where the branch leaves the main chain.
+func (t *Tree) Branch(tip *structs.Block) []*structs.Block {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	branch := []*structs.Block{tip}
+	for {
+		parent, exists := t.blocks[branch[0].PrevHash]
+		if !exists || parent.Index != branch[0].Index-1 {
+			return branch
+		}
+		branch = append([]*structs.Block{parent}, branch...)
+	}
+}
+
+// Weight is the fork-choice weight of a run of blocks: each block counts one
+// plus the stake of its proposer in stakes, in units. Blocks without a
+// registered proposer, including every block before SignedBlockVersion,
+// count one, so between them the longer run is heavier.
+func Weight(blocks []*structs.Block, stakes map[string]structs.Amount) *big.Int {
+	weight := new(big.Int)
+	for _, block := range blocks {
+		weight.Add(weight, big.NewInt(1))
+		if stake := stakes[block.Proposer]; block.Proposer != "" && stake > 0 {
+			weight.Add(weight, big.NewInt(int64(stake)))
+		}
+	}
+	return weight
+}
+
+// Heavier reports whether branch should replace main, where both continue
+// the same block. The heavier one wins; on a tie the main chain stays, so a
+// node does not switch back and forth between equal branches.
+func Heavier(branch, main []*structs.Block, stakes map[string]structs.Amount) bool {
+	return Weight(branch, stakes).Cmp(Weight(main, stakes)) > 0
+}
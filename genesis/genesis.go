@@ -0,0 +1,287 @@
+package genesis
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"indicartcoin/config"
+	"indicartcoin/sqldatabase"
+	"indicartcoin/structs"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Genesis defines a network: its chain ID, the coins and validators it starts
+// with, and the consensus parameters every node on it must share. Its Hash is
+// the hash of the genesis block and identifies the network.
+type Genesis struct {
+	ChainID     string       `json:"chainId"`
+	GenesisTime time.Time    `json:"genesisTime"` // RFC 3339; the genesis block's timestamp
+	Params      Params       `json:"params"`
+	Allocations []Allocation `json:"allocations"`
+	Validators  []Validator  `json:"validators"`
+}
+
+// Params are the consensus parameters. They replace the matching chain
+// settings of the node configuration.
+type Params struct {
+	MaxTransactionsPerBlock int             `json:"maxTransactionsPerBlock"`
+	MaxBlockBytes           int             `json:"maxBlockBytes"`
+	BlockInterval           config.Duration `json:"blockInterval"`
+	RewardDecayConstant     float64         `json:"rewardDecayConstant"`
+	AmountDecimals          int             `json:"amountDecimals"`
+}
+
+// Allocation is a balance that exists from the start.
+type Allocation struct {
+	Address string         `json:"address"`
+	Balance structs.Amount `json:"balance"`
+}
+
+// Validator is a validator that exists from the start.
+type Validator struct {
+	Address string         `json:"address"`
+	Stake   structs.Amount `json:"stake"`
+}
+
+// Load reads and checks the genesis file at path. Amounts in the file are in
+// coins of params.amountDecimals, so Load sets structs.AmountDecimals to that
+// before parsing them.
+func Load(path string) (*Genesis, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading genesis file: %v", err)
+	}
+
+	var header struct {
+		Params Params `json:"params"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, fmt.Errorf("parsing genesis file %s: %v", path, err)
+	}
+	if header.Params.AmountDecimals < 0 || header.Params.AmountDecimals > 18 {
+		return nil, errors.New("genesis params.amountDecimals must be between 0 and 18")
+	}
+	structs.AmountDecimals = header.Params.AmountDecimals
+
+	var g Genesis
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&g); err != nil {
+		return nil, fmt.Errorf("parsing genesis file %s: %v", path, err)
+	}
+	if err := g.Validate(); err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+// Validate reports every problem with the genesis at once. The parameters
+// themselves are checked with the rest of the configuration once Apply has
+// copied them.
+func (g *Genesis) Validate() error {
+	var errs []error
+
+	if g.ChainID == "" || strings.ContainsRune(g.ChainID, 0) {
+		errs = append(errs, errors.New("chainId must be a non-empty string without NUL bytes"))
+	}
+	if g.GenesisTime.IsZero() {
+		errs = append(errs, errors.New("genesisTime is required"))
+	}
+
+	var total structs.Amount
+	allocated := make(map[string]bool)
+	for _, allocation := range g.Allocations {
+		if allocation.Address == "" {
+			errs = append(errs, errors.New("allocation without an address"))
+			continue
+		}
+		if allocated[allocation.Address] {
+			errs = append(errs, fmt.Errorf("address allocated twice: %s", allocation.Address))
+		}
+		allocated[allocation.Address] = true
+		if allocation.Balance < 0 {
+			errs = append(errs, fmt.Errorf("negative allocation to %s", allocation.Address))
+		} else if total > math.MaxInt64-allocation.Balance {
+			errs = append(errs, errors.New("allocations overflow the total supply"))
+		} else {
+			total += allocation.Balance
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, val := range g.Validators {
+		if val.Address == "" {
+			errs = append(errs, errors.New("validator without an address"))
+			continue
+		}
+		if seen[val.Address] {
+			errs = append(errs, fmt.Errorf("validator listed twice: %s", val.Address))
+		}
+		seen[val.Address] = true
+		if val.Stake <= 0 {
+			errs = append(errs, fmt.Errorf("validator %s must have a positive stake", val.Address))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid genesis: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// Apply replaces the chain ID and consensus parameters in chain with the
+// genesis values.
+func (g *Genesis) Apply(chain *config.ChainConfig) {
+	chain.ChainID = g.ChainID
+	chain.MaxTransactionsPerBlock = g.Params.MaxTransactionsPerBlock
+	chain.MaxBlockBytes = g.Params.MaxBlockBytes
+	chain.BlockInterval = g.Params.BlockInterval
+	chain.RewardDecayConstant = g.Params.RewardDecayConstant
+	chain.AmountDecimals = g.Params.AmountDecimals
+}
+
+// canonicalGenesis is what the genesis hash covers. Amounts are integer units
+// and lists are sorted by address, so the same genesis always encodes to the
+// same bytes however the file was written.
+type canonicalGenesis struct {
+	ChainID     string                `json:"chainId"`
+	GenesisTime int64                 `json:"genesisTime"` // Unix nanoseconds
+	Params      canonicalParams       `json:"params"`
+	Allocations []canonicalAllocation `json:"allocations"`
+	Validators  []canonicalAllocation `json:"validators"`
+}
+
+type canonicalParams struct {
+	MaxTransactionsPerBlock int    `json:"maxTransactionsPerBlock"`
+	MaxBlockBytes           int    `json:"maxBlockBytes"`
+	BlockInterval           int64  `json:"blockInterval"` // nanoseconds
+	RewardDecayConstant     string `json:"rewardDecayConstant"`
+	AmountDecimals          int    `json:"amountDecimals"`
+}
+
+type canonicalAllocation struct {
+	Address string `json:"address"`
+	Units   int64  `json:"units"`
+}
+
+// Canonical returns the canonical JSON encoding of the genesis.
+func (g *Genesis) Canonical() []byte {
+	c := canonicalGenesis{
+		ChainID:     g.ChainID,
+		GenesisTime: g.GenesisTime.UnixNano(),
+		Params: canonicalParams{
+			MaxTransactionsPerBlock: g.Params.MaxTransactionsPerBlock,
+			MaxBlockBytes:           g.Params.MaxBlockBytes,
+			BlockInterval:           int64(g.Params.BlockInterval),
+			RewardDecayConstant:     fmt.Sprintf("%g", g.Params.RewardDecayConstant),
+			AmountDecimals:          g.Params.AmountDecimals,
+		},
+		Allocations: []canonicalAllocation{},
+		Validators:  []canonicalAllocation{},
+	}
+	for _, allocation := range g.Allocations {
+		c.Allocations = append(c.Allocations, canonicalAllocation{allocation.Address, int64(allocation.Balance)})
+	}
+	for _, val := range g.Validators {
+		c.Validators = append(c.Validators, canonicalAllocation{val.Address, int64(val.Stake)})
+	}
+	sort.Slice(c.Allocations, func(i, j int) bool { return c.Allocations[i].Address < c.Allocations[j].Address })
+	sort.Slice(c.Validators, func(i, j int) bool { return c.Validators[i].Address < c.Validators[j].Address })
+
+	// Nothing in canonicalGenesis can fail to encode.
+	encoded, _ := json.Marshal(c)
+	return encoded
+}
+
+// Hash returns the hex SHA-256 of the canonical encoding.
+func (g *Genesis) Hash() string {
+	sum := sha256.Sum256(g.Canonical())
+	return hex.EncodeToString(sum[:])
+}
+
+// Block returns the genesis block: index 0, no parent and no transactions,
+// timestamped at GenesisTime. Block 1 links to its Hash and must be sealed
+// after GenesisTime.
+func (g *Genesis) Block() *structs.Block {
+	return &structs.Block{
+		Version:        structs.HeaderBlockVersion,
+		Index:          0,
+		TimestampNanos: g.GenesisTime.UnixNano(),
+		Hash:           g.Hash(),
+	}
+}
+
+// Balances returns the allocations as a balance map.
+func (g *Genesis) Balances() map[string]structs.Amount {
+	balances := make(map[string]structs.Amount, len(g.Allocations))
+	for _, allocation := range g.Allocations {
+		balances[allocation.Address] = allocation.Balance
+	}
+	return balances
+}
+
+// Stakes returns the validators' stakes by address.
+func (g *Genesis) Stakes() map[string]structs.Amount {
+	stakes := make(map[string]structs.Amount, len(g.Validators))
+	for _, val := range g.Validators {
+		stakes[val.Address] = val.Stake
+	}
+	return stakes
+}
+
+// Initialize makes sure storage belongs to this genesis. An empty database is
+// initialized with the allocations and validators; one initialized from
+// another genesis, or holding blocks from before genesis files, is refused.
+func (g *Genesis) Initialize() error {
+	hash := g.Hash()
+	stored, err := sqldatabase.LoadGenesisHash()
+	if err != nil {
+		return fmt.Errorf("reading stored genesis: %v", err)
+	}
+	if stored == hash {
+		return nil
+	}
+	if stored != "" {
+		return fmt.Errorf("database belongs to genesis %s, not %s", stored, hash)
+	}
+
+	blocks, err := sqldatabase.LoadBlocks(nil)
+	if err != nil {
+		return fmt.Errorf("loading blocks: %v", err)
+	}
+	if len(blocks) > 0 {
+		return errors.New("database holds blocks from a chain started without a genesis file")
+	}
+
+	validators := make([]structs.Validator, 0, len(g.Validators))
+	for _, val := range g.Validators {
+		validators = append(validators, structs.Validator{Address: val.Address, Stake: val.Stake})
+	}
+	return sqldatabase.CommitGenesis(sqldatabase.GenesisCommit{
+		Hash:       hash,
+		ChainID:    g.ChainID,
+		Document:   string(g.Canonical()),
+		Balances:   g.Balances(),
+		Validators: validators,
+	})
+}
+
+// CheckUnused returns an error if storage was initialized from a genesis, for
+// nodes started without a genesis file.
+func CheckUnused() error {
+	stored, err := sqldatabase.LoadGenesisHash()
+	if err != nil {
+		return fmt.Errorf("reading stored genesis: %v", err)
+	}
+	if stored != "" {
+		return fmt.Errorf("database belongs to genesis %s; set chain.genesisPath", stored)
+	}
+	return nil
+}
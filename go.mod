@@ -5,3 +5,5 @@ go 1.21.1
 require github.com/gorilla/websocket v1.5.0
 
 require github.com/go-sql-driver/mysql v1.7.1
+
+require github.com/mattn/go-sqlite3 v1.14.22
@@ -1,53 +1,73 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"indicartcoin/blockchain"
+	"indicartcoin/chainsync"
+	"indicartcoin/config"
 	"indicartcoin/database"
+	"indicartcoin/genesis"
 	"indicartcoin/network"
+	"indicartcoin/p2p"
+	"indicartcoin/producer"
+	"indicartcoin/reindex"
 	"indicartcoin/sqldatabase"
 	"indicartcoin/structs"
 	"indicartcoin/usercreator"
 	"log"
 	"net/http"
-	"strconv"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
-// Define a struct for the response message
-type ResponseMessage struct {
-	Success bool   `json:"success"`
-	Message string `json:"message"`
-}
-
-// Define a struct for the incoming request body
-type UpdateArtOwnershipRequest struct {
-	ArtID        string               `json:"artID"`
-	ArtOwnership structs.ArtOwnership `json:"artOwnership"`
-}
-
-func updateArtOwnershipHandler(w http.ResponseWriter, r *http.Request) {
-	// Parse the request body
-	var req UpdateArtOwnershipRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
+// loadChain reads the chain and the state derived from it at startup. From
+// then on the in-memory copies are authoritative: blocks and state change only
+// under database.StateMutex, as blocks are sealed, imported or reorganized,
+// and every change is written through to storage.
+func loadChain() {
+	blocks, err := sqldatabase.LoadAllBlocks()
 	if err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
+		log.Fatalf("Failed to load blocks: %s", err.Error())
 	}
 
-	// Call the UpdateArtOwnership function from the sqldatabase package
-	sqldatabase.UpdateArtOwnership(req.ArtID, req.ArtOwnership)
+	// Fetch balances
+	balances := sqldatabase.LoadBalances()
+	// Fetch nonces
+	nonces := sqldatabase.LoadNonces()
+	// Fetch art ownership
+	artOwnership := sqldatabase.LoadArtOwnership()
 
-	// Create a success response
-	resp := ResponseMessage{
-		Success: true,
-		Message: "Art ownership updated successfully",
+	// Fetch validator stakes
+	stakes := make(map[string]structs.Amount)
+	for _, val := range sqldatabase.LoadValidators() {
+		stakes[val.Address] = val.Stake
 	}
 
-	// Send the response back
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
+	database.StateMutex.Lock()
+	database.AppState.Stakes = stakes
+	if balances != nil {
+		database.AppState.Balances = balances
+	}
+	if nonces != nil {
+		database.AppState.Nonces = nonces
+	}
+	if artOwnership != nil {
+		database.AppState.ArtOwnership = artOwnership
+	}
+	database.Blockchain.Mutex.Lock()
+	database.Blockchain.Blocks = blocks
+	database.Blockchain.Mutex.Unlock()
+	database.StateMutex.Unlock()
 }
+
+// fetchData refreshes the data that is not derived from the chain: users and
+// the art summary page.
 func fetchData() {
 	//Fetch users
 	//fmt.Println("fetching user..")
@@ -57,23 +77,6 @@ func fetchData() {
 	}
 	//fmt.Println("fetched data..")
 
-	//fmt.Println("fetchin balances..")
-	// Fetch balances
-	balances := sqldatabase.LoadBalances()
-	if balances != nil {
-		database.AppState.Balances = balances
-	}
-	//fmt.Println("balances fetched..")
-
-	//fmt.Println("fetching art ownership..")
-	// Fetch art ownership
-	//artOwnership := sqldatabase.LoadArtOwnership()
-	//if artOwnership != nil {
-	//	database.AppState.ArtOwnership = artOwnership
-	//}
-	//fmt.Println("ownership fetched..")
-	//
-
 	//fmt.Println("fetching art summary..")
 	artSummary := sqldatabase.LoadArtOwnershipSummary(0, 20)
 	if artSummary != nil {
@@ -81,69 +84,171 @@ func fetchData() {
 	}
 
 	//fmt.Println("summary fetched..")
-	// Fetch validators
+	// Pending transactions live in database.Mempool and are only read from
+	// storage at startup. Confirmed ones are queried on demand through
+	// sqldatabase.SearchTransactions.
+}
 
-	//fmt.Println("fetching Validators..")
-	validators := sqldatabase.LoadValidators()
-	if validators != nil {
-		database.Validators = validators
+func main() {
+	configPath := flag.String("config", "", "path to a JSON node configuration file")
+	migrateDryRun := flag.Bool("migrate-dry-run", false, "print pending schema migrations and exit without applying them")
+	reindexChain := flag.Bool("reindex", false, "rebuild balances, nonces, stakes, art ownership and likes by replaying the chain, report what changed and exit")
+	reindexDryRun := flag.Bool("reindex-dry-run", false, "like -reindex, but only report the differences without writing them")
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %s", err.Error())
+		return
 	}
-	//fmt.Println("validators fetched..")
-	// Fetch pending transactions
-	//fmt.Println("fetching pending transactions..")
-	pendingTransactions := sqldatabase.LoadPendingTransactions()
-	if pendingTransactions != nil {
-		database.PendingTransactions = pendingTransactions
+	var gen *genesis.Genesis
+	if cfg.Chain.GenesisPath != "" {
+		gen, err = genesis.Load(cfg.Chain.GenesisPath)
+		if err != nil {
+			log.Fatalf("Failed to load genesis: %s", err.Error())
+		}
+		gen.Apply(&cfg.Chain)
+		if err := cfg.Validate(); err != nil {
+			log.Fatalf("Invalid genesis parameters: %s", err.Error())
+		}
 	}
-	//fmt.Println("Pending Transactions fetched..")
-	Transactions := sqldatabase.LoadTransactions()
-	if pendingTransactions != nil {
-		database.Transactions = Transactions
+	database.ChainConfig = cfg.Chain
+	database.AppState.ChainID = cfg.Chain.ChainID
+	structs.AmountDecimals = cfg.Chain.AmountDecimals
+
+	sqldatabase.MigrationDryRun = *migrateDryRun
+	err = sqldatabase.InitDatabase(cfg.Storage)
+	if err != nil {
+		log.Fatalf("Failed to initialize database: %s", err.Error())
+		return
 	}
-	//fmt.Println("Transaction fetched..")
-	for _, Tx := range Transactions {
-		Tx.ArtOwnership = database.AppState.ArtOwnership[Tx.ArtID]
+	if *migrateDryRun {
+		sqldatabase.CloseDatabase()
+		return
 	}
-	// Fetch blocks
-	Blocks, err := sqldatabase.LoadBlocks(nil)
-	if err == nil && Blocks != nil {
-		database.Blockchain.Blocks = Blocks
+	defer sqldatabase.CloseDatabase()
+
+	if gen != nil {
+		if err := gen.Initialize(); err != nil {
+			log.Fatalf("Failed to initialize genesis: %s", err.Error())
+		}
+		database.Genesis = gen
+		database.Blockchain.Genesis = gen.Block()
+		fmt.Printf("Genesis %s, chain %s\n", gen.Hash(), gen.ChainID)
+	} else if err := genesis.CheckUnused(); err != nil {
+		log.Fatalf("Failed to start: %s", err.Error())
 	}
 
-}
+	if *reindexChain || *reindexDryRun {
+		var allocations, stakes map[string]structs.Amount
+		if gen != nil {
+			allocations, stakes = gen.Balances(), gen.Stakes()
+		}
+		report, err := reindex.Replay(cfg.Chain, allocations, stakes)
+		if err != nil {
+			log.Fatalf("Failed to replay the chain: %s", err.Error())
+		}
+		report.Print(os.Stdout)
+		if *reindexChain {
+			if err := report.Write(); err != nil {
+				log.Fatalf("Failed to write the reindexed state: %s", err.Error())
+			}
+			fmt.Println("Derived tables rebuilt from the chain")
+		}
+		return
+	}
 
-func main() {
-	err := sqldatabase.InitDatabase()
+	if cfg.Chain.VerifyOnStartup {
+		blocks, err := sqldatabase.LoadAllBlocks()
+		if err != nil {
+			log.Fatalf("Failed to load blocks for verification: %s", err.Error())
+		}
+		if err := blockchain.VerifyChain(blocks, database.Blockchain.Genesis, cfg.Chain.ChainID); err != nil {
+			log.Fatalf("Stored chain failed verification: %s", err.Error())
+		}
+		fmt.Printf("Verified %d stored blocks\n", len(blocks))
+	}
+	nodeKey, err := blockchain.LoadOrCreateNodeKey(cfg.Server.NodeKeyPath)
 	if err != nil {
-		log.Fatalf("Failed to initialize database: %s", err.Error())
-		return
+		log.Fatalf("Failed to load node key: %s", err.Error())
 	}
-	defer sqldatabase.CloseDatabase()
+	database.NodeKey = nodeKey
+
 	fmt.Println("fetching data..")
+	loadChain()
 	fetchData()
+	database.Mempool.Configure(cfg.Mempool.MaxTransactions, time.Duration(cfg.Mempool.TTL))
+	database.RestoreMempool(sqldatabase.LoadPendingTransactions(), time.Now())
 	fmt.Println("data fetche data..")
 	// Fetch data at regular intervals
-	timeInterval := 1 * time.Second // 10 seconds
-	ticker := time.NewTicker(timeInterval)
+	ticker := time.NewTicker(time.Duration(cfg.Server.FetchInterval))
 	go func() {
 		for {
 			select {
 			case <-ticker.C:
 				fetchData()
+				database.ExpireTransactions(time.Now())
 			}
 		}
 	}()
 
+	// Other nodes connect on /p2p; the ones in p2p.peers are dialed once the
+	// server is up, and further ones from the stored peer book up to
+	// p2p.maxOutbound. Blocks are only sealed once the node has caught up with
+	// them.
+	genesisHash := ""
+	if gen != nil {
+		genesisHash = gen.Hash()
+	}
+	syncer := chainsync.New(len(cfg.P2P.Peers) > 0)
+	peers := p2p.NewNode(cfg.Chain.ChainID, genesisHash, p2p.Options{
+		ListenURL:   cfg.P2P.ExternalURL,
+		MaxOutbound: cfg.P2P.MaxOutbound,
+		BanDuration: time.Duration(cfg.P2P.BanDuration),
+		SavePeer:    sqldatabase.SavePeer,
+		DeletePeer:  sqldatabase.DeletePeer,
+		SaveBan:     sqldatabase.SaveBan,
+		DeleteBan:   sqldatabase.DeleteBan,
+	}, p2p.Handlers{
+		Height:      database.Height,
+		Transaction: database.SubmitTransaction,
+		Block:       syncer.ReceiveBlock,
+		Headers:     database.Headers,
+		Blocks:      database.BlockRange,
+	})
+	peers.RestoreBook(sqldatabase.LoadPeers(), sqldatabase.LoadBans())
+	database.Peers = peers
+	syncer.Start(peers)
+
+	// The node seals only once it has caught up and its turn has come.
+	blockProducer := producer.Start(time.Duration(cfg.Chain.BlockInterval), cfg.Chain.ProduceEmptyBlocks, func() bool {
+		return syncer.Syncing() || !database.MayPropose(time.Now())
+	})
+
 	// Rest of your code
 	// ...
 
 	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-		network.HandleConnections(w, r, database.AppState, database.Validators, &database.Blockchain)
+		network.HandleConnections(w, r, database.AppState)
 	})
+	http.Handle("/p2p", peers)
+	http.HandleFunc("/p2p/peers", network.PeersHandler)
+	http.HandleFunc("/p2p/book", network.PeerBookHandler)
+	http.HandleFunc("/sync/status", network.SyncStatusHandler(syncer))
+	http.HandleFunc("/consensus/proposers", network.ProposersHandler)
 	http.HandleFunc("/signup", usercreator.SignupHandler)
 	http.HandleFunc("/login", usercreator.LoginHandler)
 	http.HandleFunc("/get_blockchain", network.GetBlockchainHandler)
 	http.HandleFunc("/get_art_summary", network.GetArtSummaryHandler)
+	http.HandleFunc("/chain/verify", network.VerifyChainHandler)
+	http.HandleFunc("/genesis", network.GenesisHandler)
+	http.HandleFunc("/block/tx_proof", network.TransactionProofHandler)
+	http.HandleFunc("/block/submit", network.SubmitBlockHandler)
+	http.HandleFunc("/account/nonce", network.NextNonceHandler)
+	http.HandleFunc("/mempool", network.MempoolHandler)
+	http.HandleFunc("/tx/", network.TransactionHandler)
+	http.HandleFunc("/transactions", network.TransactionSearchHandler)
+	http.HandleFunc("/account/history", network.AccountHistoryHandler)
 
 	// New HTTP handler to get the current app state
 	http.HandleFunc("/get_app_state", func(w http.ResponseWriter, r *http.Request) {
@@ -161,7 +266,8 @@ func main() {
 		//fmt.Println(validators)
 	})
 
-	http.HandleFunc("/updateArtOwnership", updateArtOwnershipHandler)
+	http.HandleFunc("/art/provenance", network.ProvenanceHandler)
+	http.HandleFunc("/art/provenance/certificate", network.ProvenanceCertificateHandler)
 
 	http.HandleFunc("/art/like", network.LikeArtHandler)
 
@@ -244,48 +350,44 @@ func main() {
 		w.Write(jsonData)
 	})
 
+	// Stake is only taken from the chain, so every node ranks the same
+	// validators; an endpoint writing it locally would split them.
 	http.HandleFunc("/validator/signup", func(w http.ResponseWriter, r *http.Request) {
-		var response ValidatorSignupResponse
-
-		address := r.URL.Query().Get("address")
-		stakeStr := r.URL.Query().Get("stake")
-		stake, err := strconv.Atoi(stakeStr)
-		if err != nil {
-			response = ValidatorSignupResponse{
-				Success: false,
-				Message: "Invalid stake",
-			}
-			jsonResponse, _ := json.Marshal(response)
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write(jsonResponse)
-			return
-		}
-
-		newValidator := structs.Validator{
-			Address: address,
-			Stake:   float64(stake),
-		}
-		database.Validators = append(database.Validators, newValidator)
-		sqldatabase.AddValidator(newValidator)
-
-		response = ValidatorSignupResponse{
-			Success: true,
-			Message: "Validator signup successful",
+		response := ValidatorSignupResponse{
+			Success: false,
+			Message: "Validators sign up on-chain: submit a signed Stake transaction (type 4) over /ws",
 		}
 		jsonResponse, _ := json.Marshal(response)
 		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusGone)
 		w.Write(jsonResponse)
 	})
 
+	server := &http.Server{Addr: cfg.Server.ListenAddr}
 	go func() {
-		err := http.ListenAndServe(":8080", nil)
-		if err != nil {
+		err := server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Fatalf("Failed to start server: %s", err.Error())
 		}
 	}()
 
-	fmt.Println("Server started at :8080")
-
-	select {}
+	fmt.Println("Server started at", cfg.Server.ListenAddr)
+	peers.KeepConnected(cfg.P2P.Peers, time.Duration(cfg.P2P.RedialInterval))
+
+	// Shut down on SIGINT or SIGTERM: stop taking transactions, let the
+	// producer finish the block it is sealing, then close the database.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	fmt.Println("shutting down..")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	peers.Close()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Println("Error shutting down server:", err)
+	}
+	syncer.Stop()
+	blockProducer.Stop()
+	ticker.Stop()
 }
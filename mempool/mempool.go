@@ -0,0 +1,296 @@
+package mempool
+
+import (
+	"container/heap"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"indicartcoin/structs"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrDuplicate = errors.New("transaction already in the pool")
+	ErrPoolFull  = errors.New("pool is full")
+)
+
+// Entry is a transaction waiting in the pool.
+type Entry struct {
+	Transaction structs.Transaction `json:"transaction"`
+	ReceivedAt  time.Time           `json:"receivedAt"`
+	ExpiresAt   time.Time           `json:"expiresAt"`
+	Size        int                 `json:"size"` // bytes, see TransactionSize
+	seq         uint64              // arrival order, breaks fee ties
+}
+
+// TransactionSize is the number of bytes tx takes up in a block: the length
+// of its JSON encoding, as sent over /ws and stored in /get_blockchain.
+func TransactionSize(tx structs.Transaction) int {
+	data, err := json.Marshal(tx)
+	if err != nil {
+		return 0
+	}
+	return len(data)
+}
+
+// Pool holds pending transactions ordered by fee. Transactions from one
+// sender always leave the pool in nonce order, so a block never holds a
+// sender's nonce n+1 without nonce n. The pool is safe for concurrent use.
+type Pool struct {
+	mu       sync.Mutex
+	maxSize  int
+	ttl      time.Duration
+	byID     map[string]*Entry
+	bySender map[string][]*Entry // sorted by nonce, then arrival
+	bytes    int
+	seq      uint64
+}
+
+// New returns an empty pool holding at most maxSize transactions, each for at
+// most ttl (no expiry if ttl is 0).
+func New(maxSize int, ttl time.Duration) *Pool {
+	return &Pool{
+		maxSize:  maxSize,
+		ttl:      ttl,
+		byID:     make(map[string]*Entry),
+		bySender: make(map[string][]*Entry),
+	}
+}
+
+// Configure changes the size limit and TTL. A smaller limit takes effect on
+// the next Add; entries already in the pool keep their expiry time.
+func (p *Pool) Configure(maxSize int, ttl time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.maxSize = maxSize
+	p.ttl = ttl
+}
+
+// higherPriority reports whether a should be mined before b when both are
+// free to go: higher fee first, then earlier arrival.
+func higherPriority(a, b *Entry) bool {
+	if a.Transaction.Fee != b.Transaction.Fee {
+		return a.Transaction.Fee > b.Transaction.Fee
+	}
+	return a.seq < b.seq
+}
+
+// Add puts tx into the pool. It fails with ErrDuplicate if a transaction with
+// the same TransactionId is already there. When the pool is full the
+// lowest-fee transaction that no other pooled transaction depends on is
+// evicted to make room, provided tx pays a higher fee; otherwise Add fails
+// with ErrPoolFull. Evicted transactions are returned.
+func (p *Pool) Add(tx structs.Transaction, now time.Time) ([]structs.Transaction, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, exists := p.byID[tx.TransactionId]; exists {
+		return nil, ErrDuplicate
+	}
+
+	var evicted []structs.Transaction
+	if p.maxSize > 0 && len(p.byID) >= p.maxSize {
+		victim := p.evictionCandidate(tx.From)
+		if victim == nil {
+			return nil, ErrPoolFull
+		}
+		if victim.Transaction.Fee >= tx.Fee {
+			return nil, fmt.Errorf("%w: fee must be above %s", ErrPoolFull, victim.Transaction.Fee)
+		}
+		p.remove(victim)
+		evicted = append(evicted, victim.Transaction)
+	}
+
+	p.seq++
+	entry := &Entry{Transaction: tx, ReceivedAt: now, Size: TransactionSize(tx), seq: p.seq}
+	if p.ttl > 0 {
+		entry.ExpiresAt = now.Add(p.ttl)
+	}
+	p.byID[tx.TransactionId] = entry
+	p.bytes += entry.Size
+	queue := append(p.bySender[tx.From], entry)
+	sort.SliceStable(queue, func(i, j int) bool { return queue[i].Transaction.Nonce < queue[j].Transaction.Nonce })
+	p.bySender[tx.From] = queue
+	return evicted, nil
+}
+
+// evictionCandidate returns the cheapest transaction among the last ones of
+// every sender, so evicting it never leaves a nonce gap behind. The incoming
+// transaction's sender is skipped, since their new transaction may follow
+// their last pooled one.
+func (p *Pool) evictionCandidate(incomingSender string) *Entry {
+	var victim *Entry
+	for sender, queue := range p.bySender {
+		if sender == incomingSender {
+			continue
+		}
+		last := queue[len(queue)-1]
+		if victim == nil || higherPriority(victim, last) {
+			victim = last
+		}
+	}
+	return victim
+}
+
+func (p *Pool) remove(entry *Entry) {
+	delete(p.byID, entry.Transaction.TransactionId)
+	p.bytes -= entry.Size
+	queue := p.bySender[entry.Transaction.From]
+	for i, queued := range queue {
+		if queued == entry {
+			queue = append(queue[:i], queue[i+1:]...)
+			break
+		}
+	}
+	if len(queue) == 0 {
+		delete(p.bySender, entry.Transaction.From)
+	} else {
+		p.bySender[entry.Transaction.From] = queue
+	}
+}
+
+// Remove drops the transactions with the given IDs, for example once they
+// are in a block. Unknown IDs are ignored.
+func (p *Pool) Remove(transactionIds ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, id := range transactionIds {
+		if entry, exists := p.byID[id]; exists {
+			p.remove(entry)
+		}
+	}
+}
+
+// Expire drops every transaction whose TTL ran out at now, together with the
+// later-nonce transactions of the same sender that can no longer be mined
+// without it, and returns them.
+func (p *Pool) Expire(now time.Time) []structs.Transaction {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var expired []structs.Transaction
+	for sender, queue := range p.bySender {
+		for i, entry := range queue {
+			if entry.ExpiresAt.IsZero() || now.Before(entry.ExpiresAt) {
+				continue
+			}
+			for _, dropped := range queue[i:] {
+				delete(p.byID, dropped.Transaction.TransactionId)
+				p.bytes -= dropped.Size
+				expired = append(expired, dropped.Transaction)
+			}
+			if i == 0 {
+				delete(p.bySender, sender)
+			} else {
+				p.bySender[sender] = queue[:i]
+			}
+			break
+		}
+	}
+	return expired
+}
+
+// Get returns the pooled entry with the given TransactionId.
+func (p *Pool) Get(transactionId string) (Entry, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	entry, exists := p.byID[transactionId]
+	if !exists {
+		return Entry{}, false
+	}
+	return *entry, true
+}
+
+// Len returns the number of pooled transactions.
+func (p *Pool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.byID)
+}
+
+// Bytes returns the total size of the pooled transactions.
+func (p *Pool) Bytes() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.bytes
+}
+
+// MaxSize returns the configured size limit.
+func (p *Pool) MaxSize() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.maxSize
+}
+
+// Select returns the transactions for the next block in the order it should
+// hold them: repeatedly the highest-priority transaction that is next in line
+// for its sender, stopping before maxCount transactions or maxBytes bytes
+// would be exceeded. A limit of 0 means no limit. The pool is not changed.
+func (p *Pool) Select(maxCount int, maxBytes int) []structs.Transaction {
+	var txs []structs.Transaction
+	size := 0
+	for _, entry := range p.Entries() {
+		if maxCount > 0 && len(txs) == maxCount {
+			break
+		}
+		if maxBytes > 0 && size+entry.Size > maxBytes {
+			break
+		}
+		size += entry.Size
+		txs = append(txs, entry.Transaction)
+	}
+	return txs
+}
+
+// Entries returns every pooled entry in Select order.
+func (p *Pool) Entries() []Entry {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	heads := &senderHeap{}
+	for _, queue := range p.bySender {
+		heads.queues = append(heads.queues, queue)
+	}
+	heap.Init(heads)
+
+	entries := make([]Entry, 0, len(p.byID))
+	for heads.Len() > 0 {
+		queue := heads.queues[0]
+		entries = append(entries, *queue[0])
+		if len(queue) == 1 {
+			heap.Pop(heads)
+		} else {
+			heads.queues[0] = queue[1:]
+			heap.Fix(heads, 0)
+		}
+	}
+	return entries
+}
+
+// Transactions returns the pooled transactions in Select order.
+func (p *Pool) Transactions() []structs.Transaction {
+	return p.Select(0, 0)
+}
+
+// senderHeap orders per-sender queues by the priority of their first entry.
+type senderHeap struct {
+	queues [][]*Entry
+}
+
+func (h *senderHeap) Len() int           { return len(h.queues) }
+func (h *senderHeap) Less(i, j int) bool { return higherPriority(h.queues[i][0], h.queues[j][0]) }
+func (h *senderHeap) Swap(i, j int)      { h.queues[i], h.queues[j] = h.queues[j], h.queues[i] }
+func (h *senderHeap) Push(x interface{}) { h.queues = append(h.queues, x.([]*Entry)) }
+func (h *senderHeap) Pop() interface{} {
+	last := h.queues[len(h.queues)-1]
+	h.queues = h.queues[:len(h.queues)-1]
+	return last
+}
@@ -0,0 +1,179 @@
+package mempool
+
+import (
+	"errors"
+	"indicartcoin/structs"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// pending builds a transaction to pool, with only the fields the pool reads
+// set.
+func pending(id, from string, nonce uint64, fee structs.Amount) structs.Transaction {
+	return structs.Transaction{TransactionId: id, From: from, To: "bob", Amount: 1, Fee: fee, Nonce: nonce, Version: structs.NoncedTransactionVersion}
+}
+
+// add puts txs into p one second apart, failing the test on any error.
+func add(t *testing.T, p *Pool, txs ...structs.Transaction) {
+	t.Helper()
+	for i, tx := range txs {
+		if _, err := p.Add(tx, start.Add(time.Duration(i)*time.Second)); err != nil {
+			t.Fatalf("adding %s: %v", tx.TransactionId, err)
+		}
+	}
+}
+
+// pooled returns the IDs in the pool, sorted.
+func pooled(p *Pool) []string {
+	var ids []string
+	for _, tx := range p.Transactions() {
+		ids = append(ids, tx.TransactionId)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// ids returns the IDs of txs in order.
+func ids(txs []structs.Transaction) []string {
+	var ids []string
+	for _, tx := range txs {
+		ids = append(ids, tx.TransactionId)
+	}
+	return ids
+}
+
+func TestFullPoolReplacesOnlyForAHigherFee(t *testing.T) {
+	p := New(2, 0)
+	add(t, p, pending("a", "alice", 0, 5), pending("b", "bob", 0, 1))
+
+	if _, err := p.Add(pending("a", "alice", 0, 9), start); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("same ID again: got error %v, want ErrDuplicate", err)
+	}
+	for _, fee := range []structs.Amount{0, 1} {
+		evicted, err := p.Add(pending("c", "carol", 0, fee), start)
+		if !errors.Is(err, ErrPoolFull) || evicted != nil {
+			t.Errorf("fee %s against a cheapest fee of 1: evicted %v, error %v, want ErrPoolFull", fee, ids(evicted), err)
+		}
+	}
+	if got, want := pooled(p), []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("pool %v after refused additions, want %v", got, want)
+	}
+
+	evicted, err := p.Add(pending("c", "carol", 0, 2), start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(evicted); !reflect.DeepEqual(got, []string{"b"}) {
+		t.Errorf("evicted %v, want the cheapest, b", got)
+	}
+	if got, want := pooled(p), []string{"a", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("pool %v, want %v", got, want)
+	}
+	if p.Bytes() != TransactionSize(pending("a", "alice", 0, 5))+TransactionSize(pending("c", "carol", 0, 2)) {
+		t.Errorf("pool size %d bytes does not match its transactions", p.Bytes())
+	}
+}
+
+func TestEvictionOrder(t *testing.T) {
+	// Only a sender's last transaction can go, so alice's cheap nonce 0 is
+	// safe behind her expensive nonce 1.
+	p := New(3, 0)
+	add(t, p, pending("alice-0", "alice", 0, 1), pending("alice-1", "alice", 1, 10), pending("bob-0", "bob", 0, 5))
+	evicted, err := p.Add(pending("carol-0", "carol", 0, 6), start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(evicted); !reflect.DeepEqual(got, []string{"bob-0"}) {
+		t.Errorf("evicted %v, want bob-0, the cheapest last transaction of a sender", got)
+	}
+	evicted, err = p.Add(pending("dave-0", "dave", 0, 20), start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(evicted); !reflect.DeepEqual(got, []string{"carol-0"}) {
+		t.Errorf("evicted %v, want carol-0, not alice-0 with alice-1 behind it", got)
+	}
+	evicted, err = p.Add(pending("erin-0", "erin", 0, 11), start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(evicted); !reflect.DeepEqual(got, []string{"alice-1"}) {
+		t.Errorf("evicted %v, want alice-1, the last of alice's transactions", got)
+	}
+
+	// Among equal fees the later arrival goes first.
+	p = New(2, 0)
+	add(t, p, pending("early", "alice", 0, 3), pending("late", "bob", 0, 3))
+	if evicted, err := p.Add(pending("new", "carol", 0, 4), start); err != nil || !reflect.DeepEqual(ids(evicted), []string{"late"}) {
+		t.Errorf("evicted %v, error %v, want the later arrival", ids(evicted), err)
+	}
+
+	// The incoming sender's own transactions are never evicted for it, as the
+	// new one may follow them.
+	p = New(2, 0)
+	add(t, p, pending("alice-0", "alice", 0, 1), pending("alice-1", "alice", 1, 1))
+	if _, err := p.Add(pending("alice-2", "alice", 2, 50), start); !errors.Is(err, ErrPoolFull) {
+		t.Errorf("pool full of the sender's own transactions: got error %v, want ErrPoolFull", err)
+	}
+}
+
+func TestSelectOrder(t *testing.T) {
+	p := New(0, 0)
+	add(t, p,
+		pending("alice-1", "alice", 1, 9),
+		pending("bob-0", "bob", 0, 5),
+		pending("alice-0", "alice", 0, 1),
+		pending("carol-0", "carol", 0, 5),
+	)
+	// alice-1 pays the most but waits for alice-0; bob-0 came before carol-0.
+	want := []string{"bob-0", "carol-0", "alice-0", "alice-1"}
+	if got := ids(p.Select(0, 0)); !reflect.DeepEqual(got, want) {
+		t.Errorf("selected %v, want %v", got, want)
+	}
+	if got := ids(p.Select(2, 0)); !reflect.DeepEqual(got, want[:2]) {
+		t.Errorf("selected %v with a limit of 2, want %v", got, want[:2])
+	}
+}
+
+func TestExpireDropsLaterNonces(t *testing.T) {
+	p := New(0, time.Minute)
+	add(t, p, pending("alice-0", "alice", 0, 1), pending("bob-0", "bob", 0, 1))
+	if _, err := p.Add(pending("alice-1", "alice", 1, 1), start.Add(30*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	if expired := p.Expire(start.Add(59 * time.Second)); len(expired) != 0 {
+		t.Errorf("expired %v before any TTL ran out", ids(expired))
+	}
+	entry, _ := p.Get("alice-1")
+	if want := start.Add(90 * time.Second); !entry.ExpiresAt.Equal(want) {
+		t.Errorf("alice-1 expires at %s, want %s", entry.ExpiresAt, want)
+	}
+
+	// alice-0 runs out, and alice-1 cannot be mined without it.
+	expired := ids(p.Expire(start.Add(time.Minute)))
+	sort.Strings(expired)
+	if want := []string{"alice-0", "alice-1"}; !reflect.DeepEqual(expired, want) {
+		t.Errorf("expired %v, want %v", expired, want)
+	}
+	if got := pooled(p); !reflect.DeepEqual(got, []string{"bob-0"}) {
+		t.Errorf("pool %v after expiry, want bob-0", got)
+	}
+	if p.Len() != 1 || p.Bytes() != TransactionSize(pending("bob-0", "bob", 0, 1)) {
+		t.Errorf("pool holds %d transactions and %d bytes after expiry, want bob-0 alone", p.Len(), p.Bytes())
+	}
+	if expired := ids(p.Expire(start.Add(time.Hour))); !reflect.DeepEqual(expired, []string{"bob-0"}) {
+		t.Errorf("expired %v, want bob-0", expired)
+	}
+
+	// Without a TTL nothing expires.
+	p = New(0, 0)
+	add(t, p, pending("alice-0", "alice", 0, 1))
+	if expired := p.Expire(start.Add(24 * 365 * time.Hour)); len(expired) != 0 {
+		t.Errorf("expired %v without a TTL", ids(expired))
+	}
+}
@@ -0,0 +1,103 @@
+package merkle_test
+
+import (
+	"encoding/hex"
+	"indicartcoin/merkle"
+	"indicartcoin/structs"
+	"strconv"
+	"testing"
+)
+
+func TestProofVerifiesEveryLeaf(t *testing.T) {
+	// Odd sizes carry a hash up unchanged, so every size up to 9 is tried.
+	for size := 1; size <= 9; size++ {
+		leaves := make([][]byte, size)
+		for i := range leaves {
+			leaves[i] = []byte("leaf " + strconv.Itoa(i))
+		}
+		root := merkle.Root(leaves)
+		for i := range leaves {
+			proof, err := merkle.Proof(leaves, i)
+			if err != nil {
+				t.Fatalf("size %d, leaf %d: %v", size, i, err)
+			}
+			if !merkle.Verify(leaves[i], proof, root) {
+				t.Errorf("size %d, leaf %d: proof %v does not verify", size, i, proof)
+			}
+			if merkle.Verify([]byte("forged"), proof, root) {
+				t.Errorf("size %d, leaf %d: proof verifies a leaf that is not in the tree", size, i)
+			}
+			if size > 1 && merkle.Verify(leaves[(i+1)%size], proof, root) {
+				t.Errorf("size %d, leaf %d: proof verifies the next leaf too", size, i)
+			}
+		}
+	}
+	if _, err := merkle.Proof([][]byte{[]byte("only")}, 1); err == nil {
+		t.Error("proof for a leaf past the end, want an error")
+	}
+}
+
+// TestTransactionProofAgainstBlockRoot checks a proof the way a light client
+// does with /block/tx_proof: the leaf is the transaction's Hash, the proof folds
+// into the block's Merkle root, and the root is covered by the block hash.
+func TestTransactionProofAgainstBlockRoot(t *testing.T) {
+	var txs []structs.Transaction
+	for i := 0; i < 5; i++ {
+		txs = append(txs, structs.Transaction{
+			TransactionId: "tx-" + strconv.Itoa(i),
+			Type:          structs.CoinTransfer,
+			From:          "alice",
+			To:            "bob",
+			Amount:        structs.Amount(i + 1),
+			Nonce:         uint64(i),
+			Version:       structs.NoncedTransactionVersion,
+		})
+	}
+	block := &structs.Block{
+		Version:        structs.VRFBlockVersion,
+		Index:          3,
+		TimestampNanos: 1767225600000000000,
+		PrevHash:       "parent",
+		Transactions:   txs,
+		MerkleRoot:     structs.ComputeMerkleRoot(txs),
+	}
+	block.Hash = block.CalculateHash()
+
+	root, err := hex.DecodeString(block.MerkleRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tx := range txs {
+		proof, found := block.TransactionProof(tx.TransactionId)
+		if !found {
+			t.Fatalf("no proof for %s", tx.TransactionId)
+		}
+		leaf, err := hex.DecodeString(tx.Hash())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !merkle.Verify(leaf, proof, root) {
+			t.Errorf("proof for %s does not reach the block's Merkle root", tx.TransactionId)
+		}
+		// The leaf is not the Serialize() output for a canonical transaction.
+		if merkle.Verify([]byte(tx.Serialize()), proof, root) {
+			t.Errorf("proof for %s verifies against its Serialize() output", tx.TransactionId)
+		}
+
+		changed := tx
+		changed.Amount += 1
+		if leaf, _ := hex.DecodeString(changed.Hash()); merkle.Verify(leaf, proof, root) {
+			t.Errorf("proof for %s still verifies with the amount changed", tx.TransactionId)
+		}
+	}
+
+	// A root that is not the one in the header does not hash to the block.
+	tampered := *block
+	tampered.MerkleRoot = structs.ComputeMerkleRoot(txs[:4])
+	if tampered.CalculateHash() == block.Hash {
+		t.Error("block hash does not cover the Merkle root")
+	}
+	if _, found := block.TransactionProof("tx-missing"); found {
+		t.Error("proof for a transaction the block does not hold")
+	}
+}
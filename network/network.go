@@ -2,14 +2,24 @@ package network
 
 import (
 	"encoding/json"
-	"fmt"
+	"errors"
+	"indicartcoin/blockchain"
+	"indicartcoin/chainsync"
 	"indicartcoin/database"
+	"indicartcoin/genesis"
+	"indicartcoin/mempool"
+	"indicartcoin/merkle"
+	"indicartcoin/p2p"
+	"indicartcoin/provenance"
 	"indicartcoin/sqldatabase"
 	"indicartcoin/state"
 	"indicartcoin/structs"
 	"log"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
@@ -20,7 +30,7 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
-func HandleConnections(w http.ResponseWriter, r *http.Request, state *state.State, vals []structs.Validator, IndicBlockchain *structs.Blockchain) {
+func HandleConnections(w http.ResponseWriter, r *http.Request, state *state.State) {
 	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Fatal(err)
@@ -35,17 +45,24 @@ func HandleConnections(w http.ResponseWriter, r *http.Request, state *state.Stat
 			_ = ws.WriteJSON(structs.ResponseMessage{Status: "error", Message: "Invalid JSON"})
 			break
 		}
-		fmt.Println(tx.ArtOwnership.Status.String())
+		database.StateMutex.Lock()
 		valid, err := state.IsValidTransaction(tx)
 		// Validate the transaction
 		if !valid {
+			database.StateMutex.Unlock()
 			log.Printf("Invalid transaction: %v", tx)
 			log.Printf("error: %v", err.Error())
 			_ = ws.WriteJSON(structs.ResponseMessage{Status: "error", Message: err.Error()})
 			continue
 		}
 		//Add transaction to Database
-		database.AddTransaction(tx, IndicBlockchain, vals)
+		err = database.AddTransaction(tx)
+		database.StateMutex.Unlock()
+		if err != nil {
+			log.Printf("Transaction %s not added: %v", tx.TransactionId, err)
+			_ = ws.WriteJSON(structs.ResponseMessage{Status: "error", Message: err.Error()})
+			continue
+		}
 
 		// Send success message
 		_ = ws.WriteJSON(structs.ResponseMessage{Status: "success", Message: "Transaction added"})
@@ -55,7 +72,9 @@ func HandleConnections(w http.ResponseWriter, r *http.Request, state *state.Stat
 func GetBlockchainHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
+	database.Blockchain.Mutex.Lock()
 	blockchainData, err := json.Marshal(database.Blockchain.Blocks)
+	database.Blockchain.Mutex.Unlock()
 	if err != nil {
 		http.Error(w, "Failed to serialize blockchain", http.StatusInternalServerError)
 		return
@@ -64,6 +83,468 @@ func GetBlockchainHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(blockchainData)
 }
 
+type ChainVerifyResponse struct {
+	Valid  bool                   `json:"valid"`
+	Height int                    `json:"height"`
+	Error  *blockchain.ChainError `json:"error,omitempty"`
+}
+
+// VerifyChainHandler re-verifies every stored block and reports the first
+// broken link, if any.
+func VerifyChainHandler(w http.ResponseWriter, r *http.Request) {
+	blocks, err := sqldatabase.LoadAllBlocks()
+	if err != nil {
+		http.Error(w, "Failed to load blocks", http.StatusInternalServerError)
+		return
+	}
+
+	response := ChainVerifyResponse{Valid: true, Height: len(blocks)}
+	if err := blockchain.VerifyChain(blocks, database.Blockchain.Genesis, database.ChainConfig.ChainID); err != nil {
+		var chainErr *blockchain.ChainError
+		if !errors.As(err, &chainErr) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		response.Valid = false
+		response.Error = chainErr
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// GenesisResponse identifies the network the node is on.
+type GenesisResponse struct {
+	Hash    string           `json:"hash"`
+	ChainID string           `json:"chainId"`
+	Genesis *genesis.Genesis `json:"genesis"`
+}
+
+// GenesisHandler returns the genesis the node was started from, or 404 if it
+// was started without one.
+func GenesisHandler(w http.ResponseWriter, r *http.Request) {
+	if database.Genesis == nil {
+		http.Error(w, "Node was started without a genesis file", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(GenesisResponse{
+		Hash:    database.Genesis.Hash(),
+		ChainID: database.Genesis.ChainID,
+		Genesis: database.Genesis,
+	})
+}
+
+type SubmitBlockResponse struct {
+	Height  int    `json:"height"`  // main-chain height after the block was taken
+	TipHash string `json:"tipHash"` // hash of the main chain's last block
+}
+
+// SubmitBlockHandler takes a block sealed by another node, in the form
+// /get_blockchain lists them, and hands it to database.ReceiveBlock. The block
+// may extend the main chain, start or extend a side branch, or make a side
+// branch heavy enough to switch to. Invalid blocks get 400, and blocks whose
+// parent the node does not have get 422 so the parent can be sent first.
+func SubmitBlockHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var block structs.Block
+	if err := json.NewDecoder(r.Body).Decode(&block); err != nil {
+		http.Error(w, "Invalid block: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := database.ReceiveBlock(&block); err != nil && !errors.Is(err, database.ErrKnownBlock) {
+		status := http.StatusBadRequest
+		if errors.Is(err, database.ErrUnknownParent) {
+			status = http.StatusUnprocessableEntity
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	var response SubmitBlockResponse
+	database.Blockchain.Mutex.Lock()
+	response.Height = len(database.Blockchain.Blocks)
+	if response.Height > 0 {
+		response.TipHash = database.Blockchain.Blocks[response.Height-1].Hash
+	}
+	database.Blockchain.Mutex.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+type PeersResponse struct {
+	NodeID string         `json:"nodeId"`
+	Peers  []p2p.PeerInfo `json:"peers"`
+}
+
+// PeersHandler lists the nodes this node is connected to.
+func PeersHandler(w http.ResponseWriter, r *http.Request) {
+	response := PeersResponse{Peers: []p2p.PeerInfo{}}
+	if database.Peers != nil {
+		response.NodeID = database.Peers.ID()
+		for _, peer := range database.Peers.Peers() {
+			response.Peers = append(response.Peers, peer.Info())
+		}
+	}
+	sort.Slice(response.Peers, func(i, j int) bool { return response.Peers[i].ConnectedAt.Before(response.Peers[j].ConnectedAt) })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// PeerBookResponse is the body of /p2p/book.
+type PeerBookResponse struct {
+	Known  []structs.KnownPeer `json:"known"`
+	Banned []structs.PeerBan   `json:"banned"`
+}
+
+// PeerBookHandler lists the peer addresses the node knows and the peers it
+// has banned.
+func PeerBookHandler(w http.ResponseWriter, r *http.Request) {
+	response := PeerBookResponse{Known: []structs.KnownPeer{}, Banned: []structs.PeerBan{}}
+	if database.Peers != nil {
+		response.Known, response.Banned = database.Peers.Book()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// ProposersHandler lists who may seal the next block and when.
+func ProposersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(database.NextProposers())
+}
+
+// SyncStatusHandler reports how far the node has caught up with its peers.
+func SyncStatusHandler(syncer *chainsync.Syncer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(syncer.Status())
+	}
+}
+
+type BlockHeader struct {
+	Version        int    `json:"version"`
+	Index          int    `json:"index"`
+	Timestamp      string `json:"timestamp,omitempty"` // text timestamp of version 0 and 1 blocks
+	TimestampNanos int64  `json:"timestampNanos"`
+	PrevHash       string `json:"prevHash"`
+	MerkleRoot     string `json:"merkleRoot"`
+	Proposer       string `json:"proposer,omitempty"`  // version 3
+	Signature      string `json:"signature,omitempty"` // version 3
+	VRFProof       string `json:"vrfProof,omitempty"`  // version 4
+	Hash           string `json:"hash"`
+}
+
+type TransactionProofResponse struct {
+	TransactionId   string             `json:"transactionId"`
+	TransactionHash string             `json:"transactionHash"`
+	Header          BlockHeader        `json:"header"`
+	Proof           []merkle.ProofStep `json:"proof"`
+}
+
+// TransactionProofHandler returns a Merkle inclusion proof for the transaction
+// given by the "tx" query parameter. The leaf is the transaction's Hash: the
+// SHA-256 of its CanonicalJSON for version 1 and later, of its Serialize()
+// output for version 0. A light client verifies the proof by folding each
+// step into the leaf to reach header.merkleRoot, and checking that the header
+// hashes to header.hash: SHA-256 of "INDICARTCOIN_BLOCK_V2" || 0x00 || the
+// canonical header for version 2 to 4, of index + timestamp + prevHash +
+// merkleRoot for version 1.
+func TransactionProofHandler(w http.ResponseWriter, r *http.Request) {
+	transactionId := r.URL.Query().Get("tx")
+	if transactionId == "" {
+		http.Error(w, "tx parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	database.Blockchain.Mutex.Lock()
+	defer database.Blockchain.Mutex.Unlock()
+
+	for _, block := range database.Blockchain.Blocks {
+		proof, found := block.TransactionProof(transactionId)
+		if !found {
+			continue
+		}
+		if block.MerkleRoot == "" {
+			http.Error(w, "Block was created before Merkle roots and has no proofs", http.StatusConflict)
+			return
+		}
+
+		var txHash string
+		for _, tx := range block.Transactions {
+			if tx.TransactionId == transactionId {
+				txHash = tx.Hash()
+			}
+		}
+		response := TransactionProofResponse{
+			TransactionId:   transactionId,
+			TransactionHash: txHash,
+			Header: BlockHeader{
+				Version:        block.Version,
+				Index:          block.Index,
+				Timestamp:      block.Timestamp,
+				TimestampNanos: block.TimestampNanos,
+				PrevHash:       block.PrevHash,
+				MerkleRoot:     block.MerkleRoot,
+				Proposer:       block.Proposer,
+				Signature:      block.Signature,
+				VRFProof:       block.VRFProof,
+				Hash:           block.Hash,
+			},
+			Proof: proof,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	http.Error(w, "Transaction not found in any block", http.StatusNotFound)
+}
+
+type NextNonceResponse struct {
+	Address string `json:"address"`
+	Nonce   uint64 `json:"nonce"`
+}
+
+// NextNonceHandler returns the nonce the next transaction from the "address"
+// query parameter must carry, counting transactions still in the pending pool.
+func NextNonceHandler(w http.ResponseWriter, r *http.Request) {
+	address := r.URL.Query().Get("address")
+	if address == "" {
+		http.Error(w, "address parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	database.StateMutex.Lock()
+	nonce := database.AppState.NextNonce(address)
+	database.StateMutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(NextNonceResponse{Address: address, Nonce: nonce})
+}
+
+type MempoolResponse struct {
+	Size         int             `json:"size"`
+	MaxSize      int             `json:"maxSize"`
+	Transactions []mempool.Entry `json:"transactions"`
+}
+
+// MempoolHandler lists the pending pool in the order blocks are filled from
+// it. Optional query parameters: "id" for a single transaction, "from" to
+// keep one sender's transactions, and "limit".
+func MempoolHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit := 0
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	var entries []mempool.Entry
+	if id := query.Get("id"); id != "" {
+		entry, found := database.Mempool.Get(id)
+		if !found {
+			http.Error(w, "Transaction not in the pool", http.StatusNotFound)
+			return
+		}
+		entries = append(entries, entry)
+	} else {
+		from := query.Get("from")
+		for _, entry := range database.Mempool.Entries() {
+			if from != "" && entry.Transaction.From != from {
+				continue
+			}
+			if limit > 0 && len(entries) == limit {
+				break
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	response := MempoolResponse{
+		Size:         database.Mempool.Len(),
+		MaxSize:      database.Mempool.MaxSize(),
+		Transactions: entries,
+	}
+	if response.Transactions == nil {
+		response.Transactions = []mempool.Entry{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+type TransactionStatusResponse struct {
+	TransactionId string           `json:"transactionId"`
+	Status        string           `json:"status"` // Pending, Completed, Confirmed or Failed
+	Confirmations int              `json:"confirmations"`
+	Pending       *mempool.Entry   `json:"pending,omitempty"`
+	Receipt       *structs.Receipt `json:"receipt,omitempty"`
+}
+
+// TransactionHandler serves /tx/{id}: whether the transaction is still
+// waiting in the pool, was mined (Completed, or Confirmed once
+// chain.confirmationDepth blocks include it or build on it) or was dropped
+// (Failed), together with its receipt.
+func TransactionHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/tx/")
+	if id == "" {
+		http.Error(w, "Transaction ID is required", http.StatusBadRequest)
+		return
+	}
+
+	response := TransactionStatusResponse{TransactionId: id}
+	if entry, found := database.Mempool.Get(id); found {
+		response.Status = structs.Pending.String()
+		response.Pending = &entry
+	} else {
+		receipt, err := sqldatabase.LoadReceipt(id)
+		if err != nil {
+			http.Error(w, "Failed to load receipt", http.StatusInternalServerError)
+			return
+		}
+		if receipt == nil {
+			http.Error(w, "Transaction not found", http.StatusNotFound)
+			return
+		}
+		response.Receipt = receipt
+		response.Status = receipt.Status.String()
+		if receipt.Status != structs.Failed {
+			database.Blockchain.Mutex.Lock()
+			response.Confirmations = len(database.Blockchain.Blocks) - receipt.BlockIndex + 1
+			database.Blockchain.Mutex.Unlock()
+			if response.Confirmations >= database.ChainConfig.ConfirmationDepth {
+				response.Status = structs.Confirmed.String()
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// TransactionSearchHandler pages through confirmed transactions, newest
+// first. Optional query parameters: "address" (sender or recipient), "from",
+// "to", "artId", "type", "minBlock" and "maxBlock" (inclusive), "since" and
+// "until" (RFC 3339 block times), "limit" and "cursor", the nextCursor of the
+// previous page.
+func TransactionSearchHandler(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	query := sqldatabase.TransactionQuery{
+		Address: params.Get("address"),
+		From:    params.Get("from"),
+		To:      params.Get("to"),
+		ArtID:   params.Get("artId"),
+		Cursor:  params.Get("cursor"),
+	}
+
+	var err error
+	if value := params.Get("type"); value != "" {
+		var parsed int
+		parsed, err = strconv.Atoi(value)
+		if err == nil && (parsed < int(structs.CoinTransfer) || parsed > int(structs.Stake)) {
+			err = errors.New("unknown transaction type")
+		}
+		transactionType := structs.TransactionType(parsed)
+		query.Type = &transactionType
+	}
+	for name, target := range map[string]*int{"minBlock": &query.MinBlock, "maxBlock": &query.MaxBlock, "limit": &query.Limit} {
+		if value := params.Get(name); value != "" && err == nil {
+			*target, err = strconv.Atoi(value)
+			if err == nil && *target < 0 {
+				err = errors.New(name + " must not be negative")
+			}
+		}
+	}
+	for name, target := range map[string]*time.Time{"since": &query.Since, "until": &query.Until} {
+		if value := params.Get(name); value != "" && err == nil {
+			*target, err = time.Parse(time.RFC3339, value)
+		}
+	}
+	if err != nil {
+		http.Error(w, "Invalid query: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := sqldatabase.SearchTransactions(query)
+	if errors.Is(err, sqldatabase.ErrInvalidCursor) {
+		http.Error(w, "Invalid cursor", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to search transactions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// AccountHistoryHandler is TransactionSearchHandler with a required
+// "address" parameter: every confirmed transaction sent or received by it.
+func AccountHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("address") == "" {
+		http.Error(w, "address parameter is required", http.StatusBadRequest)
+		return
+	}
+	TransactionSearchHandler(w, r)
+}
+
+func loadProvenance(w http.ResponseWriter, r *http.Request) *provenance.Timeline {
+	artID := r.URL.Query().Get("artId")
+	if artID == "" {
+		http.Error(w, "artId parameter is required", http.StatusBadRequest)
+		return nil
+	}
+	timeline, err := provenance.Build(artID)
+	if errors.Is(err, provenance.ErrUnknownArt) {
+		http.Error(w, "No mined transactions for this art", http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		http.Error(w, "Failed to build provenance", http.StatusInternalServerError)
+		return nil
+	}
+	return timeline
+}
+
+// ProvenanceHandler returns the ownership timeline of the art piece given by
+// the "artId" query parameter, rebuilt from the chain.
+func ProvenanceHandler(w http.ResponseWriter, r *http.Request) {
+	timeline := loadProvenance(w, r)
+	if timeline == nil {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(timeline)
+}
+
+// ProvenanceCertificateHandler returns the same timeline as ProvenanceHandler
+// in a certificate signed with the node key.
+func ProvenanceCertificateHandler(w http.ResponseWriter, r *http.Request) {
+	timeline := loadProvenance(w, r)
+	if timeline == nil {
+		return
+	}
+	// Read after the timeline, so every event is at or below the height.
+	database.Blockchain.Mutex.Lock()
+	height := len(database.Blockchain.Blocks)
+	database.Blockchain.Mutex.Unlock()
+	certificate, err := provenance.Issue(timeline, database.ChainConfig.ChainID, height, database.NodeKey)
+	if err != nil {
+		http.Error(w, "Failed to sign certificate", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(certificate)
+}
+
 func GetArtSummaryHandler(w http.ResponseWriter, r *http.Request) {
 	start, _ := strconv.Atoi(r.URL.Query().Get("start"))
 	count, _ := strconv.Atoi(r.URL.Query().Get("count"))
@@ -111,7 +592,15 @@ func LikeArtHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		// Block commits write the in-memory like count back, so it moves
+		// together with the stored one.
+		database.StateMutex.Lock()
 		err = sqldatabase.IncrementArtLike(artID)
+		if art, ok := database.AppState.ArtOwnership[artID]; ok && err == nil {
+			art.ArtLikes++
+			database.AppState.ArtOwnership[artID] = art
+		}
+		database.StateMutex.Unlock()
 		if err != nil {
 			response.Success = false
 			json.NewEncoder(w).Encode(response)
@@ -0,0 +1,280 @@
+package p2p
+
+import (
+	"indicartcoin/structs"
+	"math/rand"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxBookSize     = 1000 // known addresses kept, static peers aside
+	maxDialFailures = 10   // failed dials in a row before a learned address is forgotten
+	maxBackoffSteps = 6    // the wait between dials doubles at most this often
+)
+
+// book is the node's peer book: every address it knows and every ban in
+// force, mirrored to storage through the Options callbacks.
+type book struct {
+	opts Options
+
+	mu          sync.Mutex
+	peers       map[string]*structs.KnownPeer // by address
+	bans        map[string]structs.PeerBan    // by address
+	bannedNodes map[string]string             // node ID to the address of its ban
+}
+
+func newBook(opts Options) *book {
+	return &book{
+		opts:        opts,
+		peers:       make(map[string]*structs.KnownPeer),
+		bans:        make(map[string]structs.PeerBan),
+		bannedNodes: make(map[string]string),
+	}
+}
+
+// validAddress reports whether address is a ws:// or wss:// URL with a host.
+func validAddress(address string) bool {
+	if !strings.HasPrefix(address, "ws://") && !strings.HasPrefix(address, "wss://") {
+		return false
+	}
+	u, err := url.Parse(address)
+	return err == nil && u.Host != ""
+}
+
+// restore loads the stored peer book. Bans that ran out are dropped.
+func (b *book) restore(peers []structs.KnownPeer, bans []structs.PeerBan, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, peer := range peers {
+		peer := peer
+		if peer.Source == structs.PeerSourceConfig {
+			// p2p.peers may have changed since; KeepConnected marks the
+			// current ones again.
+			peer.Source = structs.PeerSourceExchange
+		}
+		b.peers[peer.Address] = &peer
+	}
+	for _, ban := range bans {
+		if !now.Before(ban.BannedUntil) {
+			if b.opts.DeleteBan != nil {
+				b.opts.DeleteBan(ban.Address)
+			}
+			continue
+		}
+		b.bans[ban.Address] = ban
+		if ban.NodeID != "" {
+			b.bannedNodes[ban.NodeID] = ban.Address
+		}
+	}
+}
+
+// add records a newly learned address and reports whether it was new. A full
+// book takes no more learned addresses.
+func (b *book) add(address, source string) bool {
+	if !validAddress(address) || address == b.opts.ListenURL {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if peer, exists := b.peers[address]; exists {
+		if source == structs.PeerSourceConfig && peer.Source != source {
+			peer.Source = source
+			b.save(peer)
+		}
+		return false
+	}
+	if source != structs.PeerSourceConfig && len(b.peers) >= maxBookSize {
+		return false
+	}
+	peer := &structs.KnownPeer{Address: address, Source: source}
+	b.peers[address] = peer
+	b.save(peer)
+	return true
+}
+
+// remove forgets address, e.g. once it turned out to be this node's own.
+func (b *book) remove(address string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, exists := b.peers[address]; !exists {
+		return
+	}
+	delete(b.peers, address)
+	if b.opts.DeletePeer != nil {
+		b.opts.DeletePeer(address)
+	}
+}
+
+// connected records a successful handshake with the node at address.
+func (b *book) connected(address, nodeID string, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if peer := b.peers[address]; peer != nil {
+		peer.NodeID = nodeID
+		peer.LastSeen = now
+		peer.LastAttempt = now
+		peer.Failures = 0
+		b.save(peer)
+	}
+}
+
+// disconnected records the latency measured over a connection to address.
+func (b *book) disconnected(address string, latency time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if peer := b.peers[address]; peer != nil && latency > 0 {
+		peer.LatencyMillis = latency.Milliseconds()
+		b.save(peer)
+	}
+}
+
+// failed records a failed dial. Learned addresses that keep failing are
+// forgotten; configured ones are kept.
+func (b *book) failed(address string, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	peer := b.peers[address]
+	if peer == nil {
+		return
+	}
+	peer.LastAttempt = now
+	peer.Failures++
+	if peer.Source != structs.PeerSourceConfig && peer.Failures >= maxDialFailures {
+		delete(b.peers, address)
+		if b.opts.DeletePeer != nil {
+			b.opts.DeletePeer(address)
+		}
+		return
+	}
+	b.save(peer)
+}
+
+// candidates returns up to n learned addresses worth dialing: not banned, not
+// in skip, and not tried within the backoff after their last failure. Those
+// seen most recently come first.
+func (b *book) candidates(n int, skip map[string]bool, interval time.Duration, now time.Time) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var found []*structs.KnownPeer
+	for address, peer := range b.peers {
+		if skip[address] || peer.Source == structs.PeerSourceConfig || b.isBanned(address, peer.NodeID, now) {
+			continue
+		}
+		backoff := interval << min(peer.Failures, maxBackoffSteps)
+		if peer.Failures > 0 && now.Sub(peer.LastAttempt) < backoff {
+			continue
+		}
+		found = append(found, peer)
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].LastSeen.After(found[j].LastSeen) })
+
+	addresses := make([]string, 0, n)
+	for _, peer := range found {
+		if len(addresses) == n {
+			break
+		}
+		addresses = append(addresses, peer.Address)
+	}
+	return addresses
+}
+
+// sample returns up to n random addresses the node has connected to before,
+// to pass on to a peer.
+func (b *book) sample(n int, now time.Time) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var addresses []string
+	for address, peer := range b.peers {
+		if !peer.LastSeen.IsZero() && !b.isBanned(address, peer.NodeID, now) {
+			addresses = append(addresses, address)
+		}
+	}
+	rand.Shuffle(len(addresses), func(i, j int) { addresses[i], addresses[j] = addresses[j], addresses[i] })
+	if len(addresses) > n {
+		addresses = addresses[:n]
+	}
+	return addresses
+}
+
+// ban keeps the peer at address, and the node with nodeID, away until until.
+func (b *book) ban(address, nodeID, reason string, until time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ban := structs.PeerBan{Address: address, NodeID: nodeID, Reason: reason, BannedUntil: until}
+	b.bans[address] = ban
+	if nodeID != "" {
+		b.bannedNodes[nodeID] = address
+	}
+	if b.opts.SaveBan != nil {
+		b.opts.SaveBan(ban)
+	}
+}
+
+// banned reports whether the address or node ID is banned. Either may be
+// empty.
+func (b *book) banned(address, nodeID string, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.isBanned(address, nodeID, now)
+}
+
+// isBanned is banned for callers holding b.mu. Bans that ran out are lifted.
+func (b *book) isBanned(address, nodeID string, now time.Time) bool {
+	for _, key := range []string{address, b.bannedNodes[nodeID]} {
+		ban, exists := b.bans[key]
+		if key == "" || !exists {
+			continue
+		}
+		if now.Before(ban.BannedUntil) {
+			return true
+		}
+		delete(b.bans, key)
+		if ban.NodeID != "" {
+			delete(b.bannedNodes, ban.NodeID)
+		}
+		if b.opts.DeleteBan != nil {
+			b.opts.DeleteBan(key)
+		}
+	}
+	return false
+}
+
+// list returns the known addresses and the bans in force, sorted by address.
+func (b *book) list(now time.Time) ([]structs.KnownPeer, []structs.PeerBan) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	peers := make([]structs.KnownPeer, 0, len(b.peers))
+	for _, peer := range b.peers {
+		peers = append(peers, *peer)
+	}
+	bans := make([]structs.PeerBan, 0, len(b.bans))
+	for address, ban := range b.bans {
+		if b.isBanned(address, "", now) {
+			bans = append(bans, ban)
+		}
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].Address < peers[j].Address })
+	sort.Slice(bans, func(i, j int) bool { return bans[i].Address < bans[j].Address })
+	return peers, bans
+}
+
+func (b *book) save(peer *structs.KnownPeer) {
+	if b.opts.SavePeer != nil {
+		b.opts.SavePeer(*peer)
+	}
+}
@@ -0,0 +1,587 @@
+package p2p
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"indicartcoin/structs"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ProtocolVersion is announced in the handshake. Peers speaking another
+// version are refused.
+const ProtocolVersion = 1
+
+// Message types. MsgGetHeaders and MsgGetBlocks ask for the main-chain
+// headers or blocks from index From on, at most Count of them; they are
+// answered with MsgHeaders and MsgBlocks carrying the same RequestID.
+// MsgGetPeers is answered with MsgPeers listing addresses of other nodes.
+const (
+	MsgHello       = "hello"
+	MsgTransaction = "tx"
+	MsgBlock       = "block"
+	MsgGetHeaders  = "getHeaders"
+	MsgHeaders     = "headers"
+	MsgGetBlocks   = "getBlocks"
+	MsgBlocks      = "blocks"
+	MsgGetPeers    = "getPeers"
+	MsgPeers       = "peers"
+)
+
+// Message is one JSON frame on a peer connection. Type says which of the
+// other fields is set.
+type Message struct {
+	Type        string               `json:"type"`
+	Hello       *Hello               `json:"hello,omitempty"`
+	Transaction *structs.Transaction `json:"transaction,omitempty"`
+	Block       *structs.Block       `json:"block,omitempty"`
+
+	RequestID uint64           `json:"requestId,omitempty"`
+	From      int              `json:"from,omitempty"`
+	Count     int              `json:"count,omitempty"`
+	Height    int              `json:"height,omitempty"` // the sender's height, on responses
+	Headers   []structs.Block  `json:"headers,omitempty"`
+	Blocks    []*structs.Block `json:"blocks,omitempty"`
+	Addresses []string         `json:"addresses,omitempty"`
+}
+
+// Hello is the first message each side sends. Nodes only talk to peers on the
+// same chain ID and genesis.
+type Hello struct {
+	Version     int    `json:"version"`
+	NodeID      string `json:"nodeId"` // random per process, to spot duplicate and self connections
+	ChainID     string `json:"chainId"`
+	GenesisHash string `json:"genesisHash"` // empty for chains without a genesis file
+	Height      int    `json:"height"`
+	ListenURL   string `json:"listenUrl,omitempty"` // where other nodes can dial the sender, if anywhere
+}
+
+// Handlers connect a Node to the chain it gossips for. Transaction and Block
+// are called for every transaction and block a peer sends that the node has
+// not seen; if they return nil it is relayed to the other peers. Headers and
+// Blocks answer peers' requests with up to count main-chain headers or
+// blocks from index from on, fewer past the tip. They may be called from
+// several peers' goroutines at once.
+type Handlers struct {
+	Height      func() int
+	Transaction func(tx structs.Transaction) error
+	Block       func(block *structs.Block) error
+	Headers     func(from, count int) []structs.Block
+	Blocks      func(from, count int) []*structs.Block
+}
+
+// Options configure how a Node finds and keeps its peers. The Save and Delete
+// callbacks persist the peer book; any of them may be nil.
+type Options struct {
+	ListenURL   string        // ws:// URL of this node's /p2p endpoint, announced to peers; empty if unreachable
+	MaxOutbound int           // connections to dial, static peers included
+	BanDuration time.Duration // how long a misbehaving peer is kept away
+
+	SavePeer   func(peer structs.KnownPeer)
+	DeletePeer func(address string)
+	SaveBan    func(ban structs.PeerBan)
+	DeleteBan  func(address string)
+}
+
+// Invalid marks err as caused by a transaction or block that fails
+// verification. Handlers return it so the peer that sent the data is banned.
+func Invalid(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &invalidError{err}
+}
+
+// IsInvalid reports whether err was marked with Invalid.
+func IsInvalid(err error) bool {
+	var invalid *invalidError
+	return errors.As(err, &invalid)
+}
+
+type invalidError struct {
+	err error
+}
+
+func (e *invalidError) Error() string {
+	return e.err.Error()
+}
+
+func (e *invalidError) Unwrap() error {
+	return e.err
+}
+
+// BanScore is the misbehavior score at which a peer is banned. Points decay
+// by one a minute, so only a peer that keeps misbehaving, or sends data that
+// fails verification, gets there.
+const BanScore = 100
+
+// ErrKnown is wrapped by handler errors for a transaction or block the node
+// already had. Such gossip is dropped without being logged or relayed.
+var ErrKnown = errors.New("already known")
+
+// The most headers and blocks a peer sends for one request. Blocks are
+// bounded so a full response stays under the message size limit.
+const (
+	MaxHeadersPerRequest = 2000
+	MaxBlocksPerRequest  = 16
+)
+
+const (
+	handshakeTimeout     = 10 * time.Second
+	writeTimeout         = 10 * time.Second
+	pongTimeout          = 60 * time.Second
+	pingInterval         = pongTimeout * 9 / 10
+	requestTimeout       = 30 * time.Second
+	maxMessagesPerSecond = 200         // more from one peer count as flooding
+	exchangeInterval     = time.Minute // least time between asking peers for addresses to fill free slots
+	maxAddresses         = 100         // addresses sent in one MsgPeers
+	maxMessageBytes      = 32 << 20
+	sendQueueLength      = 256
+	seenCapacity         = 20000
+)
+
+// Node is this process's end of the peer network. It accepts connections on
+// ServeHTTP, dials peers with Connect or KeepConnected, and gossips
+// transactions and blocks between them. Addresses of other nodes are kept in
+// a peer book, learned from handshakes and by asking peers, and used to fill
+// up to Options.MaxOutbound connections. Peers that misbehave are banned. A
+// Node depends only on its Options and Handlers, so several can run in one
+// process, each in front of its own chain.
+type Node struct {
+	id          string
+	chainID     string
+	genesisHash string
+	opts        Options
+	handlers    Handlers
+	upgrader    websocket.Upgrader
+	seen        *seenSet
+	book        *book
+
+	mu     sync.Mutex
+	peers  map[string]*Peer // by node ID
+	closed bool
+	stop   chan struct{}
+}
+
+// NewNode returns a Node for the chain with the given ID and genesis hash.
+func NewNode(chainID string, genesisHash string, opts Options, handlers Handlers) *Node {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return &Node{
+		id:          hex.EncodeToString(id),
+		chainID:     chainID,
+		genesisHash: genesisHash,
+		opts:        opts,
+		handlers:    handlers,
+		upgrader:    websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }},
+		seen:        newSeenSet(seenCapacity),
+		book:        newBook(opts),
+		peers:       make(map[string]*Peer),
+		stop:        make(chan struct{}),
+	}
+}
+
+// ID returns the node ID announced in the handshake.
+func (n *Node) ID() string {
+	return n.id
+}
+
+// RestoreBook loads the peer book kept in storage.
+func (n *Node) RestoreBook(peers []structs.KnownPeer, bans []structs.PeerBan) {
+	n.book.restore(peers, bans, time.Now().UTC())
+}
+
+// Book returns the known peer addresses and the bans in force.
+func (n *Node) Book() ([]structs.KnownPeer, []structs.PeerBan) {
+	return n.book.list(time.Now().UTC())
+}
+
+// ServeHTTP accepts a connection from another node and serves it until it
+// closes. Banned hosts are turned away before the upgrade.
+func (n *Node) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if n.book.banned(remoteHost(r.RemoteAddr), "", time.Now().UTC()) {
+		http.Error(w, "Banned", http.StatusForbidden)
+		return
+	}
+	conn, err := n.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("Error accepting peer:", err)
+		return
+	}
+	peer, err := n.handshake(conn, r.RemoteAddr, false)
+	if err != nil {
+		log.Printf("Refusing peer %s: %v", r.RemoteAddr, err)
+		return
+	}
+	<-peer.done
+}
+
+// Connect dials the node at url, a ws:// URL of its /p2p endpoint, and
+// returns once the handshake is done. The connection is then served in the
+// background until it closes.
+func (n *Node) Connect(url string) (*Peer, error) {
+	if n.book.banned(url, "", time.Now().UTC()) {
+		return nil, fmt.Errorf("%s is banned", url)
+	}
+	dialer := websocket.Dialer{HandshakeTimeout: handshakeTimeout}
+	conn, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		n.book.failed(url, time.Now().UTC())
+		return nil, fmt.Errorf("dialing %s: %v", url, err)
+	}
+	peer, err := n.handshake(conn, url, true)
+	if errors.Is(err, errSelf) {
+		// Our own address, passed around by peers.
+		n.book.remove(url)
+	}
+	if err != nil {
+		if !errors.Is(err, errDuplicate) {
+			n.book.failed(url, time.Now().UTC())
+		}
+		return nil, fmt.Errorf("handshake with %s: %v", url, err)
+	}
+	return peer, nil
+}
+
+// KeepConnected dials each of urls and redials it interval after the
+// connection fails or drops, until Close is called. Every interval it also
+// dials addresses from the peer book while fewer than Options.MaxOutbound
+// connections are outbound, asking peers for more addresses when the book
+// runs short.
+func (n *Node) KeepConnected(urls []string, interval time.Duration) {
+	go n.discover(len(urls), interval)
+	for _, url := range urls {
+		n.book.add(url, structs.PeerSourceConfig)
+		go func(url string) {
+			for {
+				peer, err := n.Connect(url)
+				if err != nil {
+					log.Printf("Peer %s: %v", url, err)
+				} else {
+					select {
+					case <-peer.done:
+					case <-n.stop:
+						return
+					}
+				}
+				select {
+				case <-time.After(interval):
+				case <-n.stop:
+					return
+				}
+			}
+		}(url)
+	}
+}
+
+// discover fills the outbound slots the static peers leave from the peer
+// book.
+func (n *Node) discover(static int, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var lastExchange time.Time
+	exchanges := 0
+
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-ticker.C:
+		}
+
+		peers := n.Peers()
+		skip := make(map[string]bool)
+		outbound := 0
+		for _, peer := range peers {
+			skip[peer.addr] = true
+			skip[peer.hello.ListenURL] = true
+			if peer.outbound {
+				outbound++
+			}
+		}
+		// Static peers keep their slots even while they are down.
+		free := n.opts.MaxOutbound - max(outbound, static)
+		if free <= 0 {
+			continue
+		}
+		now := time.Now().UTC()
+		candidates := n.book.candidates(free, skip, interval, now)
+		for _, url := range candidates {
+			go func(url string) {
+				if _, err := n.Connect(url); err != nil {
+					log.Printf("Peer %s: %v", url, err)
+				}
+			}(url)
+		}
+		if len(candidates) < free && len(peers) > 0 && now.Sub(lastExchange) >= exchangeInterval {
+			lastExchange = now
+			// Peers are asked in turn.
+			go n.exchange(peers[exchanges%len(peers)])
+			exchanges++
+		}
+	}
+}
+
+// exchange asks peer for the addresses it knows and adds them to the book.
+func (n *Node) exchange(peer *Peer) {
+	addresses, err := peer.GetPeers()
+	if err != nil {
+		log.Printf("Asking peer %s for addresses: %v", peer.addr, err)
+		return
+	}
+	added := 0
+	for _, address := range addresses {
+		if n.book.add(address, structs.PeerSourceExchange) {
+			added++
+		}
+	}
+	if added > 0 {
+		log.Printf("Learned %d peer addresses from %s", added, peer.addr)
+	}
+}
+
+// addresses returns addresses to pass on to a peer: the announced ones of
+// connected peers and others from the book the node has reached before.
+func (n *Node) addresses() []string {
+	seen := make(map[string]bool)
+	var addresses []string
+	for _, peer := range n.Peers() {
+		if url := peer.hello.ListenURL; url != "" && !seen[url] && len(addresses) < maxAddresses {
+			seen[url] = true
+			addresses = append(addresses, url)
+		}
+	}
+	for _, url := range n.book.sample(maxAddresses, time.Now().UTC()) {
+		if !seen[url] && len(addresses) < maxAddresses {
+			seen[url] = true
+			addresses = append(addresses, url)
+		}
+	}
+	return addresses
+}
+
+// ban disconnects peer and keeps it away for Options.BanDuration: the URL the
+// node dialed, or the host that connected, and the peer's node ID.
+func (n *Node) ban(peer *Peer, reason string) {
+	address := peer.addr
+	if !peer.outbound {
+		address = remoteHost(peer.addr)
+	}
+	until := time.Now().UTC().Add(n.opts.BanDuration)
+	n.book.ban(address, peer.hello.NodeID, reason, until)
+	log.Printf("Banned peer %s (node %s) until %s: %s", address, peer.hello.NodeID, until.Format(time.RFC3339), reason)
+	peer.Close()
+}
+
+// remoteHost returns the host part of a host:port address.
+func remoteHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+var (
+	errSelf      = errors.New("connected to itself")
+	errDuplicate = errors.New("already connected to node")
+)
+
+// handshake exchanges Hello messages on a fresh connection and registers the
+// peer if it is on the same chain. The connection is closed on error.
+func (n *Node) handshake(conn *websocket.Conn, addr string, outbound bool) (*Peer, error) {
+	height := 0
+	if n.handlers.Height != nil {
+		height = n.handlers.Height()
+	}
+	hello := Hello{Version: ProtocolVersion, NodeID: n.id, ChainID: n.chainID, GenesisHash: n.genesisHash, Height: height, ListenURL: n.opts.ListenURL}
+
+	conn.SetWriteDeadline(time.Now().Add(handshakeTimeout))
+	if err := conn.WriteJSON(Message{Type: MsgHello, Hello: &hello}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetReadLimit(maxMessageBytes)
+	conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	var reply Message
+	if err := conn.ReadJSON(&reply); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	var err error
+	switch {
+	case reply.Type != MsgHello || reply.Hello == nil:
+		err = fmt.Errorf("expected %s, got %q", MsgHello, reply.Type)
+	case reply.Hello.Version != ProtocolVersion:
+		err = fmt.Errorf("protocol version %d, expected %d", reply.Hello.Version, ProtocolVersion)
+	case reply.Hello.ChainID != n.chainID:
+		err = fmt.Errorf("chain ID %q, expected %q", reply.Hello.ChainID, n.chainID)
+	case reply.Hello.GenesisHash != n.genesisHash:
+		err = fmt.Errorf("genesis %q, expected %q", reply.Hello.GenesisHash, n.genesisHash)
+	case reply.Hello.NodeID == n.id:
+		err = errSelf
+	case n.book.banned("", reply.Hello.NodeID, time.Now().UTC()):
+		err = errors.New("node is banned")
+	}
+	if err != nil {
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, err.Error()), time.Now().Add(writeTimeout))
+		conn.Close()
+		return nil, err
+	}
+
+	peer := &Peer{
+		node:        n,
+		conn:        conn,
+		addr:        addr,
+		outbound:    outbound,
+		hello:       *reply.Hello,
+		height:      reply.Hello.Height,
+		connectedAt: time.Now().UTC(),
+		send:        make(chan Message, sendQueueLength),
+		done:        make(chan struct{}),
+		pending:     make(map[uint64]chan Message),
+	}
+	if outbound {
+		n.book.connected(addr, peer.hello.NodeID, peer.connectedAt)
+	}
+	n.mu.Lock()
+	_, duplicate := n.peers[peer.hello.NodeID]
+	if !n.closed && !duplicate {
+		n.peers[peer.hello.NodeID] = peer
+	}
+	closed := n.closed
+	n.mu.Unlock()
+	if closed || duplicate {
+		conn.Close()
+		if closed {
+			return nil, errors.New("node is closed")
+		}
+		return nil, fmt.Errorf("%w %s", errDuplicate, peer.hello.NodeID)
+	}
+
+	if peer.hello.ListenURL != "" && peer.hello.ListenURL != addr {
+		n.book.add(peer.hello.ListenURL, structs.PeerSourceHello)
+	}
+	log.Printf("Connected to peer %s (node %s, height %d)", addr, peer.hello.NodeID, peer.hello.Height)
+	go peer.writeLoop()
+	go peer.readLoop()
+	return peer, nil
+}
+
+// BroadcastTransaction sends tx to every peer, unless the node has already
+// sent or received it.
+func (n *Node) BroadcastTransaction(tx structs.Transaction) {
+	if n.seen.add(transactionKey(tx.TransactionId)) {
+		n.relay(Message{Type: MsgTransaction, Transaction: &tx}, nil)
+	}
+}
+
+// BroadcastBlock sends block to every peer, unless the node has already sent
+// or received it.
+func (n *Node) BroadcastBlock(block *structs.Block) {
+	if n.seen.add(blockKey(block.Hash)) {
+		n.relay(Message{Type: MsgBlock, Block: block}, nil)
+	}
+}
+
+// relay queues msg for every peer but from.
+func (n *Node) relay(msg Message, from *Peer) {
+	for _, peer := range n.Peers() {
+		if peer != from {
+			peer.queue(msg)
+		}
+	}
+}
+
+// Peers returns the connected peers.
+func (n *Node) Peers() []*Peer {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	peers := make([]*Peer, 0, len(n.peers))
+	for _, peer := range n.peers {
+		peers = append(peers, peer)
+	}
+	return peers
+}
+
+// Close disconnects every peer and stops redialing.
+func (n *Node) Close() {
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		return
+	}
+	n.closed = true
+	close(n.stop)
+	n.mu.Unlock()
+
+	for _, peer := range n.Peers() {
+		peer.Close()
+	}
+}
+
+func (n *Node) removePeer(peer *Peer) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.peers[peer.hello.NodeID] == peer {
+		delete(n.peers, peer.hello.NodeID)
+	}
+}
+
+func transactionKey(transactionId string) string {
+	return "tx:" + transactionId
+}
+
+func blockKey(hash string) string {
+	return "block:" + hash
+}
+
+// seenSet remembers the most recent keys added to it, up to a capacity, so
+// gossip is neither processed nor relayed twice.
+type seenSet struct {
+	mu       sync.Mutex
+	keys     map[string]bool
+	order    []string
+	capacity int
+}
+
+func newSeenSet(capacity int) *seenSet {
+	return &seenSet{keys: make(map[string]bool), capacity: capacity}
+}
+
+// add records key and reports whether it was new.
+func (s *seenSet) add(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.keys[key] {
+		return false
+	}
+	s.keys[key] = true
+	s.order = append(s.order, key)
+	if len(s.order) > s.capacity {
+		delete(s.keys, s.order[0])
+		s.order = s.order[1:]
+	}
+	return true
+}
+
+// forget removes key, so it is processed again if a peer sends it.
+func (s *seenSet) forget(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.keys, key)
+}
@@ -0,0 +1,137 @@
+package p2p
+
+import (
+	"indicartcoin/structs"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryChain is the in-memory store behind a test node's handlers. It
+// counts how often each transaction and block was handed to it.
+type memoryChain struct {
+	mu           sync.Mutex
+	transactions map[string]int
+	blocks       map[string]int
+}
+
+func (c *memoryChain) addTransaction(tx structs.Transaction) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.transactions[tx.TransactionId]++
+	if c.transactions[tx.TransactionId] > 1 {
+		return ErrKnown
+	}
+	return nil
+}
+
+func (c *memoryChain) addBlock(block *structs.Block) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.blocks[block.Hash]++
+	if c.blocks[block.Hash] > 1 {
+		return ErrKnown
+	}
+	return nil
+}
+
+func (c *memoryChain) counts(transactionId, blockHash string) (int, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.transactions[transactionId], c.blocks[blockHash]
+}
+
+type testNode struct {
+	*Node
+	chain  *memoryChain
+	server *httptest.Server
+	url    string
+}
+
+// startTestNode runs a Node on a loopback listener.
+func startTestNode(t *testing.T) *testNode {
+	t.Helper()
+	chain := &memoryChain{transactions: make(map[string]int), blocks: make(map[string]int)}
+	node := NewNode("test-chain", "genesis", Options{}, Handlers{
+		Height:      func() int { return 0 },
+		Transaction: chain.addTransaction,
+		Block:       chain.addBlock,
+	})
+	server := httptest.NewServer(node)
+	t.Cleanup(func() {
+		node.Close()
+		server.Close()
+	})
+	return &testNode{Node: node, chain: chain, server: server, url: "ws" + strings.TrimPrefix(server.URL, "http")}
+}
+
+// waitFor polls condition until it holds or a few seconds have passed.
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestGossipReachesEveryNodeOnce(t *testing.T) {
+	// Three nodes connected in a triangle, so every message reaches each
+	// node twice: straight from its origin and relayed by the third node.
+	nodes := []*testNode{startTestNode(t), startTestNode(t), startTestNode(t)}
+	for _, link := range [][2]int{{0, 1}, {1, 2}, {0, 2}} {
+		if _, err := nodes[link[0]].Connect(nodes[link[1]].url); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, node := range nodes {
+		waitFor(t, "every node to have two peers", func() bool { return len(node.Peers()) == 2 })
+	}
+
+	tx := structs.Transaction{TransactionId: "tx-1", From: "alice", To: "bob", Amount: 1}
+	block := &structs.Block{Version: structs.VRFBlockVersion, Index: 1, Hash: "block-1"}
+	nodes[0].BroadcastTransaction(tx)
+	nodes[0].BroadcastBlock(block)
+
+	for _, node := range nodes[1:] {
+		waitFor(t, "the transaction and the block to arrive", func() bool {
+			txs, blocks := node.chain.counts(tx.TransactionId, block.Hash)
+			return txs > 0 && blocks > 0
+		})
+	}
+
+	// Give the relayed copies time to arrive, then broadcast again from
+	// every node: the seen sets drop all of it.
+	time.Sleep(200 * time.Millisecond)
+	for _, node := range nodes {
+		node.BroadcastTransaction(tx)
+		node.BroadcastBlock(block)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	for i, node := range nodes {
+		txs, blocks := node.chain.counts(tx.TransactionId, block.Hash)
+		want := 1
+		if i == 0 {
+			// The origin sent them and never takes them back.
+			want = 0
+		}
+		if txs != want || blocks != want {
+			t.Errorf("node %d handled the transaction %d times and the block %d times, want %d", i, txs, blocks, want)
+		}
+	}
+	for i, node := range nodes {
+		for _, peer := range node.Peers() {
+			if info := peer.Info(); info.Score != 0 {
+				t.Errorf("node %d scored peer %s %d for honest gossip", i, peer.addr, info.Score)
+			}
+		}
+	}
+}
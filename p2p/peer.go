@@ -0,0 +1,393 @@
+package p2p
+
+import (
+	"errors"
+	"fmt"
+	"indicartcoin/structs"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Peer is one connection to another node.
+type Peer struct {
+	node        *Node
+	conn        *websocket.Conn
+	addr        string // URL dialed, or the remote address of an inbound connection
+	outbound    bool
+	hello       Hello
+	connectedAt time.Time
+
+	mu          sync.Mutex
+	height      int // the highest block index the peer announced or sent
+	nextRequest uint64
+	pending     map[uint64]chan Message // requests awaiting a response, by ID
+	latency     time.Duration           // round trip of the last ping, zero until one was answered
+	score       float64                 // misbehavior points, see Misbehaved
+	scoredAt    time.Time
+	windowStart time.Time // flood detection: messages read in the second from windowStart
+	windowCount int
+
+	send      chan Message
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// PeerInfo describes a connected peer.
+type PeerInfo struct {
+	NodeID        string    `json:"nodeId"`
+	Address       string    `json:"address"`
+	ListenURL     string    `json:"listenUrl,omitempty"` // as announced by the peer
+	Outbound      bool      `json:"outbound"`
+	Height        int       `json:"height"`
+	LatencyMillis int64     `json:"latencyMillis"`
+	Score         int       `json:"score"` // misbehavior points, banned at BanScore
+	ConnectedAt   time.Time `json:"connectedAt"`
+}
+
+// Info returns a description of the peer.
+func (p *Peer) Info() PeerInfo {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return PeerInfo{
+		NodeID:        p.hello.NodeID,
+		Address:       p.addr,
+		ListenURL:     p.hello.ListenURL,
+		Outbound:      p.outbound,
+		Height:        p.height,
+		LatencyMillis: p.latency.Milliseconds(),
+		Score:         int(p.decayedScore(time.Now())),
+		ConnectedAt:   p.connectedAt,
+	}
+}
+
+// Misbehaved adds points to the peer's misbehavior score. A peer that reaches
+// BanScore is disconnected and banned for Options.BanDuration.
+func (p *Peer) Misbehaved(points int, reason string) {
+	now := time.Now()
+	p.mu.Lock()
+	p.score = p.decayedScore(now) + float64(points)
+	p.scoredAt = now
+	score := p.score
+	p.mu.Unlock()
+
+	log.Printf("Peer %s misbehaved (+%d, score %.0f): %s", p.addr, points, score, reason)
+	if score >= BanScore {
+		p.node.ban(p, reason)
+	}
+}
+
+// decayedScore returns the score less one point for every minute since it
+// last rose. p.mu must be held.
+func (p *Peer) decayedScore(now time.Time) float64 {
+	return max(p.score-now.Sub(p.scoredAt).Minutes(), 0)
+}
+
+// Done is closed once the connection is closed.
+func (p *Peer) Done() <-chan struct{} {
+	return p.done
+}
+
+// ErrPeerClosed is returned for requests to a peer that disconnected before
+// it answered.
+var ErrPeerClosed = errors.New("peer disconnected")
+
+// GetHeaders asks the peer for up to count main-chain headers from index from
+// on. The headers are blocks without transactions; the peer may send fewer,
+// or none past its tip.
+func (p *Peer) GetHeaders(from, count int) ([]structs.Block, error) {
+	resp, err := p.request(Message{Type: MsgGetHeaders, From: from, Count: count}, MsgHeaders)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Headers) > count {
+		return nil, fmt.Errorf("peer sent %d headers, %d were asked for", len(resp.Headers), count)
+	}
+	return resp.Headers, nil
+}
+
+// GetBlocks asks the peer for up to count main-chain blocks from index from
+// on. The peer may send fewer, or none past its tip.
+func (p *Peer) GetBlocks(from, count int) ([]*structs.Block, error) {
+	resp, err := p.request(Message{Type: MsgGetBlocks, From: from, Count: count}, MsgBlocks)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Blocks) > count {
+		return nil, fmt.Errorf("peer sent %d blocks, %d were asked for", len(resp.Blocks), count)
+	}
+	for _, block := range resp.Blocks {
+		if block == nil {
+			return nil, errors.New("peer sent an empty block")
+		}
+	}
+	return resp.Blocks, nil
+}
+
+// GetPeers asks the peer for addresses of other nodes.
+func (p *Peer) GetPeers() ([]string, error) {
+	resp, err := p.request(Message{Type: MsgGetPeers}, MsgPeers)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Addresses) > maxAddresses {
+		p.Misbehaved(10, fmt.Sprintf("sent %d addresses", len(resp.Addresses)))
+		return nil, fmt.Errorf("peer sent %d addresses, at most %d are allowed", len(resp.Addresses), maxAddresses)
+	}
+	return resp.Addresses, nil
+}
+
+// request sends msg and waits for the response of type respType carrying the
+// same request ID.
+func (p *Peer) request(msg Message, respType string) (Message, error) {
+	reply := make(chan Message, 1)
+	p.mu.Lock()
+	p.nextRequest++
+	msg.RequestID = p.nextRequest
+	p.pending[msg.RequestID] = reply
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.pending, msg.RequestID)
+		p.mu.Unlock()
+	}()
+
+	p.queue(msg)
+	timer := time.NewTimer(requestTimeout)
+	defer timer.Stop()
+	select {
+	case resp := <-reply:
+		if resp.Type != respType {
+			return Message{}, fmt.Errorf("peer answered %s with %s", msg.Type, resp.Type)
+		}
+		return resp, nil
+	case <-p.done:
+		return Message{}, ErrPeerClosed
+	case <-timer.C:
+		return Message{}, fmt.Errorf("peer did not answer %s within %s", msg.Type, requestTimeout)
+	}
+}
+
+// Close closes the connection.
+func (p *Peer) Close() {
+	p.closeOnce.Do(func() {
+		close(p.done)
+		p.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+		p.conn.Close()
+		p.node.removePeer(p)
+		p.mu.Lock()
+		latency := p.latency
+		p.mu.Unlock()
+		if p.outbound {
+			p.node.book.disconnected(p.addr, latency)
+		} else if p.hello.ListenURL != "" {
+			p.node.book.disconnected(p.hello.ListenURL, latency)
+		}
+		log.Printf("Disconnected from peer %s (node %s)", p.addr, p.hello.NodeID)
+	})
+}
+
+// queue hands msg to the writer without blocking. A peer too slow to keep up
+// misses messages rather than holding up the node.
+func (p *Peer) queue(msg Message) {
+	select {
+	case p.send <- msg:
+	case <-p.done:
+	default:
+		log.Printf("Peer %s is not keeping up, dropping a %s message", p.addr, msg.Type)
+	}
+}
+
+// writeLoop sends queued messages and pings. Pings carry the time they were
+// sent, so the pong measures the round trip. The first goes out right away.
+func (p *Peer) writeLoop() {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	defer p.Close()
+
+	if err := p.ping(); err != nil {
+		return
+	}
+	for {
+		select {
+		case <-p.done:
+			return
+		case msg := <-p.send:
+			p.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := p.conn.WriteJSON(msg); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := p.ping(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (p *Peer) ping() error {
+	now := time.Now()
+	payload := strconv.FormatInt(now.UnixNano(), 10)
+	return p.conn.WriteControl(websocket.PingMessage, []byte(payload), now.Add(writeTimeout))
+}
+
+func (p *Peer) readLoop() {
+	defer p.Close()
+
+	p.conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	p.conn.SetPongHandler(func(payload string) error {
+		now := time.Now()
+		if sent, err := strconv.ParseInt(payload, 10, 64); err == nil && sent <= now.UnixNano() {
+			p.mu.Lock()
+			p.latency = now.Sub(time.Unix(0, sent))
+			p.mu.Unlock()
+		}
+		return p.conn.SetReadDeadline(now.Add(pongTimeout))
+	})
+	for {
+		var msg Message
+		if err := p.conn.ReadJSON(&msg); err != nil {
+			select {
+			case <-p.done:
+				// Closed on this side.
+			default:
+				if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+					log.Printf("Peer %s: %v", p.addr, err)
+				}
+			}
+			return
+		}
+		p.conn.SetReadDeadline(time.Now().Add(pongTimeout))
+		if p.flooding() {
+			p.Misbehaved(20, fmt.Sprintf("more than %d messages a second", maxMessagesPerSecond))
+		}
+		p.handle(msg)
+	}
+}
+
+// flooding counts a message read and reports, once per second, whether the
+// peer sent more than maxMessagesPerSecond in it.
+func (p *Peer) flooding() bool {
+	now := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if now.Sub(p.windowStart) >= time.Second {
+		p.windowStart = now
+		p.windowCount = 0
+	}
+	p.windowCount++
+	return p.windowCount == maxMessagesPerSecond+1
+}
+
+// handle passes a gossiped transaction or block to the node's handlers the
+// first time the node sees it, and relays it to the other peers if it was
+// accepted. One that is refused is forgotten again, so it is looked at anew
+// if it arrives once more, e.g. after its parent block. One the node already
+// had is dropped. A peer whose data fails verification is banned; refused
+// data, unknown messages and unrequested responses add smaller penalties.
+func (p *Peer) handle(msg Message) {
+	switch {
+	case msg.Type == MsgTransaction && msg.Transaction != nil:
+		key := transactionKey(msg.Transaction.TransactionId)
+		if !p.node.seen.add(key) {
+			return
+		}
+		if handler := p.node.handlers.Transaction; handler != nil {
+			if err := handler(*msg.Transaction); errors.Is(err, ErrKnown) {
+				return
+			} else if err != nil {
+				log.Printf("Transaction %s from peer %s refused: %v", msg.Transaction.TransactionId, p.addr, err)
+				p.node.seen.forget(key)
+				p.refused("transaction "+msg.Transaction.TransactionId, err)
+				return
+			}
+		}
+		p.node.relay(msg, p)
+	case msg.Type == MsgBlock && msg.Block != nil:
+		p.mu.Lock()
+		if msg.Block.Index > p.height {
+			p.height = msg.Block.Index
+		}
+		p.mu.Unlock()
+		key := blockKey(msg.Block.Hash)
+		if !p.node.seen.add(key) {
+			return
+		}
+		if handler := p.node.handlers.Block; handler != nil {
+			if err := handler(msg.Block); errors.Is(err, ErrKnown) {
+				return
+			} else if err != nil {
+				log.Printf("Block %d from peer %s refused: %v", msg.Block.Index, p.addr, err)
+				p.node.seen.forget(key)
+				p.refused(fmt.Sprintf("block %d", msg.Block.Index), err)
+				return
+			}
+		}
+		p.node.relay(msg, p)
+	case msg.Type == MsgGetHeaders || msg.Type == MsgGetBlocks || msg.Type == MsgGetPeers:
+		p.serve(msg)
+	case msg.Type == MsgHeaders || msg.Type == MsgBlocks || msg.Type == MsgPeers:
+		p.mu.Lock()
+		if msg.Height > p.height {
+			p.height = msg.Height
+		}
+		reply := p.pending[msg.RequestID]
+		p.mu.Unlock()
+		if reply == nil {
+			// Possibly the late answer to a request that timed out.
+			p.Misbehaved(2, "unrequested "+msg.Type+" message")
+			return
+		}
+		select {
+		case reply <- msg:
+		default:
+		}
+	default:
+		p.Misbehaved(10, fmt.Sprintf("unknown or malformed %q message", msg.Type))
+	}
+}
+
+// refused penalizes the peer for gossip the node's handlers refused: fully
+// for data that fails verification, a point otherwise, as a valid
+// transaction can turn stale on its way, e.g. when its nonce was used.
+func (p *Peer) refused(what string, err error) {
+	if IsInvalid(err) {
+		p.Misbehaved(BanScore, fmt.Sprintf("invalid %s: %v", what, err))
+		return
+	}
+	p.Misbehaved(1, fmt.Sprintf("refused %s", what))
+}
+
+// serve answers a request for headers, blocks or peer addresses. Counts above
+// the per-request limits are cut down to them.
+func (p *Peer) serve(msg Message) {
+	resp := Message{RequestID: msg.RequestID}
+	if height := p.node.handlers.Height; height != nil {
+		resp.Height = height()
+	}
+	switch msg.Type {
+	case MsgGetHeaders:
+		resp.Type = MsgHeaders
+		if handler := p.node.handlers.Headers; handler != nil && msg.Count > 0 {
+			resp.Headers = handler(msg.From, min(msg.Count, MaxHeadersPerRequest))
+		}
+	case MsgGetBlocks:
+		resp.Type = MsgBlocks
+		if handler := p.node.handlers.Blocks; handler != nil && msg.Count > 0 {
+			resp.Blocks = handler(msg.From, min(msg.Count, MaxBlocksPerRequest))
+		}
+	case MsgGetPeers:
+		resp.Type = MsgPeers
+		for _, address := range p.node.addresses() {
+			if address != p.addr && address != p.hello.ListenURL {
+				resp.Addresses = append(resp.Addresses, address)
+			}
+		}
+	}
+	p.queue(resp)
+}
@@ -0,0 +1,108 @@
+package p2p_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"indicartcoin/blockchain"
+	"indicartcoin/config"
+	"indicartcoin/database"
+	"indicartcoin/forkchoice"
+	"indicartcoin/p2p"
+	"indicartcoin/sqldatabase"
+	"indicartcoin/state"
+	"indicartcoin/structs"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestGossipedUnknownStatusKeepsNodeUp sends a node a signed transaction
+// with a status outside TransactionStatus through the real submission path
+// and SQLite storage. The node must refuse it and go on taking gossip from
+// the same peer.
+func TestGossipedUnknownStatusKeepsNodeUp(t *testing.T) {
+	store, err := sqldatabase.OpenSQLite(filepath.Join(t.TempDir(), "chain.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if _, err := store.Migrate(false); err != nil {
+		t.Fatal(err)
+	}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender := blockchain.PublicKeyPEM(key)
+	store.UpdateBalance(sender, 100)
+
+	sqldatabase.UseStore(store)
+	database.ChainConfig = config.Default().Chain
+	database.ChainConfig.ChainID = "test"
+	database.Peers = nil
+	database.Blockchain = structs.Blockchain{Mutex: &sync.Mutex{}, Blocks: []*structs.Block{}}
+	database.SideBlocks = forkchoice.NewTree()
+	database.AppState = &state.State{
+		Balances:     sqldatabase.LoadBalances(),
+		Nonces:       map[string]uint64{},
+		ArtOwnership: map[string]structs.ArtOwnership{},
+		Stakes:       map[string]structs.Amount{},
+		ChainID:      "test",
+	}
+
+	receiver := p2p.NewNode("test", "", p2p.Options{}, p2p.Handlers{
+		Height:      func() int { return 0 },
+		Transaction: database.SubmitTransaction,
+	})
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	defer receiver.Close()
+	origin := p2p.NewNode("test", "", p2p.Options{}, p2p.Handlers{Height: func() int { return 0 }})
+	defer origin.Close()
+	if _, err := origin.Connect("ws" + strings.TrimPrefix(server.URL, "http")); err != nil {
+		t.Fatal(err)
+	}
+
+	signed := func(id string, nonce uint64, status structs.TransactionStatus) structs.Transaction {
+		tx := structs.Transaction{
+			TransactionId: id,
+			Type:          structs.CoinTransfer,
+			From:          sender,
+			To:            "bob",
+			Amount:        1,
+			Status:        status,
+			Version:       structs.NoncedTransactionVersion,
+			Nonce:         nonce,
+		}
+		// The status is not signed, so a relaying peer can set any value.
+		if tx.Signature, err = blockchain.Sign(tx.SignedMessage("test"), key); err != nil {
+			t.Fatal(err)
+		}
+		return tx
+	}
+	origin.BroadcastTransaction(signed("bad-status", 0, 7))
+	origin.BroadcastTransaction(signed("good", 0, structs.Pending))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, pooled := database.Mempool.Get("good"); pooled {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the transaction after the bad one never reached the pool")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, pooled := database.Mempool.Get("bad-status"); pooled {
+		t.Error("transaction with an unknown status was pooled")
+	}
+	if pending := sqldatabase.LoadPendingTransactions(); len(pending) != 1 || pending[0].TransactionId != "good" {
+		t.Errorf("stored pending pool %v, want only the good transaction", pending)
+	}
+	if peers := receiver.Peers(); len(peers) != 1 {
+		t.Errorf("receiver has %d peers after the bad transaction, want 1", len(peers))
+	}
+}
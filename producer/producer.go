@@ -0,0 +1,90 @@
+package producer
+
+import (
+	"indicartcoin/database"
+	"log"
+	"time"
+)
+
+// Producer seals blocks in the background: at the latest every interval, and
+// sooner whenever the mempool holds a full block.
+type Producer struct {
+	interval     time.Duration
+	produceEmpty bool
+	hold         func() bool
+	stop         chan struct{}
+	done         chan struct{}
+}
+
+// Start launches a producer sealing a block at least every interval. With
+// produceEmpty set a block is sealed on schedule even when the mempool is
+// empty; otherwise the interval passes without one. No block is sealed while
+// hold, if set, returns true, such as while the node is catching up with its
+// peers.
+func Start(interval time.Duration, produceEmpty bool, hold func() bool) *Producer {
+	p := &Producer{
+		interval:     interval,
+		produceEmpty: produceEmpty,
+		hold:         hold,
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// Stop asks the producer to exit and waits until it has. A block being
+// sealed when Stop is called is finished first.
+func (p *Producer) Stop() {
+	close(p.stop)
+	<-p.done
+}
+
+func (p *Producer) run() {
+	defer close(p.done)
+
+	timer := time.NewTimer(p.interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-timer.C:
+			p.seal(p.produceEmpty)
+		case <-database.PoolChanged:
+			if !database.BlockFull() {
+				continue
+			}
+			if !timer.Stop() {
+				<-timer.C
+			}
+			p.seal(false)
+		}
+		timer.Reset(p.interval)
+	}
+}
+
+// seal seals one block, then keeps going while the pool still holds a full
+// block so a burst of transactions is not held back by the interval.
+func (p *Producer) seal(allowEmpty bool) {
+	for {
+		if p.hold != nil && p.hold() {
+			return
+		}
+		block := database.SealBlock(allowEmpty)
+		if block == nil {
+			return
+		}
+		log.Printf("Sealed block %d with %d transactions", block.Index, len(block.Transactions))
+		if !database.BlockFull() {
+			return
+		}
+		select {
+		case <-p.stop:
+			return
+		default:
+		}
+		allowEmpty = false
+	}
+}
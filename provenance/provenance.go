@@ -0,0 +1,156 @@
+package provenance
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"indicartcoin/blockchain"
+	"indicartcoin/sqldatabase"
+	"indicartcoin/structs"
+	"time"
+)
+
+// Event types in a Timeline.
+const (
+	Uploaded    = "upload"
+	Updated     = "update"
+	Transferred = "transfer"
+)
+
+// ErrUnknownArt is returned for an art ID no mined transaction mentions.
+var ErrUnknownArt = errors.New("no mined transactions for this art")
+
+// Event is one mined transaction in the life of an art piece.
+type Event struct {
+	Type          string         `json:"type"`
+	TransactionId string         `json:"transactionId"`
+	Owner         string         `json:"owner"`                   // owner after the event
+	PreviousOwner string         `json:"previousOwner,omitempty"` // set for transfers
+	Price         structs.Amount `json:"price"`                   // listed price after the event
+	Amount        structs.Amount `json:"amount"`                  // coins sent with the transaction; for a transfer, what the new owner paid
+	BlockIndex    int            `json:"blockIndex"`
+	Position      int            `json:"position"`
+	BlockTime     string         `json:"blockTime,omitempty"` // RFC 3339, empty for blocks before migration 0009
+}
+
+// Timeline is the ownership history of an art piece, oldest event first, as
+// recorded in the chain.
+type Timeline struct {
+	ArtID        string  `json:"artId"`
+	ArtName      string  `json:"artName"`
+	CurrentOwner string  `json:"currentOwner"`
+	Events       []Event `json:"events"`
+}
+
+// Build reconstructs the timeline of artID from the ArtUpload, ArtUpdate and
+// ArtTransfer transactions in the chain, replaying them in block order.
+func Build(artID string) (*Timeline, error) {
+	var txs []sqldatabase.TransactionRecord
+	query := sqldatabase.TransactionQuery{ArtID: artID, Limit: sqldatabase.MaxSearchLimit}
+	for {
+		page, err := sqldatabase.SearchTransactions(query)
+		if err != nil {
+			return nil, err
+		}
+		txs = append(txs, page.Transactions...)
+		if page.NextCursor == "" {
+			break
+		}
+		query.Cursor = page.NextCursor
+	}
+	if len(txs) == 0 {
+		return nil, ErrUnknownArt
+	}
+
+	timeline := &Timeline{ArtID: artID, Events: []Event{}}
+	var price structs.Amount
+	// Search results are newest first.
+	for i := len(txs) - 1; i >= 0; i-- {
+		record := txs[i]
+		tx := record.Transaction
+		event := Event{
+			TransactionId: tx.TransactionId,
+			Amount:        tx.Amount,
+			BlockIndex:    record.BlockIndex,
+			Position:      record.Position,
+			BlockTime:     record.BlockTime,
+		}
+		switch tx.Type {
+		case structs.ArtUpload:
+			event.Type = Uploaded
+			timeline.CurrentOwner = tx.From
+			timeline.ArtName = tx.ArtOwnership.ArtName
+			price = tx.ArtOwnership.Price
+		case structs.ArtUpdate:
+			event.Type = Updated
+			timeline.ArtName = tx.ArtOwnership.ArtName
+			price = tx.ArtOwnership.Price
+		case structs.ArtTransfer:
+			event.Type = Transferred
+			event.PreviousOwner = tx.From
+			timeline.CurrentOwner = tx.To
+		default:
+			continue
+		}
+		event.Owner = timeline.CurrentOwner
+		event.Price = price
+		timeline.Events = append(timeline.Events, event)
+	}
+	return timeline, nil
+}
+
+// certificateDomain separates certificate signatures from anything else the
+// node key signs. The signed message is certificateDomain || 0x00 || Payload.
+const certificateDomain = "INDICARTCOIN_PROVENANCE_V1"
+
+// Certificate is a timeline signed by the node that issued it. Payload is the
+// JSON encoding of a CertificateBody, kept as the exact string that was signed
+// so any client can check the signature before parsing it.
+type Certificate struct {
+	Payload   string `json:"payload"`
+	Issuer    string `json:"issuer"`    // the node's public key (PEM)
+	Signature string `json:"signature"` // Base64 RSA PKCS#1 v1.5 over SHA-256
+}
+
+// CertificateBody is what a Certificate attests to.
+type CertificateBody struct {
+	ChainID  string   `json:"chainId"`
+	Height   int      `json:"height"` // chain height the timeline was read at
+	IssuedAt string   `json:"issuedAt"`
+	Timeline Timeline `json:"provenance"`
+}
+
+// Issue signs timeline with key.
+func Issue(timeline *Timeline, chainID string, height int, key *rsa.PrivateKey) (*Certificate, error) {
+	payload, err := json.Marshal(CertificateBody{
+		ChainID:  chainID,
+		Height:   height,
+		IssuedAt: time.Now().UTC().Format(time.RFC3339),
+		Timeline: *timeline,
+	})
+	if err != nil {
+		return nil, err
+	}
+	signature, err := blockchain.Sign(certificateDomain+"\x00"+string(payload), key)
+	if err != nil {
+		return nil, err
+	}
+	return &Certificate{Payload: string(payload), Issuer: blockchain.PublicKeyPEM(key), Signature: signature}, nil
+}
+
+// Verify checks the certificate's signature against its issuer and returns
+// the body it attests to. Whether the issuer is trusted is up to the caller.
+func (c *Certificate) Verify() (*CertificateBody, error) {
+	valid, err := blockchain.VerifySignature(certificateDomain+"\x00"+c.Payload, c.Signature, c.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	if !valid {
+		return nil, errors.New("invalid certificate signature")
+	}
+	var body CertificateBody
+	if err := json.Unmarshal([]byte(c.Payload), &body); err != nil {
+		return nil, err
+	}
+	return &body, nil
+}
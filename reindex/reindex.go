@@ -0,0 +1,295 @@
+package reindex
+
+import (
+	"errors"
+	"fmt"
+	"indicartcoin/config"
+	"indicartcoin/sqldatabase"
+	"indicartcoin/state"
+	"indicartcoin/structs"
+	"indicartcoin/validator"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// Issue is something in the chain that the state transition would not accept
+// as recorded. Replay still goes on so the rest of the chain can be checked.
+type Issue struct {
+	BlockIndex    int    `json:"blockIndex"`
+	TransactionId string `json:"transactionId"`
+	Problem       string `json:"problem"`
+}
+
+// Discrepancy is a stored value that differs from the one the replay derived.
+// Missing values are reported as empty strings.
+type Discrepancy struct {
+	Kind     string `json:"kind"` // structs.BalanceChange, NonceChange, StakeChange, ArtOwnerChange, or "artPrice", "artStatus", "artLikes"
+	Key      string `json:"key"`  // address or art ID
+	Stored   string `json:"stored"`
+	Replayed string `json:"replayed"`
+}
+
+// Report is the outcome of a Replay.
+type Report struct {
+	Blocks       int `json:"blocks"`
+	Transactions int `json:"transactions"` // applied, including those that needed unaccounted funds
+
+	// UnaccountedFunds is what senders and buyers spent beyond the balance the chain
+	// gives them, summed over every ErrInsufficientBalance issue. It covers
+	// coins that entered storage without a transaction or a genesis
+	// allocation, such as balances seeded by hand.
+	UnaccountedFunds structs.Amount `json:"unaccountedFunds"`
+	// Fees is the total of every fee paid. Each block's fees are credited to
+	// its proposer and validators as when the block was applied (see
+	// validator.Rewards).
+	Fees structs.Amount `json:"fees"`
+
+	Issues        []Issue       `json:"issues"`
+	Discrepancies []Discrepancy `json:"discrepancies"`
+
+	derived sqldatabase.DerivedState
+}
+
+// Replay rebuilds balances, nonces, stakes and art ownership by applying every
+// stored block, in order, to a state holding only the genesis allocations and
+// stakes, takes like counts from the art_likes table, and compares the result
+// with what is stored now. Nothing is written; see Write. Each block's fees
+// are paid out with the stakes as replayed up to its parent and the chain's
+// rewardDecayConstant, as the node did when it applied the block.
+//
+// A transaction whose sender, or buyer for an ArtTransfer, is short of funds
+// is applied anyway, crediting the shortfall to that account first, because
+// the block it is in was accepted and later transactions depend on it. Any
+// other rejection is reported and the transaction is skipped.
+func Replay(chain config.ChainConfig, allocations map[string]structs.Amount, stakes map[string]structs.Amount) (*Report, error) {
+	blocks, err := sqldatabase.LoadAllBlocks()
+	if err != nil {
+		return nil, fmt.Errorf("loading blocks: %v", err)
+	}
+	likes, err := sqldatabase.CountLikes()
+	if err != nil {
+		return nil, fmt.Errorf("counting likes: %v", err)
+	}
+
+	report := &Report{Issues: []Issue{}, Discrepancies: []Discrepancy{}}
+	replayed := &state.State{
+		Balances:     make(map[string]structs.Amount),
+		ArtOwnership: make(map[string]structs.ArtOwnership),
+		Nonces:       make(map[string]uint64),
+		Stakes:       make(map[string]structs.Amount),
+		ChainID:      chain.ChainID,
+	}
+	for address, balance := range allocations {
+		replayed.Balances[address] = balance
+	}
+	for address, stake := range stakes {
+		replayed.Stakes[address] = stake
+	}
+	for _, block := range blocks {
+		report.Blocks++
+		vals := replayed.Validators()
+		fees := structs.Amount(0)
+		for _, tx := range block.Transactions {
+			if report.apply(replayed, block.Index, tx) {
+				fees += tx.Fee
+			}
+		}
+		// Blocks without transactions pay nothing out, as in database.applyBlock.
+		if len(block.Transactions) > 0 {
+			for _, reward := range validator.Rewards(block.Proposer, block.Seed(), vals, fees, chain.RewardDecayConstant) {
+				replayed.Balances[reward.Address] += reward.Amount
+			}
+			report.Fees += fees
+		}
+	}
+
+	stored := sqldatabase.DerivedState{
+		Balances:     sqldatabase.LoadBalances(),
+		Nonces:       sqldatabase.LoadNonces(),
+		ArtOwnership: sqldatabase.LoadArtOwnership(),
+		Stakes:       make(map[string]structs.Amount),
+	}
+	for _, val := range sqldatabase.LoadValidators() {
+		stored.Stakes[val.Address] = val.Stake
+	}
+	report.derived = sqldatabase.DerivedState{
+		Balances:     replayed.Balances,
+		Nonces:       replayed.Nonces,
+		ArtOwnership: make(map[string]structs.ArtOwnership, len(replayed.ArtOwnership)),
+		Stakes:       make(map[string]structs.Amount),
+	}
+	for address, stake := range replayed.Stakes {
+		if stake > 0 {
+			report.derived.Stakes[address] = stake
+		}
+	}
+	for artID, art := range replayed.ArtOwnership {
+		art.ArtLikes = likes[artID]
+		// Version 0 transactions only carry the art ID, so the media and
+		// description can only come from the stored row.
+		if existing, exists := stored.ArtOwnership[artID]; exists && art.ArtName == "" && art.Art == "" {
+			owner, price, status := art.ArtOwner, art.Price, art.Status
+			art = existing
+			art.ArtOwner, art.Price, art.Status = owner, price, status
+			art.ArtLikes = likes[artID]
+		}
+		report.derived.ArtOwnership[artID] = art
+	}
+	report.compare(stored, likes)
+
+	for artID, art := range stored.ArtOwnership {
+		if _, replayed := report.derived.ArtOwnership[artID]; !replayed && art.ArtLikes != likes[artID] {
+			art.ArtLikes = likes[artID]
+			report.derived.ArtOwnership[artID] = art
+		}
+	}
+	return report, nil
+}
+
+// apply applies tx to replayed and reports whether it went in.
+func (r *Report) apply(replayed *state.State, blockIndex int, tx structs.Transaction) bool {
+	err := replayed.Apply(tx)
+	// Both sides of an ArtTransfer can be short: the buyer of the price and
+	// the seller of the fee. Each is credited in turn.
+	var txErr *state.TransactionError
+	for tries := 0; tries < 2 && errors.As(err, &txErr) && errors.Is(txErr, state.ErrInsufficientBalance); tries++ {
+		shortfall := txErr.Needs - replayed.Balances[txErr.Account]
+		r.UnaccountedFunds += shortfall
+		r.Issues = append(r.Issues, Issue{blockIndex, tx.TransactionId, fmt.Sprintf("%s spends %s it never received", payer(tx, txErr.Account), shortfall)})
+		replayed.Balances[txErr.Account] += shortfall
+		err = replayed.Apply(tx)
+	}
+	if err != nil {
+		r.Issues = append(r.Issues, Issue{blockIndex, tx.TransactionId, err.Error()})
+		return false
+	}
+	r.Transactions++
+	return true
+}
+
+// payer names the side of tx that account is.
+func payer(tx structs.Transaction, account string) string {
+	if tx.Type == structs.ArtTransfer && account == tx.To {
+		return "buyer"
+	}
+	return "sender"
+}
+
+func (r *Report) compare(stored sqldatabase.DerivedState, likes map[string]int) {
+	for _, address := range unionKeys(stored.Balances, r.derived.Balances) {
+		storedBalance, inStore := stored.Balances[address]
+		replayedBalance, inReplay := r.derived.Balances[address]
+		if storedBalance != replayedBalance {
+			r.add(structs.BalanceChange, address, amountString(storedBalance, inStore), amountString(replayedBalance, inReplay))
+		}
+	}
+	for _, address := range unionKeys(stored.Nonces, r.derived.Nonces) {
+		storedNonce, inStore := stored.Nonces[address]
+		replayedNonce, inReplay := r.derived.Nonces[address]
+		if storedNonce != replayedNonce {
+			r.add(structs.NonceChange, address, nonceString(storedNonce, inStore), nonceString(replayedNonce, inReplay))
+		}
+	}
+	for _, address := range unionKeys(stored.Stakes, r.derived.Stakes) {
+		storedStake, inStore := stored.Stakes[address]
+		replayedStake, inReplay := r.derived.Stakes[address]
+		if storedStake != replayedStake {
+			r.add(structs.StakeChange, address, amountString(storedStake, inStore), amountString(replayedStake, inReplay))
+		}
+	}
+	for _, artID := range unionKeys(stored.ArtOwnership, r.derived.ArtOwnership) {
+		storedArt, inStore := stored.ArtOwnership[artID]
+		replayedArt, inReplay := r.derived.ArtOwnership[artID]
+		switch {
+		case !inReplay:
+			// A pending upload is not on the chain yet; a mined one has to be.
+			if storedArt.Status != structs.Pending {
+				r.add("artStatus", artID, storedArt.Status.String(), "")
+			}
+			if storedArt.ArtLikes != likes[artID] {
+				r.add("artLikes", artID, strconv.Itoa(storedArt.ArtLikes), strconv.Itoa(likes[artID]))
+			}
+		case !inStore:
+			r.add(structs.ArtOwnerChange, artID, "", replayedArt.ArtOwner)
+		default:
+			if storedArt.ArtOwner != replayedArt.ArtOwner {
+				r.add(structs.ArtOwnerChange, artID, storedArt.ArtOwner, replayedArt.ArtOwner)
+			}
+			if storedArt.Price != replayedArt.Price {
+				r.add("artPrice", artID, storedArt.Price.String(), replayedArt.Price.String())
+			}
+			// The SQL stores read every mined status back as Completed.
+			if storedArt.Status == structs.Pending {
+				r.add("artStatus", artID, storedArt.Status.String(), replayedArt.Status.String())
+			}
+			if storedArt.ArtLikes != replayedArt.ArtLikes {
+				r.add("artLikes", artID, strconv.Itoa(storedArt.ArtLikes), strconv.Itoa(replayedArt.ArtLikes))
+			}
+		}
+	}
+}
+
+func (r *Report) add(kind, key, stored, replayed string) {
+	r.Discrepancies = append(r.Discrepancies, Discrepancy{Kind: kind, Key: key, Stored: stored, Replayed: replayed})
+}
+
+// Write replaces the stored balances, nonces, stakes and art rows with the
+// replayed ones in a single database transaction. Art rows the chain does not mention
+// only get their like counts corrected.
+func (r *Report) Write() error {
+	return sqldatabase.ReplaceDerivedState(r.derived)
+}
+
+// Print writes a human readable summary of the report to w.
+func (r *Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "Replayed %d transactions in %d blocks\n", r.Transactions, r.Blocks)
+	fmt.Fprintf(w, "Fees paid: %s\n", r.Fees)
+	fmt.Fprintf(w, "Unaccounted funds: %s\n", r.UnaccountedFunds)
+	for _, issue := range r.Issues {
+		fmt.Fprintf(w, "block %d, transaction %s: %s\n", issue.BlockIndex, issue.TransactionId, issue.Problem)
+	}
+	if len(r.Discrepancies) == 0 {
+		fmt.Fprintln(w, "Stored state matches the chain")
+		return
+	}
+	fmt.Fprintf(w, "%d discrepancies:\n", len(r.Discrepancies))
+	for _, d := range r.Discrepancies {
+		fmt.Fprintf(w, "%s %q: stored %s, replayed %s\n", d.Kind, d.Key, orNone(d.Stored), orNone(d.Replayed))
+	}
+}
+
+func unionKeys[V any](a, b map[string]V) []string {
+	keys := make([]string, 0, len(a)+len(b))
+	for key := range a {
+		keys = append(keys, key)
+	}
+	for key := range b {
+		if _, exists := a[key]; !exists {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func amountString(amount structs.Amount, present bool) string {
+	if !present {
+		return ""
+	}
+	return amount.String()
+}
+
+func nonceString(nonce uint64, present bool) string {
+	if !present {
+		return ""
+	}
+	return strconv.FormatUint(nonce, 10)
+}
+
+func orNone(value string) string {
+	if value == "" {
+		return "(none)"
+	}
+	return value
+}
@@ -0,0 +1,155 @@
+package reindex_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"indicartcoin/blockchain"
+	"indicartcoin/config"
+	"indicartcoin/database"
+	"indicartcoin/forkchoice"
+	"indicartcoin/reindex"
+	"indicartcoin/sqldatabase"
+	"indicartcoin/state"
+	"indicartcoin/structs"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// startChain resets the database package to an empty chain on a new SQLite
+// store, the way main does at startup, with a node key that seals every block
+// as soon as its parent is sealed.
+func startChain(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	store, err := sqldatabase.OpenSQLite(filepath.Join(t.TempDir(), "chain.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	if _, err := store.Migrate(false); err != nil {
+		t.Fatal(err)
+	}
+	nodeKey := newKey(t)
+
+	sqldatabase.UseStore(store)
+	database.ChainConfig = config.Default().Chain
+	database.ChainConfig.ChainID = "test"
+	// Every validator's turn has come as soon as the parent is sealed.
+	database.ChainConfig.BlockInterval = 0
+	database.NodeKey = nodeKey
+	database.Peers = nil
+	database.Blockchain = structs.Blockchain{Mutex: &sync.Mutex{}, Blocks: []*structs.Block{}}
+	database.SideBlocks = forkchoice.NewTree()
+	database.AppState = &state.State{
+		Balances:     map[string]structs.Amount{},
+		Nonces:       map[string]uint64{},
+		ArtOwnership: map[string]structs.ArtOwnership{},
+		Stakes:       map[string]structs.Amount{},
+		ChainID:      "test",
+	}
+	return nodeKey
+}
+
+func newKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// seedBalance gives address a balance that no transaction or allocation
+// accounts for, in storage and in the node's state.
+func seedBalance(address string, balance structs.Amount) {
+	sqldatabase.UpdateBalance(address, balance)
+	database.AppState.Balances[address] = balance
+}
+
+// seal signs tx from key, and as buyer with buyerKey if given, and seals it
+// in a block of its own.
+func seal(t *testing.T, tx structs.Transaction, key, buyerKey *rsa.PrivateKey) {
+	t.Helper()
+	var err error
+	tx.From = blockchain.PublicKeyPEM(key)
+	tx.Version = structs.NoncedTransactionVersion
+	if tx.Signature, err = blockchain.Sign(tx.SignedMessage("test"), key); err != nil {
+		t.Fatal(err)
+	}
+	if buyerKey != nil {
+		if tx.BuyerSignature, err = blockchain.Sign(tx.SignedMessage("test"), buyerKey); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := database.SubmitTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	if database.SealBlock(false) == nil {
+		t.Fatalf("transaction %s was not sealed", tx.TransactionId)
+	}
+}
+
+// TestReplayCreditsFees seals a block that makes the sender a validator and
+// one that pays a fee shared between the proposer and that validator, then
+// replays the chain: the replayed balances must match the stored ones.
+func TestReplayCreditsFees(t *testing.T) {
+	nodeKey := startChain(t)
+	senderKey := newKey(t)
+	sender := blockchain.PublicKeyPEM(senderKey)
+	seedBalance(sender, 100)
+
+	seal(t, structs.Transaction{TransactionId: "stake", Type: structs.Stake, To: sender, Amount: 40, Fee: 3, Nonce: 0}, senderKey, nil)
+	seal(t, structs.Transaction{TransactionId: "pay", Type: structs.CoinTransfer, To: "bob", Amount: 10, Fee: 20, Nonce: 1}, senderKey, nil)
+
+	proposer := blockchain.PublicKeyPEM(nodeKey)
+	stored := sqldatabase.LoadBalances()
+	if stored[proposer] <= 3 || stored[proposer] >= 23 {
+		t.Fatalf("stored proposer balance %s, want all of the first fee and part of the second", stored[proposer])
+	}
+
+	report, err := reindex.Replay(database.ChainConfig, map[string]structs.Amount{sender: 100}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Fees != 23 {
+		t.Errorf("replay counted %s in fees, want 0.000000023", report.Fees)
+	}
+	if len(report.Issues) != 0 {
+		t.Errorf("replay found issues %v, want none", report.Issues)
+	}
+	if len(report.Discrepancies) != 0 {
+		t.Errorf("replay differs from storage: %v", report.Discrepancies)
+	}
+}
+
+// TestReplayCreditsShortBuyer sells art to a buyer whose coins were seeded by
+// hand. The buyer pays the price, so the shortfall is the buyer's, not the
+// seller's, and the replay must end up with the stored balances.
+func TestReplayCreditsShortBuyer(t *testing.T) {
+	startChain(t)
+	sellerKey, buyerKey := newKey(t), newKey(t)
+	seller, buyer := blockchain.PublicKeyPEM(sellerKey), blockchain.PublicKeyPEM(buyerKey)
+	seedBalance(seller, 10)
+	seedBalance(buyer, 30)
+
+	art := structs.ArtOwnership{Id: "art-1", ArtName: "Tide", Price: 30}
+	seal(t, structs.Transaction{TransactionId: "upload", Type: structs.ArtUpload, To: seller, ArtID: "art-1", ArtOwnership: art, Fee: 1, Nonce: 0}, sellerKey, nil)
+	seal(t, structs.Transaction{TransactionId: "sale", Type: structs.ArtTransfer, To: buyer, ArtID: "art-1", Amount: 30, Fee: 1, Nonce: 1}, sellerKey, buyerKey)
+
+	report, err := reindex.Replay(database.ChainConfig, map[string]structs.Amount{seller: 10}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.UnaccountedFunds != 30 {
+		t.Errorf("unaccounted funds %s, want the buyer's 0.000000030", report.UnaccountedFunds)
+	}
+	if len(report.Issues) != 1 || report.Issues[0].TransactionId != "sale" || !strings.HasPrefix(report.Issues[0].Problem, "buyer ") {
+		t.Errorf("replay found issues %v, want one for the buyer of the sale", report.Issues)
+	}
+	for _, d := range report.Discrepancies {
+		if d.Kind == structs.BalanceChange || d.Kind == structs.ArtOwnerChange {
+			t.Errorf("replay differs from storage: %+v", d)
+		}
+	}
+}
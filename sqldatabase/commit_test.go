@@ -0,0 +1,255 @@
+package sqldatabase
+
+import (
+	"indicartcoin/structs"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// openTestStore opens and migrates the SQLite database at path.
+func openTestStore(t *testing.T, path string) *SQLStore {
+	t.Helper()
+	s, err := OpenSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Migrate(false); err != nil {
+		s.Close()
+		t.Fatal(err)
+	}
+	return s
+}
+
+// restart closes s and opens the file again, as a node coming back up would.
+func restart(t *testing.T, s *SQLStore, path string) *SQLStore {
+	t.Helper()
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return openTestStore(t, path)
+}
+
+var testTransfer = structs.Transaction{
+	TransactionId: "tx-1",
+	Type:          structs.CoinTransfer,
+	From:          "alice",
+	To:            "bob",
+	Amount:        4,
+	Fee:           1,
+	Signature:     "sig",
+	Status:        structs.Pending,
+	Version:       structs.NoncedTransactionVersion,
+}
+
+// seedAccounts gives alice 10 and puts testTransfer in the pending pool, the
+// state before block 1.
+func seedAccounts(s *SQLStore) {
+	s.UpdateBalance("alice", 10)
+	s.AddPendingTransaction(testTransfer)
+}
+
+// testCommit is block 1 mining testTransfer, with carol as proposer taking
+// the fee.
+func testCommit() BlockCommit {
+	mined := testTransfer
+	mined.Status = structs.Completed
+	return BlockCommit{
+		Block: &structs.Block{
+			Version:        structs.VRFBlockVersion,
+			Index:          1,
+			TimestampNanos: 1700000000000000000,
+			Hash:           "block-1",
+			PrevHash:       "genesis",
+			MerkleRoot:     "root",
+			Proposer:       "carol",
+			Signature:      "block-sig",
+			VRFProof:       "proof",
+		},
+		Transactions: []structs.Transaction{mined},
+		Receipts: []structs.Receipt{{
+			TransactionId: mined.TransactionId,
+			Status:        structs.Completed,
+			BlockIndex:    1,
+			BlockHash:     "block-1",
+			RecordedAt:    "2023-11-14T22:13:20Z",
+		}},
+		Balances:     map[string]structs.Amount{"alice": 5, "bob": 4, "carol": 1},
+		Nonces:       map[string]uint64{"alice": 1},
+		ArtOwnership: map[string]structs.ArtOwnership{},
+		Stakes:       map[string]structs.Amount{},
+		Undo: BlockUndo{
+			Balances:     map[string]structs.Amount{"alice": 10, "bob": 0, "carol": 0},
+			Nonces:       map[string]uint64{"alice": 0},
+			ArtOwnership: map[string]structs.ArtOwnership{},
+		},
+	}
+}
+
+// checkBlockStored fails the test unless the block, its transaction, receipt,
+// balances and nonce are all stored (committed) or none of them are.
+func checkBlockStored(t *testing.T, s *SQLStore, committed bool) {
+	t.Helper()
+	blocks, err := s.LoadBlocks(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receipt, err := s.LoadReceipt(testTransfer.TransactionId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	balances := s.LoadBalances()
+	nonces := s.LoadNonces()
+	pending := s.LoadPendingTransactions()
+
+	if committed {
+		if len(blocks) != 1 || blocks[0].Hash != "block-1" || len(blocks[0].Transactions) != 1 {
+			t.Errorf("stored blocks %v, want block-1 with its transaction", blocks)
+		}
+		if receipt == nil || receipt.BlockIndex != 1 {
+			t.Errorf("receipt %v, want one for block 1", receipt)
+		}
+		if balances["alice"] != 5 || balances["bob"] != 4 || balances["carol"] != 1 {
+			t.Errorf("balances %v, want alice 5, bob 4, carol 1", balances)
+		}
+		if nonces["alice"] != 1 {
+			t.Errorf("nonces %v, want alice 1", nonces)
+		}
+		if len(pending) != 0 {
+			t.Errorf("pending %v, want none", pending)
+		}
+		return
+	}
+	if len(blocks) != 0 {
+		t.Errorf("stored blocks %v, want none", blocks)
+	}
+	if receipt != nil {
+		t.Errorf("receipt %v, want none", receipt)
+	}
+	if len(balances) != 1 || balances["alice"] != 10 {
+		t.Errorf("balances %v, want only alice 10", balances)
+	}
+	if len(nonces) != 0 {
+		t.Errorf("nonces %v, want none", nonces)
+	}
+	if len(pending) != 1 || pending[0].TransactionId != testTransfer.TransactionId {
+		t.Errorf("pending %v, want tx-1", pending)
+	}
+}
+
+func TestCommitBlockFailingPartwayWritesNothing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chain.db")
+	s := openTestStore(t, path)
+	defer func() { s.Close() }()
+	seedAccounts(s)
+
+	// The undo record is written last, after the block, transactions,
+	// receipts, balances and nonces; a row already in its place makes that
+	// final statement fail.
+	if _, err := s.db.Exec("INSERT INTO block_undo (block_index, undo) VALUES (1, '{}')"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CommitBlock(testCommit()); err == nil {
+		t.Fatal("commit succeeded despite the conflicting undo record")
+	}
+	s = restart(t, s, path)
+	checkBlockStored(t, s, false)
+
+	if _, err := s.db.Exec("DELETE FROM block_undo WHERE block_index = 1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CommitBlock(testCommit()); err != nil {
+		t.Fatal(err)
+	}
+	s = restart(t, s, path)
+	checkBlockStored(t, s, true)
+	if undo, err := s.LoadBlockUndo(1); err != nil || undo == nil || undo.Balances["alice"] != 10 {
+		t.Errorf("undo record %v, %v, want alice's balance of 10", undo, err)
+	}
+}
+
+func TestCrashDuringCommitBlockWritesNothing(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "chain.db")
+	s := openTestStore(t, path)
+	defer s.Close()
+	seedAccounts(s)
+
+	// Write the whole block inside a transaction, then copy the database
+	// files before it commits: the copy is what a crash at that moment
+	// leaves on disk.
+	tx, err := s.db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.writeBlockCommit(tx, testCommit()); err != nil {
+		tx.Rollback()
+		t.Fatal(err)
+	}
+	crashed := filepath.Join(dir, "crashed.db")
+	for _, suffix := range []string{"", "-wal"} {
+		if err := copyFile(path+suffix, crashed+suffix); err != nil {
+			tx.Rollback()
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	recovered := openTestStore(t, crashed)
+	defer recovered.Close()
+	checkBlockStored(t, recovered, false)
+
+	s2 := restart(t, s, path)
+	defer s2.Close()
+	checkBlockStored(t, s2, true)
+}
+
+func TestLoadBlocksFailsOnUnreadableRow(t *testing.T) {
+	s := openTestStore(t, filepath.Join(t.TempDir(), "chain.db"))
+	defer s.Close()
+	seedAccounts(s)
+	if err := s.CommitBlock(testCommit()); err != nil {
+		t.Fatal(err)
+	}
+
+	// A block must not load without a transaction it holds.
+	if _, err := s.db.Exec("UPDATE transactions SET Amount = 'lots' WHERE id = ?", testTransfer.TransactionId); err != nil {
+		t.Fatal(err)
+	}
+	if blocks, err := s.LoadBlocks(nil); err == nil {
+		t.Errorf("loaded %d blocks with an unreadable transaction, want an error", len(blocks))
+	}
+
+	if _, err := s.db.Exec("UPDATE transactions SET Amount = 4"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.db.Exec("UPDATE blocks SET version = 'two'"); err != nil {
+		t.Fatal(err)
+	}
+	if blocks, err := s.LoadBlocks(nil); err == nil {
+		t.Errorf("loaded %d blocks with an unreadable block row, want an error", len(blocks))
+	}
+}
+
+// copyFile copies src to dst; a missing src is skipped.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,786 @@
+package sqldatabase
+
+import (
+	"database/sql"
+	"fmt"
+	"indicartcoin/structs"
+	"log"
+	"math"
+	"sort"
+	"sync"
+)
+
+// MemoryStore is a Store that keeps every table in process memory. It mirrors
+// the behaviour of SQLStore closely enough to run a node or exercise the
+// packages above it without a database server; nothing survives a restart.
+type MemoryStore struct {
+	mu sync.Mutex
+
+	genesisHash  string
+	blocks       []*structs.Block
+	transactions []storedTransaction
+	pending      []structs.Transaction
+	receipts     map[string]structs.Receipt
+	balances     map[string]structs.Amount
+	nonces       map[string]uint64
+	artOwnership map[string]structs.ArtOwnership
+	artOrder     []string
+	users        map[string][]string
+	likes        map[string]map[string]bool
+	stakes       map[string]structs.Amount
+	media        map[string]memoryMedia
+	undo         map[int]BlockUndo
+	peers        map[string]structs.KnownPeer
+	bans         map[string]structs.PeerBan
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+type storedTransaction struct {
+	tx         structs.Transaction
+	blockIndex int
+	position   int
+	blockTime  int64 // Unix nanoseconds, 0 if unknown
+}
+
+type memoryMedia struct {
+	data      []byte
+	mediaType string
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		balances:     make(map[string]structs.Amount),
+		nonces:       make(map[string]uint64),
+		receipts:     make(map[string]structs.Receipt),
+		artOwnership: make(map[string]structs.ArtOwnership),
+		users:        make(map[string][]string),
+		stakes:       make(map[string]structs.Amount),
+		likes:        make(map[string]map[string]bool),
+		media:        make(map[string]memoryMedia),
+		undo:         make(map[int]BlockUndo),
+		peers:        make(map[string]structs.KnownPeer),
+		bans:         make(map[string]structs.PeerBan),
+	}
+}
+
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+func (m *MemoryStore) CommitGenesis(commit GenesisCommit) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.genesisHash != "" {
+		return fmt.Errorf("storage already holds genesis %s", m.genesisHash)
+	}
+	for _, val := range commit.Validators {
+		if _, exists := m.stakes[val.Address]; exists {
+			return fmt.Errorf("committing genesis: duplicate validator %s", val.Address)
+		}
+	}
+	m.genesisHash = commit.Hash
+	for address, balance := range commit.Balances {
+		m.balances[address] = balance
+	}
+	for _, val := range commit.Validators {
+		m.putStake(val.Address, val.Stake)
+	}
+	return nil
+}
+
+func (m *MemoryStore) LoadGenesisHash() (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.genesisHash, nil
+}
+
+func (m *MemoryStore) LoadValidators() []structs.Validator {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	vals := make([]structs.Validator, 0, len(m.stakes))
+	for address, stake := range m.stakes {
+		vals = append(vals, structs.Validator{Address: address, Stake: stake})
+	}
+	sort.Slice(vals, func(i, j int) bool { return vals[i].Address < vals[j].Address })
+	return vals
+}
+
+// putStake sets address's stake, removing the validator when it is zero.
+func (m *MemoryStore) putStake(address string, stake structs.Amount) {
+	if stake == 0 {
+		delete(m.stakes, address)
+		return
+	}
+	m.stakes[address] = stake
+}
+
+// SearchTransactions filters the stored transactions the way the SQL query
+// does and pages through them newest first.
+func (m *MemoryStore) SearchTransactions(query TransactionQuery) (*TransactionPage, error) {
+	cursor, err := decodeCursor(query.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	limit := searchLimit(query.Limit)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var matches []storedTransaction
+	for _, stored := range m.transactions {
+		tx := stored.tx
+		switch {
+		case query.Address != "" && tx.From != query.Address && tx.To != query.Address,
+			query.From != "" && tx.From != query.From,
+			query.To != "" && tx.To != query.To,
+			query.ArtID != "" && tx.ArtID != query.ArtID,
+			query.Type != nil && tx.Type != *query.Type,
+			query.MinBlock > 0 && stored.blockIndex < query.MinBlock,
+			query.MaxBlock > 0 && stored.blockIndex > query.MaxBlock,
+			!query.Since.IsZero() && (stored.blockTime == 0 || stored.blockTime < query.Since.UnixNano()),
+			!query.Until.IsZero() && (stored.blockTime == 0 || stored.blockTime >= query.Until.UnixNano()),
+			cursor != nil && (stored.blockIndex > cursor.blockIndex || stored.blockIndex == cursor.blockIndex && stored.position >= cursor.position):
+			continue
+		}
+		matches = append(matches, stored)
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].blockIndex != matches[j].blockIndex {
+			return matches[i].blockIndex > matches[j].blockIndex
+		}
+		return matches[i].position > matches[j].position
+	})
+
+	page := &TransactionPage{Transactions: []TransactionRecord{}}
+	for _, stored := range matches {
+		if len(page.Transactions) == limit {
+			last := page.Transactions[limit-1]
+			page.NextCursor = searchCursor{blockIndex: last.BlockIndex, position: last.Position}.encode()
+			break
+		}
+		tx := stored.tx
+		tx.ArtOwnership = copyArtOwnership(tx.ArtOwnership)
+		tx.Status = structs.Completed
+		page.Transactions = append(page.Transactions, TransactionRecord{
+			Transaction: tx,
+			BlockIndex:  stored.blockIndex,
+			Position:    stored.position,
+			BlockTime:   formatBlockTime(stored.blockTime),
+		})
+	}
+	return page, nil
+}
+
+func (m *MemoryStore) AddTransaction(tx structs.Transaction, blockIndex int, position int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, stored := range m.transactions {
+		if stored.tx.TransactionId == tx.TransactionId {
+			log.Println("Error adding transaction: duplicate id", tx.TransactionId)
+			return
+		}
+	}
+	m.transactions = append(m.transactions, storedTransaction{tx: tx, blockIndex: blockIndex, position: position})
+}
+
+func (m *MemoryStore) AddReceipt(receipt structs.Receipt) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.receipts[receipt.TransactionId] = copyReceipt(receipt)
+}
+
+func (m *MemoryStore) LoadReceipt(transactionId string) (*structs.Receipt, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	receipt, exists := m.receipts[transactionId]
+	if !exists {
+		return nil, nil
+	}
+	receipt = copyReceipt(receipt)
+	return &receipt, nil
+}
+
+func (m *MemoryStore) LoadPendingTransactions() []structs.Transaction {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var txs []structs.Transaction
+	for _, tx := range m.pending {
+		if tx.Status == structs.Pending {
+			txs = append(txs, tx)
+		}
+	}
+	return txs
+}
+
+func (m *MemoryStore) AddPendingTransaction(tx structs.Transaction) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, pending := range m.pending {
+		if pending.TransactionId == tx.TransactionId {
+			log.Println("Error adding transaction: duplicate id", tx.TransactionId)
+			return
+		}
+	}
+	m.pending = append(m.pending, tx)
+}
+
+func (m *MemoryStore) DeletePendingTransaction(transactionId string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, tx := range m.pending {
+		if tx.TransactionId == transactionId {
+			m.pending = append(m.pending[:i], m.pending[i+1:]...)
+			return
+		}
+	}
+}
+
+func (m *MemoryStore) UpdateBalance(address string, balance structs.Amount) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.balances[address] = balance
+}
+
+func (m *MemoryStore) AddBalances(address string, balance structs.Amount) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.balances[address]; exists {
+		log.Println("Error adding balance: duplicate address", address)
+		return
+	}
+	m.balances[address] = balance
+}
+
+func (m *MemoryStore) LoadBalances() map[string]structs.Amount {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	balances := make(map[string]structs.Amount, len(m.balances))
+	for address, balance := range m.balances {
+		balances[address] = balance
+	}
+	return balances
+}
+
+func (m *MemoryStore) UpdateNonce(address string, nonce uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nonces[address] = nonce
+}
+
+func (m *MemoryStore) LoadNonces() map[string]uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	nonces := make(map[string]uint64, len(m.nonces))
+	for address, nonce := range m.nonces {
+		nonces[address] = nonce
+	}
+	return nonces
+}
+
+func (m *MemoryStore) UpdateArtOwnership(artID string, artOwnership structs.ArtOwnership) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.artOwnership[artID]; !exists {
+		return nil
+	}
+	artOwnership.Id = artID
+	m.artOwnership[artID] = copyArtOwnership(artOwnership)
+	return nil
+}
+
+func (m *MemoryStore) FetchArtOwnershipByOwner(artOwner string) ([]structs.ArtOwnership, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var artOwnershipList []structs.ArtOwnership
+	for _, artID := range m.artOrder {
+		if art := m.artOwnership[artID]; art.ArtOwner == artOwner {
+			artOwnershipList = append(artOwnershipList, copyArtOwnership(art))
+		}
+	}
+	return artOwnershipList, nil
+}
+
+func (m *MemoryStore) LoadArtOwnership() map[string]structs.ArtOwnership {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	artOwnershipMap := make(map[string]structs.ArtOwnership, len(m.artOwnership))
+	for artID, art := range m.artOwnership {
+		artOwnershipMap[artID] = copyArtOwnership(art)
+	}
+	return artOwnershipMap
+}
+
+func (m *MemoryStore) AddArtOwnership(artOwnership structs.ArtOwnership) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.artOwnership[artOwnership.Id]; exists {
+		log.Println("Error adding art ownership: duplicate id", artOwnership.Id)
+		return
+	}
+	m.artOwnership[artOwnership.Id] = copyArtOwnership(artOwnership)
+	m.artOrder = append(m.artOrder, artOwnership.Id)
+}
+
+func (m *MemoryStore) LoadArtOwnershipSummary(start int, count int) map[string]structs.ArtOwnershipSummary {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	artOwnershipSummaryMap := make(map[string]structs.ArtOwnershipSummary)
+	for i := start; i < len(m.artOrder) && i < start+count; i++ {
+		art := m.artOwnership[m.artOrder[i]]
+		artOwnershipSummaryMap[art.Id] = structs.ArtOwnershipSummary{
+			Id:        art.Id,
+			Thumbnail: []byte(art.Thumbnail),
+			ArtLikes:  art.ArtLikes,
+			ForSale:   art.ForSale,
+			Price:     art.Price,
+			Status:    art.Status,
+		}
+	}
+	return artOwnershipSummaryMap
+}
+
+func (m *MemoryStore) FetchArtOwnershipByArtID(artID string) (*structs.ArtOwnership, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	art, exists := m.artOwnership[artID]
+	if !exists {
+		return nil, nil
+	}
+	art = copyArtOwnership(art)
+	return &art, nil
+}
+
+func (m *MemoryStore) AddBlock(block *structs.Block) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.blocks {
+		if existing.Index == block.Index {
+			log.Println("Error adding block: duplicate index", block.Index)
+			return
+		}
+	}
+	stored := *block
+	stored.Transactions = nil
+	m.blocks = append(m.blocks, &stored)
+	sort.Slice(m.blocks, func(i, j int) bool { return m.blocks[i].Index < m.blocks[j].Index })
+}
+
+// CommitBlock checks the block and its transactions against what is stored
+// and then applies the whole commit under one lock, so readers never see half
+// a block.
+func (m *MemoryStore) CommitBlock(commit BlockCommit) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkCommits(math.MaxInt, []BlockCommit{commit}); err != nil {
+		return err
+	}
+	m.writeCommit(commit)
+	return nil
+}
+
+// checkCommits fails, like the SQL constraints would, if commits reuse a
+// block index or transaction ID, counting only stored blocks up to
+// keepThrough.
+func (m *MemoryStore) checkCommits(keepThrough int, commits []BlockCommit) error {
+	indexes := make(map[int]bool)
+	for _, existing := range m.blocks {
+		if existing.Index <= keepThrough {
+			indexes[existing.Index] = true
+		}
+	}
+	transactionIds := make(map[string]bool)
+	for _, stored := range m.transactions {
+		if stored.blockIndex <= keepThrough {
+			transactionIds[stored.tx.TransactionId] = true
+		}
+	}
+	for _, commit := range commits {
+		if indexes[commit.Block.Index] {
+			return fmt.Errorf("committing block %d: duplicate index", commit.Block.Index)
+		}
+		indexes[commit.Block.Index] = true
+		for _, confirmed := range commit.Transactions {
+			if transactionIds[confirmed.TransactionId] {
+				return fmt.Errorf("committing block %d: duplicate transaction %s", commit.Block.Index, confirmed.TransactionId)
+			}
+			transactionIds[confirmed.TransactionId] = true
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) writeCommit(commit BlockCommit) {
+	stored := *commit.Block
+	stored.Transactions = nil
+	m.blocks = append(m.blocks, &stored)
+	sort.Slice(m.blocks, func(i, j int) bool { return m.blocks[i].Index < m.blocks[j].Index })
+	var blockTime int64
+	if t, ok := commit.Block.Time(); ok {
+		blockTime = t.UnixNano()
+	}
+	for position, confirmed := range commit.Transactions {
+		m.transactions = append(m.transactions, storedTransaction{tx: confirmed, blockIndex: commit.Block.Index, position: position, blockTime: blockTime})
+		for i, tx := range m.pending {
+			if tx.TransactionId == confirmed.TransactionId {
+				m.pending = append(m.pending[:i], m.pending[i+1:]...)
+				break
+			}
+		}
+	}
+	for _, receipt := range commit.Receipts {
+		m.receipts[receipt.TransactionId] = copyReceipt(receipt)
+	}
+	for address, balance := range commit.Balances {
+		m.balances[address] = balance
+	}
+	for address, nonce := range commit.Nonces {
+		m.nonces[address] = nonce
+	}
+	for artID, artOwnership := range commit.ArtOwnership {
+		m.putArtOwnership(artID, artOwnership)
+	}
+	for address, stake := range commit.Stakes {
+		m.putStake(address, stake)
+	}
+	m.undo[commit.Block.Index] = copyBlockUndo(commit.Undo)
+}
+
+// putArtOwnership updates the art row, or adds it if there is none.
+func (m *MemoryStore) putArtOwnership(artID string, artOwnership structs.ArtOwnership) {
+	if _, exists := m.artOwnership[artID]; !exists {
+		m.artOrder = append(m.artOrder, artID)
+	}
+	artOwnership.Id = artID
+	m.artOwnership[artID] = copyArtOwnership(artOwnership)
+}
+
+func (m *MemoryStore) LoadBlockUndo(blockIndex int) (*BlockUndo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	undo, exists := m.undo[blockIndex]
+	if !exists {
+		return nil, nil
+	}
+	undo = copyBlockUndo(undo)
+	return &undo, nil
+}
+
+func (m *MemoryStore) CommitReorg(reorg Reorg) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkCommits(reorg.ForkIndex, reorg.Commits); err != nil {
+		return fmt.Errorf("reorganizing after block %d: %v", reorg.ForkIndex, err)
+	}
+
+	blocks := m.blocks[:0]
+	for _, block := range m.blocks {
+		if block.Index <= reorg.ForkIndex {
+			blocks = append(blocks, block)
+		} else {
+			delete(m.undo, block.Index)
+		}
+	}
+	m.blocks = blocks
+	transactions := m.transactions[:0]
+	for _, stored := range m.transactions {
+		if stored.blockIndex <= reorg.ForkIndex {
+			transactions = append(transactions, stored)
+		}
+	}
+	m.transactions = transactions
+	for transactionId, receipt := range m.receipts {
+		if receipt.Status != structs.Failed && receipt.BlockIndex > reorg.ForkIndex {
+			delete(m.receipts, transactionId)
+		}
+	}
+
+	for address, balance := range reorg.Restore.Balances {
+		m.balances[address] = balance
+	}
+	for address, nonce := range reorg.Restore.Nonces {
+		m.nonces[address] = nonce
+	}
+	for artID, artOwnership := range reorg.Restore.ArtOwnership {
+		m.putArtOwnership(artID, artOwnership)
+	}
+	for address, stake := range reorg.Restore.Stakes {
+		m.putStake(address, stake)
+	}
+	m.pending = append(m.pending, reorg.Orphaned...)
+
+	for _, commit := range reorg.Commits {
+		m.writeCommit(commit)
+	}
+	return nil
+}
+
+// LoadBlocks returns at most 100 blocks after startBlockIndex, each carrying
+// the confirmed transactions recorded for it with AddTransaction, in block
+// order.
+func (m *MemoryStore) LoadBlocks(startBlockIndex *int) ([]*structs.Block, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var blocks []*structs.Block
+	for _, stored := range m.blocks {
+		if len(blocks) == 100 {
+			break
+		}
+		if startBlockIndex != nil && stored.Index <= *startBlockIndex {
+			continue
+		}
+		block := *stored
+		var inBlock []storedTransaction
+		for _, stored := range m.transactions {
+			if stored.blockIndex == block.Index {
+				inBlock = append(inBlock, stored)
+			}
+		}
+		sort.SliceStable(inBlock, func(i, j int) bool { return inBlock[i].position < inBlock[j].position })
+		block.Transactions = nil
+		for _, stored := range inBlock {
+			// Same as SQLStore: transactions come back as they were when hashed.
+			tx := stored.tx
+			tx.Status = structs.Pending
+			block.Transactions = append(block.Transactions, tx)
+		}
+		blocks = append(blocks, &block)
+	}
+	return blocks, nil
+}
+
+func (m *MemoryStore) AddUser(username string, data []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.users[username]; exists {
+		log.Println("Error adding user: duplicate username", username)
+		return
+	}
+	m.users[username] = append([]string(nil), data...)
+}
+
+func (m *MemoryStore) UpdateUser(username string, data []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.users[username]; exists {
+		m.users[username] = append([]string(nil), data...)
+	}
+}
+
+func (m *MemoryStore) LoadUsers() map[string][]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	userDatabase := make(map[string][]string, len(m.users))
+	for username, data := range m.users {
+		userDatabase[username] = append([]string(nil), data...)
+	}
+	return userDatabase
+}
+
+func (m *MemoryStore) AlreadyLiked(artID string, userID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.likes[artID][userID], nil
+}
+
+func (m *MemoryStore) AddLike(artID string, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.likes[artID][userID] {
+		return fmt.Errorf("User has already liked this art")
+	}
+	if m.likes[artID] == nil {
+		m.likes[artID] = make(map[string]bool)
+	}
+	m.likes[artID][userID] = true
+	return nil
+}
+
+func (m *MemoryStore) IncrementArtLike(artID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if art, exists := m.artOwnership[artID]; exists {
+		art.ArtLikes++
+		m.artOwnership[artID] = art
+	}
+	return nil
+}
+
+func (m *MemoryStore) CountLikes() (map[string]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counts := make(map[string]int, len(m.likes))
+	for artID, users := range m.likes {
+		counts[artID] = len(users)
+	}
+	return counts, nil
+}
+
+func (m *MemoryStore) ReplaceDerivedState(derived DerivedState) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.balances = make(map[string]structs.Amount, len(derived.Balances))
+	for address, balance := range derived.Balances {
+		m.balances[address] = balance
+	}
+	m.nonces = make(map[string]uint64, len(derived.Nonces))
+	for address, nonce := range derived.Nonces {
+		m.nonces[address] = nonce
+	}
+	m.stakes = make(map[string]structs.Amount, len(derived.Stakes))
+	for address, stake := range derived.Stakes {
+		m.putStake(address, stake)
+	}
+	for artID, artOwnership := range derived.ArtOwnership {
+		if _, exists := m.artOwnership[artID]; !exists {
+			m.artOrder = append(m.artOrder, artID)
+		}
+		artOwnership.Id = artID
+		m.artOwnership[artID] = copyArtOwnership(artOwnership)
+	}
+	return nil
+}
+
+func (m *MemoryStore) AddMediaData(mediaID string, data []byte, mediaType string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.media[mediaID]; exists {
+		return fmt.Errorf("media %s already exists", mediaID)
+	}
+	m.media[mediaID] = memoryMedia{data: append([]byte(nil), data...), mediaType: mediaType}
+	return nil
+}
+
+// GetMediaData returns sql.ErrNoRows for unknown IDs, matching SQLStore.
+func (m *MemoryStore) GetMediaData(mediaID string) ([]byte, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	media, exists := m.media[mediaID]
+	if !exists {
+		return nil, "", sql.ErrNoRows
+	}
+	return append([]byte(nil), media.data...), media.mediaType, nil
+}
+
+func (m *MemoryStore) SavePeer(peer structs.KnownPeer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.peers[peer.Address] = peer
+}
+
+func (m *MemoryStore) DeletePeer(address string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.peers, address)
+}
+
+func (m *MemoryStore) LoadPeers() []structs.KnownPeer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	peers := make([]structs.KnownPeer, 0, len(m.peers))
+	for _, peer := range m.peers {
+		peers = append(peers, peer)
+	}
+	return peers
+}
+
+func (m *MemoryStore) SaveBan(ban structs.PeerBan) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.bans[ban.Address] = ban
+}
+
+func (m *MemoryStore) DeleteBan(address string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.bans, address)
+}
+
+func (m *MemoryStore) LoadBans() []structs.PeerBan {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	bans := make([]structs.PeerBan, 0, len(m.bans))
+	for _, ban := range m.bans {
+		bans = append(bans, ban)
+	}
+	return bans
+}
+
+func copyBlockUndo(undo BlockUndo) BlockUndo {
+	copied := BlockUndo{
+		Balances:     make(map[string]structs.Amount, len(undo.Balances)),
+		Nonces:       make(map[string]uint64, len(undo.Nonces)),
+		ArtOwnership: make(map[string]structs.ArtOwnership, len(undo.ArtOwnership)),
+	}
+	for address, balance := range undo.Balances {
+		copied.Balances[address] = balance
+	}
+	for address, nonce := range undo.Nonces {
+		copied.Nonces[address] = nonce
+	}
+	for artID, artOwnership := range undo.ArtOwnership {
+		copied.ArtOwnership[artID] = copyArtOwnership(artOwnership)
+	}
+	if undo.Stakes != nil {
+		copied.Stakes = make(map[string]structs.Amount, len(undo.Stakes))
+		for address, stake := range undo.Stakes {
+			copied.Stakes[address] = stake
+		}
+	}
+	return copied
+}
+
+// copyArtOwnership detaches the slice fields so callers cannot modify the
+// stored record through the value they were handed.
+func copyArtOwnership(art structs.ArtOwnership) structs.ArtOwnership {
+	art.RelatedImages = append([]string(nil), art.RelatedImages...)
+	art.RelatedVideos = append([]string(nil), art.RelatedVideos...)
+	return art
+}
+
+func copyReceipt(receipt structs.Receipt) structs.Receipt {
+	receipt.Changes = append([]structs.StateChange(nil), receipt.Changes...)
+	return receipt
+}
@@ -0,0 +1,294 @@
+package sqldatabase
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migration files live in migrations/<dialect>/NNNN_name.sql and are applied
+// in version order. Every dialect must ship the same set of versions.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+const (
+	DialectMySQL  = "mysql"
+	DialectSQLite = "sqlite"
+)
+
+// Migration is one versioned schema change.
+type Migration struct {
+	Version    int
+	Name       string
+	Statements []string
+}
+
+const createSchemaVersionTable = `CREATE TABLE IF NOT EXISTS schema_version (
+	version INTEGER PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	applied_at VARCHAR(64) NOT NULL
+)`
+
+// migration_steps holds the statements of a migration that has not finished
+// yet, so an interrupted migration resumes after the last one that ran.
+const createMigrationStepsTable = `CREATE TABLE IF NOT EXISTS migration_steps (
+	version INTEGER NOT NULL,
+	step INTEGER NOT NULL,
+	PRIMARY KEY (version, step)
+)`
+
+// LoadMigrations returns the embedded migrations for dialect, ordered by version.
+func LoadMigrations(dialect string) ([]Migration, error) {
+	dir := path.Join("migrations", dialect)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for dialect %q: %v", dialect, err)
+	}
+
+	var migrations []Migration
+	seen := make(map[int]string)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".sql") {
+			continue
+		}
+		base := strings.TrimSuffix(name, ".sql")
+		prefix, label, found := strings.Cut(base, "_")
+		if !found {
+			return nil, fmt.Errorf("migration %s: expected NNNN_name.sql", name)
+		}
+		version, err := strconv.Atoi(prefix)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: invalid version %q", name, prefix)
+		}
+		if other, dup := seen[version]; dup {
+			return nil, fmt.Errorf("migrations %s and %s share version %d", other, name, version)
+		}
+		seen[version] = name
+
+		contents, err := migrationFiles.ReadFile(path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, Migration{
+			Version:    version,
+			Name:       label,
+			Statements: splitStatements(string(contents)),
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// splitStatements breaks a migration file into statements on semicolons that
+// end a line, dropping "--" comment lines.
+func splitStatements(contents string) []string {
+	var statements []string
+	var current strings.Builder
+	for _, line := range strings.Split(contents, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			stmt := strings.TrimSuffix(strings.TrimSpace(current.String()), ";")
+			statements = append(statements, stmt)
+			current.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(current.String()); rest != "" {
+		statements = append(statements, rest)
+	}
+	return statements
+}
+
+// SchemaVersion returns the highest migration version recorded in the
+// schema_version table, or 0 for a database that has never been migrated.
+func (s *SQLStore) SchemaVersion() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.schemaVersion(false)
+}
+
+// schemaVersion reads the current version. A missing schema_version table
+// means version 0; it is only created when create is set, so dry runs leave
+// the database untouched.
+func (s *SQLStore) schemaVersion(create bool) (int, error) {
+	var version int
+	err := s.db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version)
+	if err == nil {
+		return version, nil
+	}
+	if !create {
+		return 0, nil
+	}
+	if _, err := s.db.Exec(createSchemaVersionTable); err != nil {
+		return 0, fmt.Errorf("Failed to create schema_version table: %v", err)
+	}
+	return 0, nil
+}
+
+// Migrate applies every migration newer than the recorded schema version and
+// returns the ones it applied. With dryRun set nothing is executed; the
+// pending migrations and their statements are logged and returned instead.
+//
+// Each statement is recorded in migration_steps in the same transaction it
+// runs in, and skipped when the migration is run again, so a migration that
+// failed or was interrupted halfway picks up where it stopped. Data changes,
+// such as the amount conversion in 0006, are therefore applied exactly once.
+// MySQL commits schema changes on its own; if the node stops right after
+// one, it is run again, which is harmless for MODIFY and fails with a clear
+// error for ADD COLUMN.
+func (s *SQLStore) Migrate(dryRun bool) ([]Migration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	migrations, err := LoadMigrations(s.dialect)
+	if err != nil {
+		return nil, err
+	}
+	current, err := s.schemaVersion(!dryRun)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, m := range migrations {
+		if m.Version > current {
+			pending = append(pending, m)
+		}
+	}
+	if len(pending) > 0 && !dryRun {
+		if _, err := s.db.Exec(createMigrationStepsTable); err != nil {
+			return nil, fmt.Errorf("Failed to create migration_steps table: %v", err)
+		}
+	}
+
+	for _, m := range pending {
+		if dryRun {
+			log.Printf("Migration %04d_%s (dry run):", m.Version, m.Name)
+			for _, stmt := range m.Statements {
+				log.Printf("  %s;", stmt)
+			}
+			continue
+		}
+
+		// MySQL commits DDL implicitly, so statements run one at a time and the
+		// version is only recorded once all of them succeeded.
+		done, err := s.migrationSteps(m.Version)
+		if err != nil {
+			return nil, err
+		}
+		for i, stmt := range m.Statements {
+			if done[i] {
+				continue
+			}
+			if err := s.migrationStep(m.Version, i, stmt); err != nil {
+				return nil, fmt.Errorf("migration %04d_%s failed: %v", m.Version, m.Name, err)
+			}
+		}
+		_, err = s.db.Exec("INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)",
+			m.Version, m.Name, time.Now().UTC().Format(time.RFC3339))
+		if err != nil {
+			return nil, fmt.Errorf("migration %04d_%s: failed to record version: %v", m.Version, m.Name, err)
+		}
+		if _, err := s.db.Exec("DELETE FROM migration_steps WHERE version = ?", m.Version); err != nil {
+			log.Println("Error clearing migration steps:", err)
+		}
+		if len(done) > 0 {
+			log.Printf("Applied migration %04d_%s, resumed after %d of %d statements", m.Version, m.Name, len(done), len(m.Statements))
+		} else {
+			log.Printf("Applied migration %04d_%s", m.Version, m.Name)
+		}
+	}
+
+	return pending, nil
+}
+
+// migrationSteps returns the statements of migration version that already
+// ran, by index.
+func (s *SQLStore) migrationSteps(version int) (map[int]bool, error) {
+	rows, err := s.db.Query("SELECT step FROM migration_steps WHERE version = ?", version)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read migration steps: %v", err)
+	}
+	defer rows.Close()
+
+	done := make(map[int]bool)
+	for rows.Next() {
+		var step int
+		if err := rows.Scan(&step); err != nil {
+			return nil, fmt.Errorf("Failed to read migration steps: %v", err)
+		}
+		done[step] = true
+	}
+	return done, rows.Err()
+}
+
+// migrationStep runs statement step of migration version and records it in
+// one transaction.
+func (s *SQLStore) migrationStep(version, step int, stmt string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(stmt); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO migration_steps (version, step) VALUES (?, ?)", version, step); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to record statement %d: %v", step+1, err)
+	}
+	return tx.Commit()
+}
+
+// migratedAmountDecimals is the scale migration 0006 converted stored amounts
+// to, and the only one nodes ran with before the scale was recorded.
+const migratedAmountDecimals = 9
+
+// CheckAmountScale compares decimals, the configured chain.amountDecimals,
+// with the scale the stored amounts are counted in, and records it on the
+// first start. A database that already holds amounts without a recorded
+// scale is taken to be at migratedAmountDecimals.
+func (s *SQLStore) CheckAmountScale(decimals int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var stored int
+	err := s.db.QueryRow("SELECT decimals FROM amount_scale").Scan(&stored)
+	if err == sql.ErrNoRows {
+		var hasAmounts bool
+		err = s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM balances) OR EXISTS (SELECT 1 FROM validators)
+			OR EXISTS (SELECT 1 FROM transactions) OR EXISTS (SELECT 1 FROM pending_transactions)
+			OR EXISTS (SELECT 1 FROM art_ownership)`).Scan(&hasAmounts)
+		if err != nil {
+			return fmt.Errorf("Failed to check for stored amounts: %v", err)
+		}
+		stored = decimals
+		if hasAmounts {
+			stored = migratedAmountDecimals
+		}
+		if _, err := s.db.Exec("INSERT INTO amount_scale (decimals) VALUES (?)", stored); err != nil {
+			return fmt.Errorf("Failed to record the amount scale: %v", err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("Failed to read the amount scale: %v", err)
+	}
+	if stored != decimals {
+		return fmt.Errorf("stored amounts have %d decimal places, but chain.amountDecimals is %d; use %d or start from an empty database", stored, decimals, stored)
+	}
+	return nil
+}
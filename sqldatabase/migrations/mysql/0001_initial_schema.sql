@@ -0,0 +1,78 @@
+-- Tables the node used before migrations existed. IF NOT EXISTS keeps this
+-- safe on databases that were created by hand from the README.
+
+CREATE TABLE IF NOT EXISTS users (
+    username VARCHAR(255) PRIMARY KEY,
+    data TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS balances (
+    address VARCHAR(255) PRIMARY KEY,
+    balance DECIMAL(30, 10) NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS validators (
+    address VARCHAR(255) PRIMARY KEY,
+    stake DECIMAL(30, 10) NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS pending_transactions (
+    id VARCHAR(255) PRIMARY KEY,
+    type INT NOT NULL,
+    ArtID VARCHAR(255),
+    FromAddress VARCHAR(255) NOT NULL,
+    ToAddress VARCHAR(255) NOT NULL,
+    Amount DECIMAL(30, 10) NOT NULL,
+    Fee DECIMAL(30, 10) NOT NULL,
+    Signature TEXT NOT NULL,
+    Status VARCHAR(50) NOT NULL,
+    block_index INT
+);
+
+CREATE TABLE IF NOT EXISTS transactions (
+    id VARCHAR(255) PRIMARY KEY,
+    type INT NOT NULL,
+    ArtID VARCHAR(255),
+    FromAddress VARCHAR(255) NOT NULL,
+    ToAddress VARCHAR(255) NOT NULL,
+    Amount DECIMAL(30, 10) NOT NULL,
+    Fee DECIMAL(30, 10) NOT NULL,
+    Signature TEXT NOT NULL,
+    Status VARCHAR(50) NOT NULL,
+    block_index INT
+);
+
+CREATE TABLE IF NOT EXISTS blocks (
+    block_index INT PRIMARY KEY,
+    timestamp VARCHAR(255) NOT NULL,
+    hash VARCHAR(255) NOT NULL,
+    prev_hash VARCHAR(255)
+);
+
+CREATE TABLE IF NOT EXISTS art_ownership (
+    Id VARCHAR(255) PRIMARY KEY,
+    ArtOwner VARCHAR(255) NOT NULL,
+    Price DECIMAL(30, 10) NOT NULL,
+    Description TEXT,
+    Format VARCHAR(50),
+    Art TEXT,
+    RelatedImages TEXT,
+    RelatedVideos TEXT,
+    ArtName VARCHAR(255),
+    ArtLikes INT DEFAULT 0,
+    ForSale BOOLEAN,
+    Thumbnail TEXT,
+    Status VARCHAR(50) NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS art_likes (
+    art_id VARCHAR(255) NOT NULL,
+    user_id VARCHAR(255) NOT NULL,
+    PRIMARY KEY (art_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS media (
+    id VARCHAR(255) PRIMARY KEY,
+    data LONGBLOB NOT NULL,
+    media_type VARCHAR(255) NOT NULL
+);
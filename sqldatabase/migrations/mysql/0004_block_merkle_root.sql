@@ -0,0 +1,3 @@
+-- Merkle root of the block's transaction hashes; NULL for blocks hashed with
+-- the original scheme.
+ALTER TABLE blocks ADD COLUMN merkle_root VARCHAR(64);
@@ -0,0 +1,32 @@
+-- Balances, stakes, amounts, fees and prices become integer counts of the
+-- smallest coin unit. Existing values are converted at the default nine
+-- decimal places; a chain configured with another chain.amountDecimals must
+-- start from an empty database.
+
+ALTER TABLE balances MODIFY balance DECIMAL(40, 10) NOT NULL;
+UPDATE balances SET balance = ROUND(balance * 1000000000);
+ALTER TABLE balances MODIFY balance BIGINT NOT NULL;
+
+ALTER TABLE validators MODIFY stake DECIMAL(40, 10) NOT NULL;
+UPDATE validators SET stake = ROUND(stake * 1000000000);
+ALTER TABLE validators MODIFY stake BIGINT NOT NULL;
+
+ALTER TABLE transactions MODIFY Amount DECIMAL(40, 10) NOT NULL;
+UPDATE transactions SET Amount = ROUND(Amount * 1000000000);
+ALTER TABLE transactions MODIFY Amount BIGINT NOT NULL;
+
+ALTER TABLE transactions MODIFY Fee DECIMAL(40, 10) NOT NULL;
+UPDATE transactions SET Fee = ROUND(Fee * 1000000000);
+ALTER TABLE transactions MODIFY Fee BIGINT NOT NULL;
+
+ALTER TABLE pending_transactions MODIFY Amount DECIMAL(40, 10) NOT NULL;
+UPDATE pending_transactions SET Amount = ROUND(Amount * 1000000000);
+ALTER TABLE pending_transactions MODIFY Amount BIGINT NOT NULL;
+
+ALTER TABLE pending_transactions MODIFY Fee DECIMAL(40, 10) NOT NULL;
+UPDATE pending_transactions SET Fee = ROUND(Fee * 1000000000);
+ALTER TABLE pending_transactions MODIFY Fee BIGINT NOT NULL;
+
+ALTER TABLE art_ownership MODIFY Price DECIMAL(40, 10) NOT NULL;
+UPDATE art_ownership SET Price = ROUND(Price * 1000000000);
+ALTER TABLE art_ownership MODIFY Price BIGINT NOT NULL;
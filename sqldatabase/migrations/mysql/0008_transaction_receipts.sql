@@ -0,0 +1,13 @@
+-- One receipt per transaction that left the pool: mined into a block
+-- (Completed) or dropped (Failed). changes holds the JSON list of state
+-- changes.
+CREATE TABLE IF NOT EXISTS transaction_receipts (
+    transaction_id VARCHAR(255) PRIMARY KEY,
+    status VARCHAR(50) NOT NULL,
+    block_index INT,
+    block_hash VARCHAR(255),
+    block_position INT NOT NULL DEFAULT 0,
+    changes TEXT,
+    error TEXT,
+    recorded_at VARCHAR(64) NOT NULL
+);
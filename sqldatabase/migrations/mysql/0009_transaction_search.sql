@@ -0,0 +1,12 @@
+-- History queries page through confirmed transactions newest first by
+-- (block_index, block_position) and filter by address, art, type and block
+-- time. block_time is the block's Unix time in nanoseconds; it stays NULL for
+-- transactions mined before this migration.
+UPDATE transactions SET block_position = 0 WHERE block_position IS NULL;
+ALTER TABLE transactions ADD COLUMN block_time BIGINT;
+
+CREATE INDEX idx_transactions_from ON transactions (FromAddress, block_index, block_position);
+CREATE INDEX idx_transactions_to ON transactions (ToAddress, block_index, block_position);
+CREATE INDEX idx_transactions_art ON transactions (ArtID, block_index, block_position);
+CREATE INDEX idx_transactions_type ON transactions (type, block_index, block_position);
+CREATE INDEX idx_transactions_block_time ON transactions (block_time);
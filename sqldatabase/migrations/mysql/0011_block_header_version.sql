@@ -0,0 +1,8 @@
+-- Blocks record the header version they were hashed with. Existing blocks
+-- keep their hashes: those with a Merkle root are version 1, the rest version
+-- 0. timestamp_ns is the Unix time in nanoseconds of version 2 blocks, which
+-- no longer fill the text timestamp; it stays NULL for older blocks, whose
+-- time is parsed from timestamp when they are loaded.
+ALTER TABLE blocks ADD COLUMN version INT NOT NULL DEFAULT 0;
+ALTER TABLE blocks ADD COLUMN timestamp_ns BIGINT;
+UPDATE blocks SET version = 1 WHERE merkle_root IS NOT NULL AND merkle_root <> '';
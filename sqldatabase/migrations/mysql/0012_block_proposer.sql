@@ -0,0 +1,4 @@
+-- Address of the node that sealed the block and its signature over the
+-- header. Both stay NULL for blocks before header version 3.
+ALTER TABLE blocks ADD COLUMN proposer TEXT;
+ALTER TABLE blocks ADD COLUMN signature TEXT;
@@ -0,0 +1,19 @@
+-- The peer book: addresses of other nodes' /p2p endpoints learned from the
+-- configuration, handshakes and peer exchange, and the peers banned for
+-- misbehaving. Times are Unix nanoseconds, 0 for never.
+CREATE TABLE IF NOT EXISTS peers (
+    address VARCHAR(255) PRIMARY KEY,
+    node_id VARCHAR(64) NOT NULL DEFAULT '',
+    source VARCHAR(16) NOT NULL,
+    last_seen BIGINT NOT NULL DEFAULT 0,
+    last_attempt BIGINT NOT NULL DEFAULT 0,
+    failures INT NOT NULL DEFAULT 0,
+    latency_ms BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS peer_bans (
+    address VARCHAR(255) PRIMARY KEY,
+    node_id VARCHAR(64) NOT NULL DEFAULT '',
+    reason TEXT NOT NULL,
+    banned_until BIGINT NOT NULL
+);
@@ -0,0 +1,4 @@
+-- A priced version 2 ArtTransfer is paid by its buyer, the recipient, who
+-- signs it too. Every other transaction leaves the column NULL.
+ALTER TABLE transactions ADD COLUMN buyer_signature TEXT;
+ALTER TABLE pending_transactions ADD COLUMN buyer_signature TEXT;
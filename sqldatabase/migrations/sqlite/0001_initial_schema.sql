@@ -0,0 +1,77 @@
+-- Same tables as the MySQL schema, using SQLite storage classes.
+
+CREATE TABLE IF NOT EXISTS users (
+    username TEXT PRIMARY KEY,
+    data TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS balances (
+    address TEXT PRIMARY KEY,
+    balance REAL NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS validators (
+    address TEXT PRIMARY KEY,
+    stake REAL NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS pending_transactions (
+    id TEXT PRIMARY KEY,
+    type INTEGER NOT NULL,
+    ArtID TEXT,
+    FromAddress TEXT NOT NULL,
+    ToAddress TEXT NOT NULL,
+    Amount REAL NOT NULL,
+    Fee REAL NOT NULL,
+    Signature TEXT NOT NULL,
+    Status TEXT NOT NULL,
+    block_index INTEGER
+);
+
+CREATE TABLE IF NOT EXISTS transactions (
+    id TEXT PRIMARY KEY,
+    type INTEGER NOT NULL,
+    ArtID TEXT,
+    FromAddress TEXT NOT NULL,
+    ToAddress TEXT NOT NULL,
+    Amount REAL NOT NULL,
+    Fee REAL NOT NULL,
+    Signature TEXT NOT NULL,
+    Status TEXT NOT NULL,
+    block_index INTEGER
+);
+
+CREATE TABLE IF NOT EXISTS blocks (
+    block_index INTEGER PRIMARY KEY,
+    timestamp TEXT NOT NULL,
+    hash TEXT NOT NULL,
+    prev_hash TEXT
+);
+
+CREATE TABLE IF NOT EXISTS art_ownership (
+    Id TEXT PRIMARY KEY,
+    ArtOwner TEXT NOT NULL,
+    Price REAL NOT NULL,
+    Description TEXT,
+    Format TEXT,
+    Art TEXT,
+    RelatedImages TEXT,
+    RelatedVideos TEXT,
+    ArtName TEXT,
+    ArtLikes INTEGER DEFAULT 0,
+    ForSale BOOLEAN,
+    Thumbnail TEXT,
+    Status TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS art_likes (
+    art_id TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    PRIMARY KEY (art_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS media (
+    id TEXT PRIMARY KEY,
+    data BLOB NOT NULL,
+    media_type TEXT NOT NULL
+);
@@ -0,0 +1,7 @@
+-- RelatedImages/RelatedVideos now hold JSON arrays; empty strings become "[]".
+UPDATE art_ownership SET RelatedImages = '[]' WHERE RelatedImages IS NULL OR RelatedImages = '';
+UPDATE art_ownership SET RelatedVideos = '[]' WHERE RelatedVideos IS NULL OR RelatedVideos = '';
+
+-- LoadBlocks fetches transactions per block; the pending pool is filtered by status.
+CREATE INDEX IF NOT EXISTS idx_transactions_block_index ON transactions (block_index);
+CREATE INDEX IF NOT EXISTS idx_pending_transactions_status ON pending_transactions (Status);
@@ -0,0 +1,5 @@
+-- Block hashes and signatures cover the ArtOwnership id and the order of
+-- transactions inside a block, so both are stored to let the chain be verified.
+ALTER TABLE transactions ADD COLUMN ArtOwnershipId TEXT;
+ALTER TABLE transactions ADD COLUMN block_position INTEGER;
+ALTER TABLE pending_transactions ADD COLUMN ArtOwnershipId TEXT;
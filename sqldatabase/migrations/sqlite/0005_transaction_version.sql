@@ -0,0 +1,6 @@
+-- Version 1 transactions are signed over their canonical encoding, which
+-- includes the whole ArtOwnership payload, so both are kept with the row.
+ALTER TABLE transactions ADD COLUMN version INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE transactions ADD COLUMN ArtOwnershipData TEXT;
+ALTER TABLE pending_transactions ADD COLUMN version INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE pending_transactions ADD COLUMN ArtOwnershipData TEXT;
@@ -0,0 +1,41 @@
+-- Balances, stakes, amounts, fees and prices become integer counts of the
+-- smallest coin unit. Existing values are converted at the default nine
+-- decimal places; a chain configured with another chain.amountDecimals must
+-- start from an empty database.
+-- SQLite cannot change a column's type, so each one is copied into a new
+-- INTEGER column that then takes the old name.
+
+ALTER TABLE balances ADD COLUMN balance_units INTEGER NOT NULL DEFAULT 0;
+UPDATE balances SET balance_units = CAST(ROUND(balance * 1000000000) AS INTEGER);
+ALTER TABLE balances DROP COLUMN balance;
+ALTER TABLE balances RENAME COLUMN balance_units TO balance;
+
+ALTER TABLE validators ADD COLUMN stake_units INTEGER NOT NULL DEFAULT 0;
+UPDATE validators SET stake_units = CAST(ROUND(stake * 1000000000) AS INTEGER);
+ALTER TABLE validators DROP COLUMN stake;
+ALTER TABLE validators RENAME COLUMN stake_units TO stake;
+
+ALTER TABLE transactions ADD COLUMN Amount_units INTEGER NOT NULL DEFAULT 0;
+UPDATE transactions SET Amount_units = CAST(ROUND(Amount * 1000000000) AS INTEGER);
+ALTER TABLE transactions DROP COLUMN Amount;
+ALTER TABLE transactions RENAME COLUMN Amount_units TO Amount;
+
+ALTER TABLE transactions ADD COLUMN Fee_units INTEGER NOT NULL DEFAULT 0;
+UPDATE transactions SET Fee_units = CAST(ROUND(Fee * 1000000000) AS INTEGER);
+ALTER TABLE transactions DROP COLUMN Fee;
+ALTER TABLE transactions RENAME COLUMN Fee_units TO Fee;
+
+ALTER TABLE pending_transactions ADD COLUMN Amount_units INTEGER NOT NULL DEFAULT 0;
+UPDATE pending_transactions SET Amount_units = CAST(ROUND(Amount * 1000000000) AS INTEGER);
+ALTER TABLE pending_transactions DROP COLUMN Amount;
+ALTER TABLE pending_transactions RENAME COLUMN Amount_units TO Amount;
+
+ALTER TABLE pending_transactions ADD COLUMN Fee_units INTEGER NOT NULL DEFAULT 0;
+UPDATE pending_transactions SET Fee_units = CAST(ROUND(Fee * 1000000000) AS INTEGER);
+ALTER TABLE pending_transactions DROP COLUMN Fee;
+ALTER TABLE pending_transactions RENAME COLUMN Fee_units TO Fee;
+
+ALTER TABLE art_ownership ADD COLUMN Price_units INTEGER NOT NULL DEFAULT 0;
+UPDATE art_ownership SET Price_units = CAST(ROUND(Price * 1000000000) AS INTEGER);
+ALTER TABLE art_ownership DROP COLUMN Price;
+ALTER TABLE art_ownership RENAME COLUMN Price_units TO Price;
@@ -0,0 +1,9 @@
+-- Replay protection: every version 2 transaction carries its sender's nonce,
+-- and account_nonces holds the next nonce expected from each address.
+ALTER TABLE transactions ADD COLUMN nonce INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE pending_transactions ADD COLUMN nonce INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS account_nonces (
+    address TEXT PRIMARY KEY,
+    nonce INTEGER NOT NULL
+);
@@ -0,0 +1,13 @@
+-- One receipt per transaction that left the pool: mined into a block
+-- (Completed) or dropped (Failed). changes holds the JSON list of state
+-- changes.
+CREATE TABLE IF NOT EXISTS transaction_receipts (
+    transaction_id TEXT PRIMARY KEY,
+    status TEXT NOT NULL,
+    block_index INTEGER,
+    block_hash TEXT,
+    block_position INTEGER NOT NULL DEFAULT 0,
+    changes TEXT,
+    error TEXT,
+    recorded_at TEXT NOT NULL
+);
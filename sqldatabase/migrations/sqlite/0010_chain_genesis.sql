@@ -0,0 +1,8 @@
+-- The genesis the database was initialized from. There is at most one row;
+-- document is the genesis file in its canonical form and hash identifies the
+-- network.
+CREATE TABLE IF NOT EXISTS chain_genesis (
+    hash TEXT PRIMARY KEY,
+    chain_id TEXT NOT NULL,
+    document TEXT NOT NULL
+);
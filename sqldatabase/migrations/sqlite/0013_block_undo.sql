@@ -0,0 +1,7 @@
+-- What each block overwrote, as JSON: the balances, nonces and art rows it
+-- changed, as they were before it, and the validators it removed. A reorg
+-- writes them back to revert the block.
+CREATE TABLE IF NOT EXISTS block_undo (
+    block_index INTEGER PRIMARY KEY,
+    undo TEXT NOT NULL
+);
@@ -0,0 +1,19 @@
+-- The peer book: addresses of other nodes' /p2p endpoints learned from the
+-- configuration, handshakes and peer exchange, and the peers banned for
+-- misbehaving. Times are Unix nanoseconds, 0 for never.
+CREATE TABLE IF NOT EXISTS peers (
+    address TEXT PRIMARY KEY,
+    node_id TEXT NOT NULL DEFAULT '',
+    source TEXT NOT NULL,
+    last_seen BIGINT NOT NULL DEFAULT 0,
+    last_attempt BIGINT NOT NULL DEFAULT 0,
+    failures INTEGER NOT NULL DEFAULT 0,
+    latency_ms BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS peer_bans (
+    address TEXT PRIMARY KEY,
+    node_id TEXT NOT NULL DEFAULT '',
+    reason TEXT NOT NULL,
+    banned_until BIGINT NOT NULL
+);
@@ -0,0 +1,3 @@
+-- The proposer's VRF proof, whose hash seeds the choice of the next
+-- proposer. It stays NULL for blocks before header version 4.
+ALTER TABLE blocks ADD COLUMN vrf_proof TEXT;
@@ -0,0 +1,7 @@
+-- The number of decimal places stored amounts are counted in. The node
+-- records chain.amountDecimals here on its first start and refuses to start
+-- with a different value, since every stored amount would be off by a power
+-- of ten.
+CREATE TABLE IF NOT EXISTS amount_scale (
+    decimals INTEGER NOT NULL
+);
@@ -0,0 +1,115 @@
+package sqldatabase
+
+import (
+	"indicartcoin/structs"
+	"log"
+	"time"
+)
+
+// SavePeer stores a peer book entry, replacing any with the same address.
+func (s *SQLStore) SavePeer(peer structs.KnownPeer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.db.Exec("REPLACE INTO peers (address, node_id, source, last_seen, last_attempt, failures, latency_ms) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		peer.Address, peer.NodeID, peer.Source, unixNanos(peer.LastSeen), unixNanos(peer.LastAttempt), peer.Failures, peer.LatencyMillis)
+	if err != nil {
+		log.Println("Error saving peer:", err)
+	}
+}
+
+func (s *SQLStore) DeletePeer(address string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.db.Exec("DELETE FROM peers WHERE address = ?", address); err != nil {
+		log.Println("Error deleting peer:", err)
+	}
+}
+
+func (s *SQLStore) LoadPeers() []structs.KnownPeer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rows, err := s.db.Query("SELECT address, node_id, source, last_seen, last_attempt, failures, latency_ms FROM peers")
+	if err != nil {
+		log.Println("Error loading peers:", err)
+		return nil
+	}
+	defer rows.Close()
+
+	var peers []structs.KnownPeer
+	for rows.Next() {
+		var peer structs.KnownPeer
+		var lastSeen, lastAttempt int64
+		if err := rows.Scan(&peer.Address, &peer.NodeID, &peer.Source, &lastSeen, &lastAttempt, &peer.Failures, &peer.LatencyMillis); err != nil {
+			log.Println("Error scanning peer row:", err)
+			continue
+		}
+		peer.LastSeen = fromUnixNanos(lastSeen)
+		peer.LastAttempt = fromUnixNanos(lastAttempt)
+		peers = append(peers, peer)
+	}
+	return peers
+}
+
+// SaveBan stores a ban, replacing any on the same address.
+func (s *SQLStore) SaveBan(ban structs.PeerBan) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.db.Exec("REPLACE INTO peer_bans (address, node_id, reason, banned_until) VALUES (?, ?, ?, ?)",
+		ban.Address, ban.NodeID, ban.Reason, unixNanos(ban.BannedUntil))
+	if err != nil {
+		log.Println("Error saving peer ban:", err)
+	}
+}
+
+func (s *SQLStore) DeleteBan(address string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.db.Exec("DELETE FROM peer_bans WHERE address = ?", address); err != nil {
+		log.Println("Error deleting peer ban:", err)
+	}
+}
+
+func (s *SQLStore) LoadBans() []structs.PeerBan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rows, err := s.db.Query("SELECT address, node_id, reason, banned_until FROM peer_bans")
+	if err != nil {
+		log.Println("Error loading peer bans:", err)
+		return nil
+	}
+	defer rows.Close()
+
+	var bans []structs.PeerBan
+	for rows.Next() {
+		var ban structs.PeerBan
+		var bannedUntil int64
+		if err := rows.Scan(&ban.Address, &ban.NodeID, &ban.Reason, &bannedUntil); err != nil {
+			log.Println("Error scanning peer ban row:", err)
+			continue
+		}
+		ban.BannedUntil = fromUnixNanos(bannedUntil)
+		bans = append(bans, ban)
+	}
+	return bans
+}
+
+// unixNanos stores the zero time as 0.
+func unixNanos(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromUnixNanos(nanos int64) time.Time {
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos).UTC()
+}
@@ -0,0 +1,200 @@
+package sqldatabase
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"indicartcoin/structs"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Page sizes for SearchTransactions.
+const (
+	DefaultSearchLimit = 50
+	MaxSearchLimit     = 500
+)
+
+// ErrInvalidCursor is returned for a cursor that SearchTransactions did not
+// hand out.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// TransactionQuery selects confirmed transactions. Zero fields match
+// everything.
+type TransactionQuery struct {
+	Address  string // sender or recipient
+	From     string
+	To       string
+	ArtID    string
+	Type     *structs.TransactionType
+	MinBlock int       // inclusive
+	MaxBlock int       // inclusive
+	Since    time.Time // block time, inclusive
+	Until    time.Time // block time, exclusive
+	Cursor   string    // NextCursor of the previous page
+	Limit    int       // DefaultSearchLimit if 0, at most MaxSearchLimit
+}
+
+// TransactionRecord is a confirmed transaction and where it was mined.
+type TransactionRecord struct {
+	Transaction structs.Transaction `json:"transaction"`
+	BlockIndex  int                 `json:"blockIndex"`
+	Position    int                 `json:"position"`
+	BlockTime   string              `json:"blockTime,omitempty"` // RFC 3339, empty if unknown
+}
+
+// TransactionPage is one page of search results. NextCursor is empty on the
+// last page.
+type TransactionPage struct {
+	Transactions []TransactionRecord `json:"transactions"`
+	NextCursor   string              `json:"nextCursor,omitempty"`
+}
+
+// searchCursor is the position of the last transaction on a page. Results are
+// ordered newest first, so the next page starts strictly before it.
+type searchCursor struct {
+	blockIndex int
+	position   int
+}
+
+func (c searchCursor) encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d", c.blockIndex, c.position)))
+}
+
+func decodeCursor(cursor string) (*searchCursor, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 {
+		return nil, ErrInvalidCursor
+	}
+	blockIndex, err1 := strconv.Atoi(parts[0])
+	position, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &searchCursor{blockIndex: blockIndex, position: position}, nil
+}
+
+func searchLimit(limit int) int {
+	if limit <= 0 {
+		return DefaultSearchLimit
+	}
+	if limit > MaxSearchLimit {
+		return MaxSearchLimit
+	}
+	return limit
+}
+
+func formatBlockTime(nanos int64) string {
+	if nanos == 0 {
+		return ""
+	}
+	return time.Unix(0, nanos).UTC().Format(time.RFC3339Nano)
+}
+
+// SearchTransactions pages through the transactions table newest first. Every
+// filter is served by one of the indexes from migration 0009.
+func (s *SQLStore) SearchTransactions(query TransactionQuery) (*TransactionPage, error) {
+	cursor, err := decodeCursor(query.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	limit := searchLimit(query.Limit)
+
+	var where []string
+	var args []interface{}
+	if query.Address != "" {
+		where = append(where, "(FromAddress = ? OR ToAddress = ?)")
+		args = append(args, query.Address, query.Address)
+	}
+	if query.From != "" {
+		where = append(where, "FromAddress = ?")
+		args = append(args, query.From)
+	}
+	if query.To != "" {
+		where = append(where, "ToAddress = ?")
+		args = append(args, query.To)
+	}
+	if query.ArtID != "" {
+		where = append(where, "ArtID = ?")
+		args = append(args, query.ArtID)
+	}
+	if query.Type != nil {
+		where = append(where, "type = ?")
+		args = append(args, *query.Type)
+	}
+	if query.MinBlock > 0 {
+		where = append(where, "block_index >= ?")
+		args = append(args, query.MinBlock)
+	}
+	if query.MaxBlock > 0 {
+		where = append(where, "block_index <= ?")
+		args = append(args, query.MaxBlock)
+	}
+	if !query.Since.IsZero() {
+		where = append(where, "block_time >= ?")
+		args = append(args, query.Since.UnixNano())
+	}
+	if !query.Until.IsZero() {
+		where = append(where, "block_time < ?")
+		args = append(args, query.Until.UnixNano())
+	}
+	if cursor != nil {
+		where = append(where, "(block_index < ? OR (block_index = ? AND block_position < ?))")
+		args = append(args, cursor.blockIndex, cursor.blockIndex, cursor.position)
+	}
+
+	statement := "SELECT id, type, ArtID, FromAddress, ToAddress, Amount, Fee, Signature, ArtOwnershipId, version, ArtOwnershipData, nonce, buyer_signature, block_index, block_position, block_time FROM transactions"
+	if len(where) > 0 {
+		statement += " WHERE " + strings.Join(where, " AND ")
+	}
+	// One extra row tells whether there is a next page.
+	statement += " ORDER BY block_index DESC, block_position DESC LIMIT ?"
+	args = append(args, limit+1)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rows, err := s.db.Query(statement, args...)
+	if err != nil {
+		log.Println("Error searching transactions:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &TransactionPage{Transactions: []TransactionRecord{}}
+	for rows.Next() {
+		var record TransactionRecord
+		var artOwnershipId, artOwnershipData, buyerSignature sql.NullString
+		var blockTime sql.NullInt64
+		tx := &record.Transaction
+		if err := rows.Scan(&tx.TransactionId, &tx.Type, &tx.ArtID, &tx.From, &tx.To, &tx.Amount, &tx.Fee, &tx.Signature, &artOwnershipId, &tx.Version, &artOwnershipData, &tx.Nonce, &buyerSignature, &record.BlockIndex, &record.Position, &blockTime); err != nil {
+			log.Println("Error scanning transaction row:", err)
+			return nil, err
+		}
+		tx.BuyerSignature = buyerSignature.String
+		decodeArtOwnershipData(tx, artOwnershipId.String, artOwnershipData.String)
+		tx.Status = structs.Completed
+		record.BlockTime = formatBlockTime(blockTime.Int64)
+		page.Transactions = append(page.Transactions, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(page.Transactions) > limit {
+		page.Transactions = page.Transactions[:limit]
+		last := page.Transactions[limit-1]
+		page.NextCursor = searchCursor{blockIndex: last.BlockIndex, position: last.Position}.encode()
+	}
+	return page, nil
+}
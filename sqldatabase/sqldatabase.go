@@ -2,6 +2,7 @@ package sqldatabase
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"indicartcoin/structs"
 	"log"
@@ -12,37 +13,43 @@ import (
 	_ "github.com/go-sql-driver/mysql" // MySQL driver
 )
 
-var db *sql.DB
-var dbMutex sync.Mutex
+// SQLStore is the Store implementation backed by a database/sql connection.
+type SQLStore struct {
+	db      *sql.DB
+	dialect string
+	mu      sync.Mutex
+}
 
-func InitDatabase() error {
-	var err error
-	//=======>IMPORTANT
-	// Initialize the database connection. Replace with your own credentials.
-	db, err = sql.Open("mysql", "your_username:your_password@tcp(your_db_host:3306)/your_database_name")
+var _ Store = (*SQLStore)(nil)
+
+// OpenMySQL connects to the MySQL server described by dsn. It does not touch
+// the schema; call Migrate for that.
+func OpenMySQL(dsn string) (*SQLStore, error) {
+	db, err := sql.Open("mysql", dsn)
 	if err != nil {
-		return fmt.Errorf("Failed to open database: %v", err)
+		return nil, fmt.Errorf("Failed to open database: %v", err)
 	}
 	// Check if the database is accessible
 	if err = db.Ping(); err != nil {
-		return fmt.Errorf("Could not connect to the database: %v", err)
+		db.Close()
+		return nil, fmt.Errorf("Could not connect to the database: %v", err)
 	}
 	db.SetMaxOpenConns(10)
 	db.SetMaxIdleConns(5)
 	db.SetConnMaxLifetime(time.Minute * 10)
 
-	return nil
+	return &SQLStore{db: db, dialect: DialectMySQL}, nil
 }
 
-func CloseDatabase() {
-	db.Close()
+func (s *SQLStore) Close() error {
+	return s.db.Close()
 }
 
-func LoadValidators() []structs.Validator {
-	dbMutex.Lock()
-	defer dbMutex.Unlock()
+func (s *SQLStore) LoadValidators() []structs.Validator {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	rows, err := db.Query("SELECT address, stake FROM validators")
+	rows, err := s.db.Query("SELECT address, stake FROM validators")
 	if err != nil {
 		log.Println("Error loading validators:", err)
 		return nil
@@ -63,77 +70,69 @@ func LoadValidators() []structs.Validator {
 	return vals
 }
 
-// AddValidator adds a new validator to the SQL database.
-func AddValidator(val structs.Validator) {
-	dbMutex.Lock()
-	defer dbMutex.Unlock()
+// AddTransaction adds a new transaction to the SQL database. position is the
+// transaction's offset inside the block, which the block hash depends on.
+func (s *SQLStore) AddTransaction(tx structs.Transaction, block_index int, position int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	_, err := db.Exec("INSERT INTO validators (address, stake) VALUES (?, ?)",
-		string(val.Address), float64(val.Stake))
+	err := insertTransaction(s.db, tx, block_index, position, 0)
 	if err != nil {
-		log.Println("Error adding validator:", err)
+		log.Println("Error adding transaction:", err)
 	}
 }
 
-func DeleteValidator(address string) {
-	dbMutex.Lock()
-	defer dbMutex.Unlock()
+// AddReceipt stores the receipt of a transaction, replacing any earlier one
+// with the same TransactionId.
+func (s *SQLStore) AddReceipt(receipt structs.Receipt) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	_, err := db.Exec("DELETE FROM validators WHERE address = ?", address)
-	if err != nil {
-		log.Println("Error deleting validator:", err)
+	if err := insertReceipt(s.db, receipt); err != nil {
+		log.Println("Error adding receipt:", err)
 	}
 }
 
-// LoadTransactions fetches all pending transactions from the SQL database.
-func LoadTransactions() []structs.Transaction {
-	dbMutex.Lock()
-	defer dbMutex.Unlock()
+// LoadReceipt returns the receipt of the transaction, or nil if it has none.
+func (s *SQLStore) LoadReceipt(transactionId string) (*structs.Receipt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	rows, err := db.Query("SELECT id, type, ArtID, FromAddress, ToAddress, Amount, Fee, Signature, Status, block_index FROM transactions LIMIT 5")
+	var receipt structs.Receipt
+	var status string
+	var blockIndex sql.NullInt64
+	var blockHash, changes, receiptError sql.NullString
+	err := s.db.QueryRow("SELECT transaction_id, status, block_index, block_hash, block_position, changes, error, recorded_at FROM transaction_receipts WHERE transaction_id = ?", transactionId).
+		Scan(&receipt.TransactionId, &status, &blockIndex, &blockHash, &receipt.Position, &changes, &receiptError, &receipt.RecordedAt)
 	if err != nil {
-		log.Println("Error loading transactions:", err)
-		return nil
-	}
-	defer rows.Close()
-
-	var txs []structs.Transaction
-	for rows.Next() {
-		var tx structs.Transaction
-		var bockIndex = ""
-		var status = ""
-		if err := rows.Scan(&tx.TransactionId, &tx.Type, &tx.ArtID, &tx.From, &tx.To, &tx.Amount, &tx.Fee, &tx.Signature, &status, &bockIndex); err != nil {
-			log.Println("Error scanning transaction row:", err)
-			continue
-		}
-		if status == "Pending" {
-			tx.Status = structs.Pending
-		} else {
-			tx.Status = structs.Completed
+		if err == sql.ErrNoRows {
+			return nil, nil
 		}
-		txs = append(txs, tx)
+		log.Println("Error scanning receipt row:", err)
+		return nil, err
 	}
 
-	return txs
-}
-
-// AddTransaction adds a new transaction to the SQL database.
-func AddTransaction(tx structs.Transaction, block_index int) {
-	dbMutex.Lock()
-	defer dbMutex.Unlock()
-
-	_, err := db.Exec("INSERT INTO transactions (id, type, ArtID, FromAddress, ToAddress, Amount, Fee, Signature, Status, block_index) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
-		tx.TransactionId, tx.Type, tx.ArtID, tx.From, tx.To, tx.Amount, tx.Fee, tx.Signature, tx.Status.String(), block_index)
-	if err != nil {
-		log.Println("Error adding transaction:", err)
+	if status == structs.Failed.String() {
+		receipt.Status = structs.Failed
+	} else {
+		receipt.Status = structs.Completed
+	}
+	receipt.BlockIndex = int(blockIndex.Int64)
+	receipt.BlockHash = blockHash.String
+	receipt.Error = receiptError.String
+	if changes.String != "" {
+		if err := json.Unmarshal([]byte(changes.String), &receipt.Changes); err != nil {
+			log.Println("Error decoding receipt changes:", err)
+		}
 	}
+	return &receipt, nil
 }
 
-func LoadPendingTransactions() []structs.Transaction {
-	dbMutex.Lock()
-	defer dbMutex.Unlock()
+func (s *SQLStore) LoadPendingTransactions() []structs.Transaction {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	rows, err := db.Query("SELECT id, type, ArtID, FromAddress, ToAddress, Amount, Fee,Signature,Status FROM pending_transactions WHERE Status = 'Pending' LIMIT 5")
+	rows, err := s.db.Query("SELECT id, type, ArtID, FromAddress, ToAddress, Amount, Fee, Signature, Status, ArtOwnershipId, version, ArtOwnershipData, nonce, buyer_signature FROM pending_transactions WHERE Status = 'Pending'")
 	if err != nil {
 		log.Println("Error loading transactions:", err)
 		return nil
@@ -144,10 +143,13 @@ func LoadPendingTransactions() []structs.Transaction {
 	for rows.Next() {
 		var tx structs.Transaction
 		var status = ""
-		if err := rows.Scan(&tx.TransactionId, &tx.Type, &tx.ArtID, &tx.From, &tx.To, &tx.Amount, &tx.Fee, &tx.Signature, &status); err != nil {
+		var artOwnershipId, artOwnershipData, buyerSignature sql.NullString
+		if err := rows.Scan(&tx.TransactionId, &tx.Type, &tx.ArtID, &tx.From, &tx.To, &tx.Amount, &tx.Fee, &tx.Signature, &status, &artOwnershipId, &tx.Version, &artOwnershipData, &tx.Nonce, &buyerSignature); err != nil {
 			log.Println("Error scanning transaction row:", err)
 			continue
 		}
+		tx.BuyerSignature = buyerSignature.String
+		decodeArtOwnershipData(&tx, artOwnershipId.String, artOwnershipData.String)
 		if status == "Pending" {
 			tx.Status = structs.Pending
 		} else {
@@ -160,66 +162,65 @@ func LoadPendingTransactions() []structs.Transaction {
 }
 
 // AddTransaction adds a new transaction to the SQL database.
-func AddPendingTransaction(tx structs.Transaction) {
-	dbMutex.Lock()
-	defer dbMutex.Unlock()
+func (s *SQLStore) AddPendingTransaction(tx structs.Transaction) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	_, err := db.Exec("INSERT INTO pending_transactions (id, type, ArtID, FromAddress, ToAddress, Amount, Fee, Signature, Status, block_index) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
-		tx.TransactionId, tx.Type, tx.ArtID, tx.From, tx.To, tx.Amount, tx.Fee, tx.Signature, tx.Status.String(), nil)
-	if err != nil {
+	if err := insertPendingTransaction(s.db, tx); err != nil {
 		log.Println("Error adding transaction:", err)
 	}
 }
 
 // DeletePendingTransaction deletes a pending transaction from the SQL database based on the transaction ID.
-func DeletePendingTransaction(transactionId string) {
-	dbMutex.Lock()
-	defer dbMutex.Unlock()
+func (s *SQLStore) DeletePendingTransaction(transactionId string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	_, err := db.Exec("DELETE FROM pending_transactions WHERE id = ?", transactionId)
+	_, err := s.db.Exec(deletePendingQuery, transactionId)
 	if err != nil {
 		log.Println("Error deleting pending transaction:", err)
 	}
 }
 
-// UpdateBalance updates the balance for a given address in the SQL database.
-func UpdateBalance(address string, balance float64) {
-	dbMutex.Lock()
-	defer dbMutex.Unlock()
+// UpdateBalance sets the balance for a given address in the SQL database,
+// adding the row if the address has none yet.
+func (s *SQLStore) UpdateBalance(address string, balance structs.Amount) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	_, err := db.Exec("UPDATE balances SET balance=? WHERE address=?", balance, address)
+	_, err := s.db.Exec(s.upsertBalanceQuery(), address, balance)
 	if err != nil {
 		log.Println("Error updating balance:", err)
 	}
 }
 
 // AddBalances inserts a new balance record for a given address in the SQL database.
-func AddBalances(address string, balance float64) {
-	dbMutex.Lock()
-	defer dbMutex.Unlock()
+func (s *SQLStore) AddBalances(address string, balance structs.Amount) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	_, err := db.Exec("INSERT INTO balances (address, balance) VALUES (?, ?)", address, balance)
+	_, err := s.db.Exec("INSERT INTO balances (address, balance) VALUES (?, ?)", address, balance)
 	if err != nil {
 		log.Println("Error adding balance:", err)
 	}
 }
 
 // LoadBalances fetches all balances from the SQL database and returns them as a map.
-func LoadBalances() map[string]float64 {
-	dbMutex.Lock()
-	defer dbMutex.Unlock()
+func (s *SQLStore) LoadBalances() map[string]structs.Amount {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	rows, err := db.Query("SELECT address, balance FROM balances")
+	rows, err := s.db.Query("SELECT address, balance FROM balances")
 	if err != nil {
 		log.Println("Error loading balances:", err)
 		return nil
 	}
 	defer rows.Close()
 
-	balances := make(map[string]float64)
+	balances := make(map[string]structs.Amount)
 	for rows.Next() {
 		var address string
-		var balance float64
+		var balance structs.Amount
 		if err := rows.Scan(&address, &balance); err != nil {
 			log.Println("Error scanning balance row:", err)
 			continue
@@ -230,12 +231,49 @@ func LoadBalances() map[string]float64 {
 	return balances
 }
 
-func UpdateArtOwnership(artID string, artOwnership structs.ArtOwnership) error {
-	dbMutex.Lock()
-	defer dbMutex.Unlock()
+// UpdateNonce stores the next nonce expected from address, adding the row on
+// the sender's first transaction.
+func (s *SQLStore) UpdateNonce(address string, nonce uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.db.Exec(s.upsertNonceQuery(), address, nonce)
+	if err != nil {
+		log.Println("Error updating nonce:", err)
+	}
+}
+
+// LoadNonces fetches all stored nonces from the SQL database and returns them as a map.
+func (s *SQLStore) LoadNonces() map[string]uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rows, err := s.db.Query("SELECT address, nonce FROM account_nonces")
+	if err != nil {
+		log.Println("Error loading nonces:", err)
+		return nil
+	}
+	defer rows.Close()
+
+	nonces := make(map[string]uint64)
+	for rows.Next() {
+		var address string
+		var nonce uint64
+		if err := rows.Scan(&address, &nonce); err != nil {
+			log.Println("Error scanning nonce row:", err)
+			continue
+		}
+		nonces[address] = nonce
+	}
+
+	return nonces
+}
+
+func (s *SQLStore) UpdateArtOwnership(artID string, artOwnership structs.ArtOwnership) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	fmt.Println(artOwnership.Status)
-	_, err := db.Exec("UPDATE art_ownership SET ArtOwner=?, Price=?, Description=?, Format=?, Art=?, RelatedImages=?, RelatedVideos=?, ArtName=?, ArtLikes=?, ForSale=?, Thumbnail=?, Status=? WHERE Id=?",
-		artOwnership.ArtOwner, artOwnership.Price, artOwnership.Description, artOwnership.Format, artOwnership.Art, artOwnership.RelatedImages, artOwnership.RelatedVideos, artOwnership.ArtName, artOwnership.ArtLikes, artOwnership.ForSale, artOwnership.Thumbnail, artOwnership.Status.String(), artID)
+	err := updateArtOwnership(s.db, artID, artOwnership)
 	if err != nil {
 		log.Println("Error updating art ownership:", err)
 		return err
@@ -243,12 +281,12 @@ func UpdateArtOwnership(artID string, artOwnership structs.ArtOwnership) error {
 	return nil
 }
 
-func FetchArtOwnershipByOwner(artOwner string) ([]structs.ArtOwnership, error) {
-	dbMutex.Lock()
+func (s *SQLStore) FetchArtOwnershipByOwner(artOwner string) ([]structs.ArtOwnership, error) {
+	s.mu.Lock()
 	fmt.Println(artOwner)
-	defer dbMutex.Unlock()
+	defer s.mu.Unlock()
 
-	rows, err := db.Query("SELECT Id, ArtOwner, Price, Description, Format, Art, RelatedImages, RelatedVideos, ArtName, ArtLikes, ForSale, Thumbnail FROM art_ownership WHERE ArtOwner=?", artOwner)
+	rows, err := s.db.Query("SELECT Id, ArtOwner, Price, Description, Format, Art, RelatedImages, RelatedVideos, ArtName, ArtLikes, ForSale, Thumbnail FROM art_ownership WHERE ArtOwner=?", artOwner)
 	if err != nil {
 		log.Println("Error fetching art ownership by owner:", err)
 		return nil, err
@@ -258,11 +296,14 @@ func FetchArtOwnershipByOwner(artOwner string) ([]structs.ArtOwnership, error) {
 	var artOwnershipList []structs.ArtOwnership
 	for rows.Next() {
 		var artOwnership structs.ArtOwnership
-		err := rows.Scan(&artOwnership.Id, &artOwnership.ArtOwner, &artOwnership.Price, &artOwnership.Description, &artOwnership.Format, &artOwnership.Art, &artOwnership.RelatedImages, &artOwnership.RelatedVideos, &artOwnership.ArtName, &artOwnership.ArtLikes, &artOwnership.ForSale, &artOwnership.Thumbnail)
+		var relatedImages, relatedVideos sql.NullString
+		err := rows.Scan(&artOwnership.Id, &artOwnership.ArtOwner, &artOwnership.Price, &artOwnership.Description, &artOwnership.Format, &artOwnership.Art, &relatedImages, &relatedVideos, &artOwnership.ArtName, &artOwnership.ArtLikes, &artOwnership.ForSale, &artOwnership.Thumbnail)
 		if err != nil {
 			log.Println("Error scanning art ownership row:", err)
 			continue
 		}
+		artOwnership.RelatedImages = decodeMediaList(relatedImages.String)
+		artOwnership.RelatedVideos = decodeMediaList(relatedVideos.String)
 		artOwnershipList = append(artOwnershipList, artOwnership)
 		fmt.Println("Art ID: ", artOwnership.Id)
 		fmt.Println("art owner: ", artOwner)
@@ -271,11 +312,11 @@ func FetchArtOwnershipByOwner(artOwner string) ([]structs.ArtOwnership, error) {
 	return artOwnershipList, nil
 }
 
-func LoadArtOwnership() map[string]structs.ArtOwnership {
-	dbMutex.Lock()
-	defer dbMutex.Unlock()
+func (s *SQLStore) LoadArtOwnership() map[string]structs.ArtOwnership {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	rows, err := db.Query("SELECT Id, ArtOwner, Price, Description, Format, Art, RelatedImages, RelatedVideos, ArtName, ArtLikes, ForSale, Thumbnail, Status FROM art_ownership")
+	rows, err := s.db.Query("SELECT Id, ArtOwner, Price, Description, Format, Art, RelatedImages, RelatedVideos, ArtName, ArtLikes, ForSale, Thumbnail, Status FROM art_ownership")
 	if err != nil {
 		log.Println("Error loading art ownership:", err)
 		return nil
@@ -285,11 +326,14 @@ func LoadArtOwnership() map[string]structs.ArtOwnership {
 	artOwnershipMap := make(map[string]structs.ArtOwnership)
 	for rows.Next() {
 		var artOwnership structs.ArtOwnership
+		var relatedImages, relatedVideos sql.NullString
 		var status = ""
-		if err := rows.Scan(&artOwnership.Id, &artOwnership.ArtOwner, &artOwnership.Price, &artOwnership.Description, &artOwnership.Format, &artOwnership.Art, &artOwnership.RelatedImages, &artOwnership.RelatedVideos, &artOwnership.ArtName, &artOwnership.ArtLikes, &artOwnership.ForSale, &artOwnership.Thumbnail, &status); err != nil {
+		if err := rows.Scan(&artOwnership.Id, &artOwnership.ArtOwner, &artOwnership.Price, &artOwnership.Description, &artOwnership.Format, &artOwnership.Art, &relatedImages, &relatedVideos, &artOwnership.ArtName, &artOwnership.ArtLikes, &artOwnership.ForSale, &artOwnership.Thumbnail, &status); err != nil {
 			log.Println("Error scanning art ownership row:", err)
 			continue
 		}
+		artOwnership.RelatedImages = decodeMediaList(relatedImages.String)
+		artOwnership.RelatedVideos = decodeMediaList(relatedVideos.String)
 		if status == "Pending" || status == "" {
 			artOwnership.Status = structs.Pending
 		} else {
@@ -301,21 +345,20 @@ func LoadArtOwnership() map[string]structs.ArtOwnership {
 	return artOwnershipMap
 }
 
-func AddArtOwnership(artOwnership structs.ArtOwnership) {
-	dbMutex.Lock()
-	defer dbMutex.Unlock()
+func (s *SQLStore) AddArtOwnership(artOwnership structs.ArtOwnership) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	_, err := db.Exec("INSERT INTO art_ownership (Id, ArtOwner, Price, Description, Format, Art, RelatedImages, RelatedVideos, ArtName, ArtLikes, ForSale, Thumbnail,Status) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
-		artOwnership.Id, artOwnership.ArtOwner, artOwnership.Price, artOwnership.Description, artOwnership.Format, artOwnership.Art, artOwnership.RelatedImages, artOwnership.RelatedVideos, artOwnership.ArtName, artOwnership.ArtLikes, artOwnership.ForSale, artOwnership.Thumbnail, artOwnership.Status.String())
+	err := insertArtOwnership(s.db, artOwnership)
 	if err != nil {
 		log.Println("Error adding art ownership:", err)
 	}
 }
-func LoadArtOwnershipSummary(start int, count int) map[string]structs.ArtOwnershipSummary {
-	dbMutex.Lock()
-	defer dbMutex.Unlock()
+func (s *SQLStore) LoadArtOwnershipSummary(start int, count int) map[string]structs.ArtOwnershipSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	rows, err := db.Query("SELECT Id, Thumbnail, ArtLikes, ForSale, Price, Status FROM art_ownership LIMIT ?, ?", start, count)
+	rows, err := s.db.Query("SELECT Id, Thumbnail, ArtLikes, ForSale, Price, Status FROM art_ownership LIMIT ?, ?", start, count)
 	if err != nil {
 		log.Println("Error loading art ownership summary:", err)
 		return nil
@@ -345,18 +388,17 @@ func LoadArtOwnershipSummary(start int, count int) map[string]structs.ArtOwnersh
 	return artOwnershipSummaryMap
 }
 
-func AddBlock(block *structs.Block) {
-	dbMutex.Lock()
-	defer dbMutex.Unlock()
+func (s *SQLStore) AddBlock(block *structs.Block) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	tx, err := db.Begin()
+	tx, err := s.db.Begin()
 	if err != nil {
 		log.Println("Error starting transaction:", err)
 		return
 	}
 
-	_, err = tx.Exec("INSERT INTO blocks (block_index, timestamp, hash, prev_hash) VALUES (?, ?, ?, ?)",
-		block.Index, block.Timestamp, block.Hash, block.PrevHash)
+	err = insertBlock(tx, block)
 	if err != nil {
 		log.Println("Error adding block:", err)
 		tx.Rollback()
@@ -369,19 +411,443 @@ func AddBlock(block *structs.Block) {
 	}
 }
 
+// CommitBlock writes the block, its transactions and every state change it
+// causes in one database transaction. If any statement fails the transaction
+// is rolled back and nothing is written.
+func (s *SQLStore) CommitBlock(commit BlockCommit) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("starting block %d: %v", commit.Block.Index, err)
+	}
+	if err := s.writeBlockCommit(tx, commit); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("committing block %d: %v", commit.Block.Index, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing block %d: %v", commit.Block.Index, err)
+	}
+	return nil
+}
+
+func (s *SQLStore) writeBlockCommit(tx *sql.Tx, commit BlockCommit) error {
+	if err := insertBlock(tx, commit.Block); err != nil {
+		return err
+	}
+	var blockTime int64
+	if t, ok := commit.Block.Time(); ok {
+		blockTime = t.UnixNano()
+	}
+	for position, confirmed := range commit.Transactions {
+		if err := insertTransaction(tx, confirmed, commit.Block.Index, position, blockTime); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(deletePendingQuery, confirmed.TransactionId); err != nil {
+			return err
+		}
+	}
+	for _, receipt := range commit.Receipts {
+		if err := insertReceipt(tx, receipt); err != nil {
+			return err
+		}
+	}
+	for address, balance := range commit.Balances {
+		if _, err := tx.Exec(s.upsertBalanceQuery(), address, balance); err != nil {
+			return err
+		}
+	}
+	for address, nonce := range commit.Nonces {
+		if _, err := tx.Exec(s.upsertNonceQuery(), address, nonce); err != nil {
+			return err
+		}
+	}
+	// Uploads in blocks from other nodes never passed through this node's
+	// pool, so their rows may not exist yet.
+	for artID, artOwnership := range commit.ArtOwnership {
+		if err := upsertArtOwnership(tx, artID, artOwnership); err != nil {
+			return err
+		}
+	}
+	for address, stake := range commit.Stakes {
+		if err := s.writeStake(tx, address, stake); err != nil {
+			return err
+		}
+	}
+	undo, err := json.Marshal(commit.Undo)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO block_undo (block_index, undo) VALUES (?, ?)", commit.Block.Index, string(undo))
+	return err
+}
+
+// LoadBlockUndo returns nil, nil for blocks committed before undo records
+// were kept.
+func (s *SQLStore) LoadBlockUndo(blockIndex int) (*BlockUndo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var encoded string
+	err := s.db.QueryRow("SELECT undo FROM block_undo WHERE block_index = ?", blockIndex).Scan(&encoded)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var undo BlockUndo
+	if err := json.Unmarshal([]byte(encoded), &undo); err != nil {
+		return nil, fmt.Errorf("decoding undo record of block %d: %v", blockIndex, err)
+	}
+	return &undo, nil
+}
+
+// CommitReorg removes the blocks after reorg.ForkIndex, restores what they
+// overwrote, returns the orphaned transactions to the pending pool and
+// writes the new branch, all in one database transaction.
+func (s *SQLStore) CommitReorg(reorg Reorg) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("starting reorg after block %d: %v", reorg.ForkIndex, err)
+	}
+	if err := s.writeReorg(tx, reorg); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("reorganizing after block %d: %v", reorg.ForkIndex, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("reorganizing after block %d: %v", reorg.ForkIndex, err)
+	}
+	return nil
+}
+
+func (s *SQLStore) writeReorg(tx *sql.Tx, reorg Reorg) error {
+	for _, query := range []string{
+		"DELETE FROM transaction_receipts WHERE block_index > ?",
+		"DELETE FROM transactions WHERE block_index > ?",
+		"DELETE FROM blocks WHERE block_index > ?",
+		"DELETE FROM block_undo WHERE block_index > ?",
+	} {
+		if _, err := tx.Exec(query, reorg.ForkIndex); err != nil {
+			return err
+		}
+	}
+	for address, balance := range reorg.Restore.Balances {
+		if _, err := tx.Exec(s.upsertBalanceQuery(), address, balance); err != nil {
+			return err
+		}
+	}
+	for address, nonce := range reorg.Restore.Nonces {
+		if _, err := tx.Exec(s.upsertNonceQuery(), address, nonce); err != nil {
+			return err
+		}
+	}
+	for artID, artOwnership := range reorg.Restore.ArtOwnership {
+		if err := upsertArtOwnership(tx, artID, artOwnership); err != nil {
+			return err
+		}
+	}
+	for address, stake := range reorg.Restore.Stakes {
+		if err := s.writeStake(tx, address, stake); err != nil {
+			return err
+		}
+	}
+	for _, orphaned := range reorg.Orphaned {
+		if err := insertPendingTransaction(tx, orphaned); err != nil {
+			return err
+		}
+	}
+	for _, commit := range reorg.Commits {
+		if err := s.writeBlockCommit(tx, commit); err != nil {
+			return fmt.Errorf("block %d: %v", commit.Block.Index, err)
+		}
+	}
+	return nil
+}
+
+// CommitGenesis stores the genesis record and its allocations in one
+// database transaction. It fails if a genesis is already recorded.
+func (s *SQLStore) CommitGenesis(commit GenesisCommit) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("starting genesis: %v", err)
+	}
+	if err := s.writeGenesis(tx, commit); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("committing genesis: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing genesis: %v", err)
+	}
+	return nil
+}
+
+func (s *SQLStore) writeGenesis(tx *sql.Tx, commit GenesisCommit) error {
+	var existing string
+	err := tx.QueryRow("SELECT hash FROM chain_genesis LIMIT 1").Scan(&existing)
+	if err == nil {
+		return fmt.Errorf("storage already holds genesis %s", existing)
+	}
+	if err != sql.ErrNoRows {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO chain_genesis (hash, chain_id, document) VALUES (?, ?, ?)", commit.Hash, commit.ChainID, commit.Document); err != nil {
+		return err
+	}
+	for address, balance := range commit.Balances {
+		if _, err := tx.Exec(s.upsertBalanceQuery(), address, balance); err != nil {
+			return err
+		}
+	}
+	for _, val := range commit.Validators {
+		if _, err := tx.Exec("INSERT INTO validators (address, stake) VALUES (?, ?)", val.Address, val.Stake); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLStore) LoadGenesisHash() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var hash string
+	err := s.db.QueryRow("SELECT hash FROM chain_genesis LIMIT 1").Scan(&hash)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return hash, err
+}
+
+func (s *SQLStore) CountLikes() (map[string]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rows, err := s.db.Query("SELECT art_id, COUNT(*) FROM art_likes GROUP BY art_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var artID string
+		var count int
+		if err := rows.Scan(&artID, &count); err != nil {
+			return nil, err
+		}
+		counts[artID] = count
+	}
+	return counts, rows.Err()
+}
+
+// ReplaceDerivedState empties balances, account_nonces and validators and
+// refills them from derived, then updates or inserts its art rows, all in one
+// database transaction.
+func (s *SQLStore) ReplaceDerivedState(derived DerivedState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("starting reindex: %v", err)
+	}
+	if err := s.writeDerivedState(tx, derived); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("writing reindexed state: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("writing reindexed state: %v", err)
+	}
+	return nil
+}
+
+func (s *SQLStore) writeDerivedState(tx *sql.Tx, derived DerivedState) error {
+	if _, err := tx.Exec("DELETE FROM balances"); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM account_nonces"); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM validators"); err != nil {
+		return err
+	}
+	for address, balance := range derived.Balances {
+		if _, err := tx.Exec(s.upsertBalanceQuery(), address, balance); err != nil {
+			return err
+		}
+	}
+	for address, nonce := range derived.Nonces {
+		if _, err := tx.Exec(s.upsertNonceQuery(), address, nonce); err != nil {
+			return err
+		}
+	}
+	for address, stake := range derived.Stakes {
+		if err := s.writeStake(tx, address, stake); err != nil {
+			return err
+		}
+	}
+	for artID, artOwnership := range derived.ArtOwnership {
+		if err := upsertArtOwnership(tx, artID, artOwnership); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx, so a statement can run on
+// its own or as part of a block commit.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+const (
+	deletePendingQuery   = "DELETE FROM pending_transactions WHERE id = ?"
+	deleteValidatorQuery = "DELETE FROM validators WHERE address = ?"
+)
+
+func (s *SQLStore) upsertBalanceQuery() string {
+	if s.dialect == DialectSQLite {
+		return "INSERT INTO balances (address, balance) VALUES (?, ?) ON CONFLICT (address) DO UPDATE SET balance = excluded.balance"
+	}
+	return "INSERT INTO balances (address, balance) VALUES (?, ?) ON DUPLICATE KEY UPDATE balance = VALUES(balance)"
+}
+
+// writeStake sets address's stake, removing the validator when it is zero.
+func (s *SQLStore) writeStake(e execer, address string, stake structs.Amount) error {
+	if stake == 0 {
+		_, err := e.Exec(deleteValidatorQuery, address)
+		return err
+	}
+	query := "INSERT INTO validators (address, stake) VALUES (?, ?) ON DUPLICATE KEY UPDATE stake = VALUES(stake)"
+	if s.dialect == DialectSQLite {
+		query = "INSERT INTO validators (address, stake) VALUES (?, ?) ON CONFLICT (address) DO UPDATE SET stake = excluded.stake"
+	}
+	_, err := e.Exec(query, address, stake)
+	return err
+}
+
+func (s *SQLStore) upsertNonceQuery() string {
+	if s.dialect == DialectSQLite {
+		return "INSERT INTO account_nonces (address, nonce) VALUES (?, ?) ON CONFLICT (address) DO UPDATE SET nonce = excluded.nonce"
+	}
+	return "INSERT INTO account_nonces (address, nonce) VALUES (?, ?) ON DUPLICATE KEY UPDATE nonce = VALUES(nonce)"
+}
+
+// insertBlock stores a block header. timestamp_ns is only stored from
+// HeaderBlockVersion on, the proposer and signature from SignedBlockVersion
+// on and the VRF proof from VRFBlockVersion on; older blocks never had them.
+func insertBlock(e execer, block *structs.Block) error {
+	var timestampNanos interface{}
+	if block.Version >= structs.HeaderBlockVersion {
+		timestampNanos = block.TimestampNanos
+	}
+	var proposer, signature interface{}
+	if block.Version >= structs.SignedBlockVersion {
+		proposer, signature = block.Proposer, block.Signature
+	}
+	var vrfProof interface{}
+	if block.Version >= structs.VRFBlockVersion {
+		vrfProof = block.VRFProof
+	}
+	_, err := e.Exec("INSERT INTO blocks (block_index, timestamp, hash, prev_hash, merkle_root, version, timestamp_ns, proposer, signature, vrf_proof) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		block.Index, block.Timestamp, block.Hash, block.PrevHash, block.MerkleRoot, block.Version, timestampNanos, proposer, signature, vrfProof)
+	return err
+}
+
+func insertPendingTransaction(e execer, tx structs.Transaction) error {
+	_, err := e.Exec("INSERT INTO pending_transactions (id, type, ArtID, FromAddress, ToAddress, Amount, Fee, Signature, Status, block_index, ArtOwnershipId, version, ArtOwnershipData, nonce, buyer_signature) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		tx.TransactionId, tx.Type, tx.ArtID, tx.From, tx.To, tx.Amount, tx.Fee, tx.Signature, tx.Status.String(), nil, tx.ArtOwnership.Id, tx.Version, encodeArtOwnershipData(tx), tx.Nonce, buyerSignature(tx))
+	return err
+}
+
+// insertTransaction stores a confirmed transaction. blockTime is the block's
+// Unix time in nanoseconds, 0 if unknown.
+func insertTransaction(e execer, tx structs.Transaction, blockIndex int, position int, blockTime int64) error {
+	var storedTime interface{}
+	if blockTime != 0 {
+		storedTime = blockTime
+	}
+	_, err := e.Exec("INSERT INTO transactions (id, type, ArtID, FromAddress, ToAddress, Amount, Fee, Signature, Status, block_index, block_position, ArtOwnershipId, version, ArtOwnershipData, nonce, block_time, buyer_signature) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		tx.TransactionId, tx.Type, tx.ArtID, tx.From, tx.To, tx.Amount, tx.Fee, tx.Signature, tx.Status.String(), blockIndex, position, tx.ArtOwnership.Id, tx.Version, encodeArtOwnershipData(tx), tx.Nonce, storedTime, buyerSignature(tx))
+	return err
+}
+
+// buyerSignature is the value stored for tx's buyer signature: NULL for the
+// transactions that have none.
+func buyerSignature(tx structs.Transaction) interface{} {
+	if tx.BuyerSignature == "" {
+		return nil
+	}
+	return tx.BuyerSignature
+}
+
+// insertReceipt uses REPLACE, which MySQL and SQLite both understand, so a
+// transaction resubmitted after failing keeps only its latest receipt.
+func insertReceipt(e execer, receipt structs.Receipt) error {
+	var blockIndex, blockHash, changes interface{}
+	if receipt.Status != structs.Failed {
+		blockIndex = receipt.BlockIndex
+		blockHash = receipt.BlockHash
+	}
+	if len(receipt.Changes) > 0 {
+		encoded, err := json.Marshal(receipt.Changes)
+		if err != nil {
+			return err
+		}
+		changes = string(encoded)
+	}
+	_, err := e.Exec("REPLACE INTO transaction_receipts (transaction_id, status, block_index, block_hash, block_position, changes, error, recorded_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		receipt.TransactionId, receipt.Status.String(), blockIndex, blockHash, receipt.Position, changes, receipt.Error, receipt.RecordedAt)
+	return err
+}
+
+func insertArtOwnership(e execer, artOwnership structs.ArtOwnership) error {
+	_, err := e.Exec("INSERT INTO art_ownership (Id, ArtOwner, Price, Description, Format, Art, RelatedImages, RelatedVideos, ArtName, ArtLikes, ForSale, Thumbnail,Status) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		artOwnership.Id, artOwnership.ArtOwner, artOwnership.Price, artOwnership.Description, artOwnership.Format, artOwnership.Art, encodeMediaList(artOwnership.RelatedImages), encodeMediaList(artOwnership.RelatedVideos), artOwnership.ArtName, artOwnership.ArtLikes, artOwnership.ForSale, artOwnership.Thumbnail, artOwnership.Status.String())
+	return err
+}
+
+// upsertArtOwnership updates the art row, or inserts it if there is none.
+func upsertArtOwnership(tx *sql.Tx, artID string, artOwnership structs.ArtOwnership) error {
+	var exists bool
+	if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM art_ownership WHERE Id=?)", artID).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return updateArtOwnership(tx, artID, artOwnership)
+	}
+	artOwnership.Id = artID
+	return insertArtOwnership(tx, artOwnership)
+}
+
+func updateArtOwnership(e execer, artID string, artOwnership structs.ArtOwnership) error {
+	_, err := e.Exec("UPDATE art_ownership SET ArtOwner=?, Price=?, Description=?, Format=?, Art=?, RelatedImages=?, RelatedVideos=?, ArtName=?, ArtLikes=?, ForSale=?, Thumbnail=?, Status=? WHERE Id=?",
+		artOwnership.ArtOwner, artOwnership.Price, artOwnership.Description, artOwnership.Format, artOwnership.Art, encodeMediaList(artOwnership.RelatedImages), encodeMediaList(artOwnership.RelatedVideos), artOwnership.ArtName, artOwnership.ArtLikes, artOwnership.ForSale, artOwnership.Thumbnail, artOwnership.Status.String(), artID)
+	return err
+}
+
 // LoadBlocks fetches blocks and their transactions from the SQL database starting from the given index and returns them as a slice.
-// It fetches a maximum of 100 blocks at a time.
-func LoadBlocks(startBlockIndex *int) ([]*structs.Block, error) {
-	dbMutex.Lock()
-	defer dbMutex.Unlock()
+// It fetches a maximum of 100 blocks at a time. A row that cannot be read fails the whole load, so a
+// block is never returned without some of its transactions.
+func (s *SQLStore) LoadBlocks(startBlockIndex *int) ([]*structs.Block, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
 	var rows *sql.Rows
 	var err error
 
 	if startBlockIndex != nil {
-		rows, err = db.Query("SELECT block_index, timestamp, hash, prev_hash FROM blocks WHERE index > ? LIMIT 100", *startBlockIndex)
+		rows, err = s.db.Query("SELECT block_index, timestamp, hash, prev_hash, merkle_root, version, timestamp_ns, proposer, signature, vrf_proof FROM blocks WHERE block_index > ? ORDER BY block_index LIMIT 100", *startBlockIndex)
 	} else {
-		rows, err = db.Query("SELECT block_index, timestamp, hash, prev_hash FROM blocks LIMIT 100")
+		rows, err = s.db.Query("SELECT block_index, timestamp, hash, prev_hash, merkle_root, version, timestamp_ns, proposer, signature, vrf_proof FROM blocks ORDER BY block_index LIMIT 100")
 	}
 
 	if err != nil {
@@ -393,70 +859,92 @@ func LoadBlocks(startBlockIndex *int) ([]*structs.Block, error) {
 	var blocks []*structs.Block
 	for rows.Next() {
 		var block structs.Block
-		if err := rows.Scan(&block.Index, &block.Timestamp, &block.Hash, &block.PrevHash); err != nil {
-			log.Println("Error scanning block row:", err)
-			continue
+		var merkleRoot sql.NullString
+		var timestampNanos sql.NullInt64
+		var proposer, signature, vrfProof sql.NullString
+		if err := rows.Scan(&block.Index, &block.Timestamp, &block.Hash, &block.PrevHash, &merkleRoot, &block.Version, &timestampNanos, &proposer, &signature, &vrfProof); err != nil {
+			return nil, fmt.Errorf("scanning block row: %v", err)
+		}
+		block.MerkleRoot = merkleRoot.String
+		block.TimestampNanos = timestampNanos.Int64
+		block.Proposer = proposer.String
+		block.Signature = signature.String
+		block.VRFProof = vrfProof.String
+		if t, ok := block.Time(); ok && !timestampNanos.Valid {
+			block.TimestampNanos = t.UnixNano()
 		}
 
 		// Load transactions for this block
-		txRows, err := db.Query("SELECT id, type, ArtID, FromAddress, ToAddress, Amount, Fee, Signature FROM transactions WHERE block_index = ?", block.Index)
+		txRows, err := s.db.Query("SELECT id, type, ArtID, FromAddress, ToAddress, Amount, Fee, Signature, ArtOwnershipId, version, ArtOwnershipData, nonce, buyer_signature FROM transactions WHERE block_index = ? ORDER BY block_position, id", block.Index)
 		if err != nil {
-			log.Println("Error loading transactions for block:", err)
-			continue
+			return nil, fmt.Errorf("loading transactions of block %d: %v", block.Index, err)
 		}
 
 		var transactions []structs.Transaction
 		for txRows.Next() {
 			var tx structs.Transaction
-			if err := txRows.Scan(&tx.ArtID, &tx.Type, &tx.ArtID, &tx.From, &tx.To, &tx.Amount, &tx.Fee, &tx.Signature); err != nil {
-				log.Println("Error scanning transaction row:", err)
-				continue
+			var artOwnershipId, artOwnershipData, buyerSignature sql.NullString
+			if err := txRows.Scan(&tx.TransactionId, &tx.Type, &tx.ArtID, &tx.From, &tx.To, &tx.Amount, &tx.Fee, &tx.Signature, &artOwnershipId, &tx.Version, &artOwnershipData, &tx.Nonce, &buyerSignature); err != nil {
+				txRows.Close()
+				return nil, fmt.Errorf("scanning transaction of block %d: %v", block.Index, err)
 			}
+			tx.BuyerSignature = buyerSignature.String
+			decodeArtOwnershipData(&tx, artOwnershipId.String, artOwnershipData.String)
+			// Transactions are stored once applied, but the block hash and the
+			// signature were computed while they were still pending.
+			tx.Status = structs.Pending
 			transactions = append(transactions, tx)
 		}
+		err = txRows.Err()
 		txRows.Close()
+		if err != nil {
+			return nil, fmt.Errorf("loading transactions of block %d: %v", block.Index, err)
+		}
 
 		block.Transactions = transactions
 		blocks = append(blocks, &block)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("loading blocks: %v", err)
+	}
 
 	return blocks, nil
 }
 
 // AddUser adds a new user to the SQL database.
-func AddUser(username string, data []string) {
-	dbMutex.Lock()
-	defer dbMutex.Unlock()
+func (s *SQLStore) AddUser(username string, data []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
 	// Serialize the data array to a string, for example, by joining the elements with a delimiter
 	serializedData := strings.Join(data, "SEPARATE")
 
-	_, err := db.Exec("INSERT INTO users (username, data) VALUES (?, ?)", username, serializedData)
+	_, err := s.db.Exec("INSERT INTO users (username, data) VALUES (?, ?)", username, serializedData)
 	if err != nil {
 		log.Println("Error adding user:", err)
 	}
 }
 
 // UpdateUser updates the data for a given username in the SQL database.
-func UpdateUser(username string, data []string) {
-	dbMutex.Lock()
-	defer dbMutex.Unlock()
+func (s *SQLStore) UpdateUser(username string, data []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
 	// Serialize the data array to a string
 	serializedData := strings.Join(data, ",")
 
-	_, err := db.Exec("UPDATE users SET data=? WHERE username=?", serializedData, username)
+	_, err := s.db.Exec("UPDATE users SET data=? WHERE username=?", serializedData, username)
 	if err != nil {
 		log.Println("Error updating user:", err)
 	}
 }
 
 // LoadUsers fetches all user records from the SQL database and returns them as a map.
-func LoadUsers() map[string][]string {
-	dbMutex.Lock()
-	defer dbMutex.Unlock()
+func (s *SQLStore) LoadUsers() map[string][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	rows, err := db.Query("SELECT username, data FROM users")
+	rows, err := s.db.Query("SELECT username, data FROM users")
 	if err != nil {
 		log.Println("Error loading users:", err)
 		return nil
@@ -480,11 +968,11 @@ func LoadUsers() map[string][]string {
 	return userDatabase
 }
 
-func AlreadyLiked(artID string, userID string) (bool, error) {
+func (s *SQLStore) AlreadyLiked(artID string, userID string) (bool, error) {
 	var exists bool
 
 	query := `SELECT EXISTS(SELECT 1 FROM art_likes WHERE art_id=? AND user_id=?)`
-	err := db.QueryRow(query, artID, userID).Scan(&exists)
+	err := s.db.QueryRow(query, artID, userID).Scan(&exists)
 	if err != nil {
 		return false, err
 	}
@@ -492,16 +980,16 @@ func AlreadyLiked(artID string, userID string) (bool, error) {
 	return exists, nil
 }
 
-func AddLike(artID string, userID string) error {
+func (s *SQLStore) AddLike(artID string, userID string) error {
 	// First, check if the user has already liked this art piece
-	liked, err := AlreadyLiked(artID, userID)
+	liked, err := s.AlreadyLiked(artID, userID)
 	if err != nil {
 		return err
 	}
 
 	// If not liked, then add a like
 	if !liked {
-		_, err := db.Exec("INSERT INTO art_likes (art_id, user_id) VALUES (?, ?)", artID, userID)
+		_, err := s.db.Exec("INSERT INTO art_likes (art_id, user_id) VALUES (?, ?)", artID, userID)
 		if err != nil {
 			return err
 		}
@@ -517,9 +1005,9 @@ func AddLike(artID string, userID string) error {
 	return nil
 }
 
-func IncrementArtLike(artID string) error {
+func (s *SQLStore) IncrementArtLike(artID string) error {
 	query := "UPDATE art_ownership SET ArtLikes = ArtLikes + 1 WHERE Id = ?"
-	_, err := db.Exec(query, artID)
+	_, err := s.db.Exec(query, artID)
 	if err != nil {
 		fmt.Println(err.Error())
 		return err
@@ -527,20 +1015,21 @@ func IncrementArtLike(artID string) error {
 	return nil
 }
 
-func FetchArtOwnershipByArtID(artID string) (*structs.ArtOwnership, error) {
-	dbMutex.Lock()
-	defer dbMutex.Unlock()
+func (s *SQLStore) FetchArtOwnershipByArtID(artID string) (*structs.ArtOwnership, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
 	// Prepare the SQL query
 	query := `SELECT Id, ArtOwner, Price, Description, Format, Art, RelatedImages, RelatedVideos, ArtName, ArtLikes, ForSale, Thumbnail, Status FROM art_ownership WHERE Id = ?`
 
 	// Execute the query
-	row := db.QueryRow(query, artID)
+	row := s.db.QueryRow(query, artID)
 
 	// Scan the result into an ArtOwnership struct
 	var artOwnership structs.ArtOwnership
+	var relatedImages, relatedVideos sql.NullString
 	var status string
-	err := row.Scan(&artOwnership.Id, &artOwnership.ArtOwner, &artOwnership.Price, &artOwnership.Description, &artOwnership.Format, &artOwnership.Art, &artOwnership.RelatedImages, &artOwnership.RelatedVideos, &artOwnership.ArtName, &artOwnership.ArtLikes, &artOwnership.ForSale, &artOwnership.Thumbnail, &status)
+	err := row.Scan(&artOwnership.Id, &artOwnership.ArtOwner, &artOwnership.Price, &artOwnership.Description, &artOwnership.Format, &artOwnership.Art, &relatedImages, &relatedVideos, &artOwnership.ArtName, &artOwnership.ArtLikes, &artOwnership.ForSale, &artOwnership.Thumbnail, &status)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			// No result found
@@ -550,6 +1039,9 @@ func FetchArtOwnershipByArtID(artID string) (*structs.ArtOwnership, error) {
 		return nil, err
 	}
 
+	artOwnership.RelatedImages = decodeMediaList(relatedImages.String)
+	artOwnership.RelatedVideos = decodeMediaList(relatedVideos.String)
+
 	// Convert the status string to the corresponding enum value
 	if status == "Pending" {
 		artOwnership.Status = structs.Pending
@@ -560,15 +1052,82 @@ func FetchArtOwnershipByArtID(artID string) (*structs.ArtOwnership, error) {
 	return &artOwnership, nil
 }
 
-func GetMediaData(mediaID string) ([]byte, string, error) {
+func (s *SQLStore) GetMediaData(mediaID string) ([]byte, string, error) {
 	var (
 		data      []byte
 		mediaType string
 	)
 
 	query := "SELECT data, media_type FROM media WHERE id = ?"
-	row := db.QueryRow(query, mediaID)
+	row := s.db.QueryRow(query, mediaID)
 	err := row.Scan(&data, &mediaType)
 
 	return data, mediaType, err
 }
+
+func (s *SQLStore) AddMediaData(mediaID string, data []byte, mediaType string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.db.Exec("INSERT INTO media (id, data, media_type) VALUES (?, ?, ?)", mediaID, data, mediaType)
+	if err != nil {
+		log.Println("Error adding media:", err)
+	}
+	return err
+}
+
+// encodeMediaList stores a list of media IDs/URLs as a JSON array, since
+// database/sql cannot bind a []string directly.
+func encodeMediaList(list []string) string {
+	if len(list) == 0 {
+		return "[]"
+	}
+	encoded, err := json.Marshal(list)
+	if err != nil {
+		log.Println("Error encoding media list:", err)
+		return "[]"
+	}
+	return string(encoded)
+}
+
+// decodeMediaList reverses encodeMediaList. Rows written by hand from the
+// original README schema hold comma-separated values, which are still accepted.
+func decodeMediaList(value string) []string {
+	value = strings.TrimSpace(value)
+	if value == "" || value == "[]" {
+		return nil
+	}
+	if strings.HasPrefix(value, "[") {
+		var list []string
+		if err := json.Unmarshal([]byte(value), &list); err != nil {
+			log.Println("Error decoding media list:", err)
+			return nil
+		}
+		return list
+	}
+	return strings.Split(value, ",")
+}
+
+// encodeArtOwnershipData stores the transaction's ArtOwnership payload as JSON.
+// Version 0 transactions only ever covered the Id, so nothing more is kept.
+func encodeArtOwnershipData(tx structs.Transaction) interface{} {
+	if tx.Version == structs.LegacyTransactionVersion {
+		return nil
+	}
+	encoded, err := json.Marshal(tx.ArtOwnership)
+	if err != nil {
+		log.Println("Error encoding art ownership payload:", err)
+		return nil
+	}
+	return string(encoded)
+}
+
+func decodeArtOwnershipData(tx *structs.Transaction, artOwnershipId string, artOwnershipData string) {
+	tx.ArtOwnership.Id = artOwnershipId
+	if artOwnershipData == "" {
+		return
+	}
+	if err := json.Unmarshal([]byte(artOwnershipData), &tx.ArtOwnership); err != nil {
+		log.Println("Error decoding art ownership payload:", err)
+	}
+}
@@ -0,0 +1,24 @@
+package sqldatabase
+
+import (
+	"database/sql"
+	"fmt"
+
+	_ "github.com/mattn/go-sqlite3" // SQLite driver
+)
+
+// OpenSQLite opens the SQLite database file at path, creating an empty file
+// if needed. Tables are created by Migrate.
+func OpenSQLite(path string) (*SQLStore, error) {
+	dsn := fmt.Sprintf("file:%s?_busy_timeout=5000&_journal_mode=WAL&_foreign_keys=on", path)
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open database: %v", err)
+	}
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Could not open the database file %s: %v", path, err)
+	}
+
+	return &SQLStore{db: db, dialect: DialectSQLite}, nil
+}
@@ -0,0 +1,436 @@
+package sqldatabase
+
+import (
+	"fmt"
+	"indicartcoin/config"
+	"indicartcoin/structs"
+)
+
+// Store is the persistence layer used by the node. Every table the node
+// reads or writes is reached through it, so the MySQL backend can be swapped
+// for another implementation (for example MemoryStore) without touching the
+// callers.
+type Store interface {
+	Close() error
+
+	// Genesis
+	CommitGenesis(commit GenesisCommit) error
+	LoadGenesisHash() (string, error)
+
+	// Blocks
+	AddBlock(block *structs.Block)
+	CommitBlock(commit BlockCommit) error
+	LoadBlocks(startBlockIndex *int) ([]*structs.Block, error)
+	LoadBlockUndo(blockIndex int) (*BlockUndo, error)
+	CommitReorg(reorg Reorg) error
+
+	// Confirmed transactions
+	AddTransaction(tx structs.Transaction, blockIndex int, position int)
+	SearchTransactions(query TransactionQuery) (*TransactionPage, error)
+
+	// Receipts
+	AddReceipt(receipt structs.Receipt)
+	LoadReceipt(transactionId string) (*structs.Receipt, error)
+
+	// Pending pool
+	AddPendingTransaction(tx structs.Transaction)
+	LoadPendingTransactions() []structs.Transaction
+	DeletePendingTransaction(transactionId string)
+
+	// Balances
+	AddBalances(address string, balance structs.Amount)
+	UpdateBalance(address string, balance structs.Amount)
+	LoadBalances() map[string]structs.Amount
+
+	// Nonces
+	UpdateNonce(address string, nonce uint64)
+	LoadNonces() map[string]uint64
+
+	// Art ownership
+	AddArtOwnership(artOwnership structs.ArtOwnership)
+	UpdateArtOwnership(artID string, artOwnership structs.ArtOwnership) error
+	FetchArtOwnershipByArtID(artID string) (*structs.ArtOwnership, error)
+	FetchArtOwnershipByOwner(artOwner string) ([]structs.ArtOwnership, error)
+	LoadArtOwnership() map[string]structs.ArtOwnership
+	LoadArtOwnershipSummary(start int, count int) map[string]structs.ArtOwnershipSummary
+
+	// Users
+	AddUser(username string, data []string)
+	UpdateUser(username string, data []string)
+	LoadUsers() map[string][]string
+
+	// Likes
+	AlreadyLiked(artID string, userID string) (bool, error)
+	AddLike(artID string, userID string) error
+	IncrementArtLike(artID string) error
+	CountLikes() (map[string]int, error)
+
+	// Reindexing
+	ReplaceDerivedState(derived DerivedState) error
+
+	// Validators, written only from genesis, blocks and reindexing
+	LoadValidators() []structs.Validator
+
+	// Media
+	AddMediaData(mediaID string, data []byte, mediaType string) error
+	GetMediaData(mediaID string) ([]byte, string, error)
+
+	// Peer book
+	SavePeer(peer structs.KnownPeer)
+	DeletePeer(address string)
+	LoadPeers() []structs.KnownPeer
+	SaveBan(ban structs.PeerBan)
+	DeleteBan(address string)
+	LoadBans() []structs.PeerBan
+}
+
+// BlockCommit is everything sealing a block changes in storage: the block,
+// its transactions in block order, and the final balances, nonces, stakes and
+// art rows it leaves behind. Store.CommitBlock writes all of it or none of it.
+// A stake of zero removes the validator.
+type BlockCommit struct {
+	Block        *structs.Block
+	Transactions []structs.Transaction // also removed from the pending pool
+	Receipts     []structs.Receipt
+	Balances     map[string]structs.Amount
+	Nonces       map[string]uint64
+	ArtOwnership map[string]structs.ArtOwnership
+	Stakes       map[string]structs.Amount
+	Undo         BlockUndo // stored with the block so a reorg can revert it
+}
+
+// BlockUndo is what a block overwrote: every balance, nonce, stake and art
+// row in its BlockCommit as it was before the block.
+// An art row the block created is kept as Pending, the way the pool stores an
+// upload. Writing a BlockUndo back reverts the block.
+type BlockUndo struct {
+	Balances     map[string]structs.Amount       `json:"balances"`
+	Nonces       map[string]uint64               `json:"nonces"`
+	ArtOwnership map[string]structs.ArtOwnership `json:"artOwnership"`
+	Stakes       map[string]structs.Amount       `json:"stakes,omitempty"`
+}
+
+// Reorg moves the stored chain onto another branch. The blocks after
+// ForkIndex are removed with their transactions and receipts, Restore (the
+// undo records of those blocks, merged) is written back, the Orphaned
+// transactions return to the pending pool, and Commits are written on top in
+// order. Store.CommitReorg writes all of it or none of it.
+type Reorg struct {
+	ForkIndex int
+	Restore   BlockUndo
+	Orphaned  []structs.Transaction
+	Commits   []BlockCommit
+}
+
+// GenesisCommit records the genesis a database is initialized from, together
+// with the balances and validators it allocates. Store.CommitGenesis writes
+// all of it or none of it, and only once per database.
+type GenesisCommit struct {
+	Hash       string
+	ChainID    string
+	Document   string // canonical genesis JSON
+	Balances   map[string]structs.Amount
+	Validators []structs.Validator
+}
+
+// DerivedState is the part of storage that should follow from the blocks
+// alone. Store.ReplaceDerivedState swaps it in atomically: balances and nonces
+// are replaced wholesale, art rows in ArtOwnership are updated or inserted,
+// and art rows missing from it are left alone since they also hold the
+// uploaded media.
+type DerivedState struct {
+	Balances     map[string]structs.Amount
+	Nonces       map[string]uint64
+	ArtOwnership map[string]structs.ArtOwnership
+	Stakes       map[string]structs.Amount
+}
+
+// store is the backend used by the package-level helpers below.
+var store Store
+
+// UseStore makes s the backend for every package-level function.
+func UseStore(s Store) {
+	store = s
+}
+
+// CurrentStore returns the backend selected by InitDatabase or UseStore.
+func CurrentStore() Store {
+	return store
+}
+
+// MigrationDryRun makes InitDatabase log pending schema migrations instead of
+// applying them.
+var MigrationDryRun bool
+
+// InitDatabase opens the backend selected in cfg and, for the SQL backends,
+// brings the schema up to date before returning.
+func InitDatabase(cfg config.StorageConfig) error {
+	switch cfg.Backend {
+	case "mysql":
+		s, err := OpenMySQL(cfg.MySQLDSN)
+		if err != nil {
+			return err
+		}
+		return useMigratedStore(s)
+	case "sqlite":
+		// The file is created on first start.
+		s, err := OpenSQLite(cfg.SQLitePath)
+		if err != nil {
+			return err
+		}
+		return useMigratedStore(s)
+	case "memory":
+		// Nothing is kept across restarts.
+		UseStore(NewMemoryStore())
+		return nil
+	default:
+		return fmt.Errorf("Unknown storage backend: %s", cfg.Backend)
+	}
+}
+
+func useMigratedStore(s *SQLStore) error {
+	if _, err := s.Migrate(MigrationDryRun); err != nil {
+		s.Close()
+		return err
+	}
+	if !MigrationDryRun {
+		if err := s.CheckAmountScale(structs.AmountDecimals); err != nil {
+			s.Close()
+			return err
+		}
+	}
+	UseStore(s)
+	return nil
+}
+
+// InitMemoryDatabase selects an empty in-memory backend, so the node can run
+// without a MySQL server.
+func InitMemoryDatabase() {
+	UseStore(NewMemoryStore())
+}
+
+func CloseDatabase() {
+	if store != nil {
+		store.Close()
+	}
+}
+
+func LoadValidators() []structs.Validator {
+	return store.LoadValidators()
+}
+
+// SearchTransactions returns one page of confirmed transactions matching
+// query, newest first.
+func SearchTransactions(query TransactionQuery) (*TransactionPage, error) {
+	return store.SearchTransactions(query)
+}
+
+// AddTransaction adds a new transaction to the SQL database.
+func AddTransaction(tx structs.Transaction, block_index int, position int) {
+	store.AddTransaction(tx, block_index, position)
+}
+
+// AddReceipt stores the receipt of a transaction, replacing any earlier one
+// with the same TransactionId.
+func AddReceipt(receipt structs.Receipt) {
+	store.AddReceipt(receipt)
+}
+
+// LoadReceipt returns the receipt of the transaction, or nil if it has none.
+func LoadReceipt(transactionId string) (*structs.Receipt, error) {
+	return store.LoadReceipt(transactionId)
+}
+
+func LoadPendingTransactions() []structs.Transaction {
+	return store.LoadPendingTransactions()
+}
+
+// AddPendingTransaction adds a new transaction to the pending pool.
+func AddPendingTransaction(tx structs.Transaction) {
+	store.AddPendingTransaction(tx)
+}
+
+// DeletePendingTransaction deletes a pending transaction from the SQL database based on the transaction ID.
+func DeletePendingTransaction(transactionId string) {
+	store.DeletePendingTransaction(transactionId)
+}
+
+// UpdateBalance sets the balance for a given address in the SQL database,
+// adding the row if the address has none yet.
+func UpdateBalance(address string, balance structs.Amount) {
+	store.UpdateBalance(address, balance)
+}
+
+// AddBalances inserts a new balance record for a given address in the SQL database.
+func AddBalances(address string, balance structs.Amount) {
+	store.AddBalances(address, balance)
+}
+
+// LoadBalances fetches all balances from the SQL database and returns them as a map.
+func LoadBalances() map[string]structs.Amount {
+	return store.LoadBalances()
+}
+
+// UpdateNonce stores the next nonce expected from address.
+func UpdateNonce(address string, nonce uint64) {
+	store.UpdateNonce(address, nonce)
+}
+
+// LoadNonces fetches the next expected nonce of every address that has sent a
+// confirmed transaction.
+func LoadNonces() map[string]uint64 {
+	return store.LoadNonces()
+}
+
+func UpdateArtOwnership(artID string, artOwnership structs.ArtOwnership) error {
+	return store.UpdateArtOwnership(artID, artOwnership)
+}
+
+func FetchArtOwnershipByOwner(artOwner string) ([]structs.ArtOwnership, error) {
+	return store.FetchArtOwnershipByOwner(artOwner)
+}
+
+func LoadArtOwnership() map[string]structs.ArtOwnership {
+	return store.LoadArtOwnership()
+}
+
+func AddArtOwnership(artOwnership structs.ArtOwnership) {
+	store.AddArtOwnership(artOwnership)
+}
+
+func LoadArtOwnershipSummary(start int, count int) map[string]structs.ArtOwnershipSummary {
+	return store.LoadArtOwnershipSummary(start, count)
+}
+
+// CommitGenesis initializes storage from a genesis.
+func CommitGenesis(commit GenesisCommit) error {
+	return store.CommitGenesis(commit)
+}
+
+// LoadGenesisHash returns the hash of the genesis storage was initialized
+// from, or "" if it never was.
+func LoadGenesisHash() (string, error) {
+	return store.LoadGenesisHash()
+}
+
+func AddBlock(block *structs.Block) {
+	store.AddBlock(block)
+}
+
+// CommitBlock writes a sealed block and all of its effects atomically.
+func CommitBlock(commit BlockCommit) error {
+	return store.CommitBlock(commit)
+}
+
+// LoadBlockUndo returns the undo record stored with a block, or nil if the
+// block was committed before undo records were kept.
+func LoadBlockUndo(blockIndex int) (*BlockUndo, error) {
+	return store.LoadBlockUndo(blockIndex)
+}
+
+// CommitReorg switches the stored chain to another branch atomically.
+func CommitReorg(reorg Reorg) error {
+	return store.CommitReorg(reorg)
+}
+
+// LoadBlocks fetches blocks and their transactions starting from the given index and returns them as a slice.
+// It fetches a maximum of 100 blocks at a time.
+func LoadBlocks(startBlockIndex *int) ([]*structs.Block, error) {
+	return store.LoadBlocks(startBlockIndex)
+}
+
+// LoadAllBlocks pages through LoadBlocks and returns the whole stored chain in
+// index order.
+func LoadAllBlocks() ([]*structs.Block, error) {
+	var all []*structs.Block
+	var start *int
+	for {
+		blocks, err := store.LoadBlocks(start)
+		if err != nil {
+			return nil, err
+		}
+		if len(blocks) == 0 {
+			return all, nil
+		}
+		all = append(all, blocks...)
+		last := blocks[len(blocks)-1].Index
+		start = &last
+	}
+}
+
+// AddUser adds a new user to the SQL database.
+func AddUser(username string, data []string) {
+	store.AddUser(username, data)
+}
+
+// UpdateUser updates the data for a given username in the SQL database.
+func UpdateUser(username string, data []string) {
+	store.UpdateUser(username, data)
+}
+
+// LoadUsers fetches all user records from the SQL database and returns them as a map.
+func LoadUsers() map[string][]string {
+	return store.LoadUsers()
+}
+
+func AlreadyLiked(artID string, userID string) (bool, error) {
+	return store.AlreadyLiked(artID, userID)
+}
+
+func AddLike(artID string, userID string) error {
+	return store.AddLike(artID, userID)
+}
+
+func IncrementArtLike(artID string) error {
+	return store.IncrementArtLike(artID)
+}
+
+// CountLikes returns the number of users who liked each art piece, from the
+// art_likes table rather than the ArtLikes counters.
+func CountLikes() (map[string]int, error) {
+	return store.CountLikes()
+}
+
+// ReplaceDerivedState overwrites balances, nonces and art ownership with
+// derived, all or nothing.
+func ReplaceDerivedState(derived DerivedState) error {
+	return store.ReplaceDerivedState(derived)
+}
+
+func FetchArtOwnershipByArtID(artID string) (*structs.ArtOwnership, error) {
+	return store.FetchArtOwnershipByArtID(artID)
+}
+
+func AddMediaData(mediaID string, data []byte, mediaType string) error {
+	return store.AddMediaData(mediaID, data, mediaType)
+}
+
+func GetMediaData(mediaID string) ([]byte, string, error) {
+	return store.GetMediaData(mediaID)
+}
+
+// SavePeer stores a peer book entry, replacing any with the same address.
+func SavePeer(peer structs.KnownPeer) {
+	store.SavePeer(peer)
+}
+
+func DeletePeer(address string) {
+	store.DeletePeer(address)
+}
+
+func LoadPeers() []structs.KnownPeer {
+	return store.LoadPeers()
+}
+
+// SaveBan stores a peer ban, replacing any on the same address.
+func SaveBan(ban structs.PeerBan) {
+	store.SaveBan(ban)
+}
+
+func DeleteBan(address string) {
+	store.DeleteBan(address)
+}
+
+func LoadBans() []structs.PeerBan {
+	return store.LoadBans()
+}
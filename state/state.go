@@ -1,59 +1,101 @@
 package state
 
 import (
-	"errors"
 	"indicartcoin/blockchain"
 	"indicartcoin/structs"
+	"sort"
 )
 
 type State struct {
-	Balances     map[string]float64              // Account balances
-	ArtOwnership map[string]structs.ArtOwnership // ArtID to Owner
+	Balances      map[string]structs.Amount       // Account balances
+	ArtOwnership  map[string]structs.ArtOwnership // ArtID to Owner
+	Nonces        map[string]uint64               // next nonce per sender, counting confirmed transactions
+	PendingNonces map[string]uint64               // next nonce per sender, counting the pending pool too
+	Stakes        map[string]structs.Amount       // validator stake, from genesis and Stake transactions
+	ChainID       string                          `json:"-"` // signed into canonical transactions
 }
 
-func (s *State) IsValidTransaction(tx structs.Transaction) (bool, error) {
-	isValid, err := blockchain.VerifySignature(tx.Serialize(), tx.Signature, tx.From)
+// Validators returns the addresses with stake, ordered by address.
+func (s *State) Validators() []structs.Validator {
+	vals := make([]structs.Validator, 0, len(s.Stakes))
+	for address, stake := range s.Stakes {
+		if stake > 0 {
+			vals = append(vals, structs.Validator{Address: address, Stake: stake})
+		}
+	}
+	sort.Slice(vals, func(i, j int) bool { return vals[i].Address < vals[j].Address })
+	return vals
+}
 
-	if !isValid || err != nil {
-		return false, err
+// NextNonce returns the nonce the next transaction from address must carry:
+// one past the highest nonce that is confirmed or waiting in the pool.
+func (s *State) NextNonce(address string) uint64 {
+	next := s.Nonces[address]
+	if pending := s.PendingNonces[address]; pending > next {
+		next = pending
 	}
+	return next
+}
 
-	switch tx.Type {
+// ReserveNonce records that tx entered the pending pool, so the sender's
+// following transaction has to use the next nonce.
+func (s *State) ReserveNonce(tx structs.Transaction) {
+	if tx.Version < structs.NoncedTransactionVersion {
+		return
+	}
+	if s.PendingNonces == nil {
+		s.PendingNonces = make(map[string]uint64)
+	}
+	if tx.Nonce+1 > s.PendingNonces[tx.From] {
+		s.PendingNonces[tx.From] = tx.Nonce + 1
+	}
+}
 
-	case structs.ArtUpload:
-		if tx.ArtID != tx.ArtOwnership.Id {
-			return false, errors.New("art Id not same in Transaction and Ownership")
-		}
-		if tx.To != tx.From {
-			return false, errors.New("to and From Different in Art Upload")
-		}
-	case structs.CoinTransfer:
-		if tx.ArtID != "" {
-			return false, errors.New("art Id Entered in Coin Transfer Transaction: " + tx.ArtID)
-		}
-		if !tx.ArtOwnership.IsArtOwnershipEmpty() {
-			return false, errors.New("art Ownership hold values in coin transfer")
-		}
-	case structs.ArtTransfer:
-		// Check if the sender owns the art
-		owner, ownsArt := s.ArtOwnership[tx.ArtID]
-		if !ownsArt || owner.ArtOwner != tx.From {
-			return false, errors.New("malicious Transaction")
-		}
-		// Additional checks can be added here (e.g., sufficient balance to pay transaction fees)
-		balance, Exists := s.Balances[tx.From]
-		if !Exists || float64(balance) < tx.Amount {
-			return false, errors.New("balance not sufficient")
+// ResetPendingNonces rebuilds PendingNonces from the transactions currently in
+// the pending pool.
+func (s *State) ResetPendingNonces(pending []structs.Transaction) {
+	reserved := &State{}
+	for _, tx := range pending {
+		reserved.ReserveNonce(tx)
+	}
+	s.PendingNonces = reserved.PendingNonces
+}
+
+// IsValidTransaction checks a newly submitted transaction: its version and
+// signatures, and that it would apply on top of the current state once the
+// sender's pending transactions are ahead of it (see Apply). Balances are
+// those of confirmed transactions; Transition checks again when the block is
+// built.
+func (s *State) IsValidTransaction(tx structs.Transaction) (bool, error) {
+	if tx.Version != structs.NoncedTransactionVersion {
+		return false, reject(tx, ErrUnsupportedVersion, "version %d, new transactions must use version %d", tx.Version, structs.NoncedTransactionVersion)
+	}
+	// Neither status is checked by the transition, but both end up in
+	// storage, so only the known ones are taken.
+	if !tx.Status.Known() || !tx.ArtOwnership.Status.Known() {
+		return false, reject(tx, ErrMalformedTransaction, "unknown status %d, art status %d", tx.Status, tx.ArtOwnership.Status)
+	}
+	isValid, err := blockchain.VerifySignature(tx.SignedMessage(s.ChainID), tx.Signature, tx.From)
+	if err != nil {
+		return false, reject(tx, ErrInvalidSignature, "%v", err)
+	}
+	if !isValid {
+		return false, reject(tx, ErrInvalidSignature, "signature does not match the sender")
+	}
+	if tx.NeedsBuyerSignature() {
+		isValid, err := blockchain.VerifySignature(tx.SignedMessage(s.ChainID), tx.BuyerSignature, tx.To)
+		if err != nil {
+			return false, reject(tx, ErrInvalidSignature, "buyer signature: %v", err)
 		}
-	case structs.ArtUpdate:
-		{
-			if tx.ArtID != tx.ArtOwnership.Id {
-				return false, errors.New("art Id not same in Transaction and Ownership")
-			}
-			if tx.To != tx.From {
-				return false, errors.New("to and From Different in Art Upload")
-			}
+		if !isValid {
+			return false, reject(tx, ErrInvalidSignature, "buyer signature does not match the recipient")
 		}
 	}
+
+	snapshot := s.Snapshot()
+	snapshot.Nonces[tx.From] = s.NextNonce(tx.From)
+	if err := snapshot.Apply(tx); err != nil {
+		return false, err
+	}
 	return true, nil
 }
@@ -0,0 +1,272 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"indicartcoin/structs"
+	"math"
+)
+
+// Reasons a transaction can be rejected by the state transition. Every
+// rejection is a *TransactionError wrapping one of these, so callers can test
+// for them with errors.Is.
+var (
+	ErrUnsupportedVersion   = errors.New("unsupported transaction version")
+	ErrInvalidSignature     = errors.New("invalid signature")
+	ErrMalformedTransaction = errors.New("malformed transaction")
+	ErrNegativeAmount       = errors.New("negative amount")
+	ErrSelfTransfer         = errors.New("sender and recipient are the same")
+	ErrInsufficientBalance  = errors.New("insufficient balance")
+	ErrBalanceOverflow      = errors.New("balance overflow")
+	ErrNonceUsed            = errors.New("nonce already used")
+	ErrNonceGap             = errors.New("nonce skips ahead")
+	ErrUnknownArt           = errors.New("unknown art")
+	ErrNotArtOwner          = errors.New("sender does not own the art")
+	ErrArtExists            = errors.New("art already exists")
+)
+
+// TransactionError reports why the transaction with TransactionId could not
+// be applied.
+type TransactionError struct {
+	TransactionId string
+	Err           error  // one of the Err values above
+	Detail        string // human readable specifics, may be empty
+
+	// For ErrInsufficientBalance, the account that is short (the seller or
+	// the buyer of an ArtTransfer) and the balance it would need.
+	Account string
+	Needs   structs.Amount
+}
+
+func (e *TransactionError) Error() string {
+	if e.Detail == "" {
+		return e.Err.Error()
+	}
+	return e.Err.Error() + ": " + e.Detail
+}
+
+func (e *TransactionError) Unwrap() error {
+	return e.Err
+}
+
+func reject(tx structs.Transaction, err error, format string, args ...interface{}) error {
+	return &TransactionError{TransactionId: tx.TransactionId, Err: err, Detail: fmt.Sprintf(format, args...)}
+}
+
+func insufficient(tx structs.Transaction, account string, needs structs.Amount, format string, args ...interface{}) error {
+	return &TransactionError{TransactionId: tx.TransactionId, Err: ErrInsufficientBalance, Detail: fmt.Sprintf(format, args...), Account: account, Needs: needs}
+}
+
+// Snapshot returns a deep copy of the balances, art ownership, nonces and
+// stakes, so a transition can be tried without touching s.
+func (s *State) Snapshot() *State {
+	snapshot := &State{
+		Balances:      make(map[string]structs.Amount, len(s.Balances)),
+		ArtOwnership:  make(map[string]structs.ArtOwnership, len(s.ArtOwnership)),
+		Nonces:        make(map[string]uint64, len(s.Nonces)),
+		PendingNonces: make(map[string]uint64, len(s.PendingNonces)),
+		Stakes:        make(map[string]structs.Amount, len(s.Stakes)),
+		ChainID:       s.ChainID,
+	}
+	for address, balance := range s.Balances {
+		snapshot.Balances[address] = balance
+	}
+	for artID, ownership := range s.ArtOwnership {
+		snapshot.ArtOwnership[artID] = ownership
+	}
+	for address, nonce := range s.Nonces {
+		snapshot.Nonces[address] = nonce
+	}
+	for address, nonce := range s.PendingNonces {
+		snapshot.PendingNonces[address] = nonce
+	}
+	for address, stake := range s.Stakes {
+		snapshot.Stakes[address] = stake
+	}
+	return snapshot
+}
+
+// Transition applies txs in order to a snapshot of s and returns the
+// resulting state. Each transaction is applied completely or not at all: one
+// that fails is left out of applied, its error is added to rejected, and the
+// following transactions see the state as if it had never been submitted.
+// s itself is not modified.
+func (s *State) Transition(txs []structs.Transaction) (next *State, applied []structs.Transaction, rejected []*TransactionError) {
+	next = s.Snapshot()
+	for _, tx := range txs {
+		if err := next.Apply(tx); err != nil {
+			var txErr *TransactionError
+			if !errors.As(err, &txErr) {
+				txErr = &TransactionError{TransactionId: tx.TransactionId, Err: err}
+			}
+			rejected = append(rejected, txErr)
+			continue
+		}
+		applied = append(applied, tx)
+	}
+	return next, applied, rejected
+}
+
+// Apply checks tx against s and, if it is valid, applies it: the sender pays
+// Amount plus Fee, the recipient receives Amount, or for a Stake the sender's
+// stake grows by it, art changes hands or is created or updated, and the
+// sender's nonce advances. In an ArtTransfer the buyer, To, pays Amount to
+// the seller, From, who pays only the Fee. On error s is left
+// unchanged. Fees are not credited here; they go to the validators when the
+// block is finalized. Signatures are not checked.
+func (s *State) Apply(tx structs.Transaction) error {
+	if tx.Amount < 0 || tx.Fee < 0 {
+		return reject(tx, ErrNegativeAmount, "amount %s, fee %s", tx.Amount, tx.Fee)
+	}
+	if tx.From == "" {
+		return reject(tx, ErrMalformedTransaction, "missing sender")
+	}
+
+	// Each sender's transactions are numbered 0, 1, 2, ... A nonce below the
+	// expected one replays an earlier transaction; one above it leaves a gap.
+	// Older transaction versions carry no nonce.
+	nonced := tx.Version >= structs.NoncedTransactionVersion
+	if expected := s.Nonces[tx.From]; nonced && tx.Nonce < expected {
+		return reject(tx, ErrNonceUsed, "nonce %d, next nonce is %d", tx.Nonce, expected)
+	} else if nonced && tx.Nonce > expected {
+		return reject(tx, ErrNonceGap, "nonce %d, next nonce is %d", tx.Nonce, expected)
+	}
+
+	var art structs.ArtOwnership
+	switch tx.Type {
+	case structs.CoinTransfer:
+		if tx.ArtID != "" {
+			return reject(tx, ErrMalformedTransaction, "art ID %s in coin transfer", tx.ArtID)
+		}
+		if !tx.ArtOwnership.IsArtOwnershipEmpty() {
+			return reject(tx, ErrMalformedTransaction, "art ownership in coin transfer")
+		}
+		if tx.To == "" {
+			return reject(tx, ErrMalformedTransaction, "missing recipient")
+		}
+		if tx.To == tx.From {
+			return reject(tx, ErrSelfTransfer, "")
+		}
+	case structs.ArtUpload:
+		if tx.ArtID == "" || tx.ArtID != tx.ArtOwnership.Id {
+			return reject(tx, ErrMalformedTransaction, "art ID %q does not match ownership ID %q", tx.ArtID, tx.ArtOwnership.Id)
+		}
+		if tx.To != tx.From {
+			return reject(tx, ErrMalformedTransaction, "art upload to another address")
+		}
+		// The pool stores an uploaded piece as Pending as soon as the upload
+		// is accepted, so only a row from someone else or an earlier upload
+		// is a conflict.
+		if existing, exists := s.ArtOwnership[tx.ArtID]; exists && (existing.Status != structs.Pending || existing.ArtOwner != tx.From) {
+			return reject(tx, ErrArtExists, "art %s", tx.ArtID)
+		}
+		art = tx.ArtOwnership
+		art.ArtOwner = tx.From
+	case structs.ArtTransfer:
+		existing, exists := s.ArtOwnership[tx.ArtID]
+		if !exists {
+			return reject(tx, ErrUnknownArt, "art %s", tx.ArtID)
+		}
+		if existing.ArtOwner != tx.From {
+			return reject(tx, ErrNotArtOwner, "art %s", tx.ArtID)
+		}
+		if tx.To == "" {
+			return reject(tx, ErrMalformedTransaction, "missing recipient")
+		}
+		if tx.To == tx.From {
+			return reject(tx, ErrSelfTransfer, "")
+		}
+		art = existing
+		art.ArtOwner = tx.To
+	case structs.ArtUpdate:
+		if tx.ArtID == "" || tx.ArtID != tx.ArtOwnership.Id {
+			return reject(tx, ErrMalformedTransaction, "art ID %q does not match ownership ID %q", tx.ArtID, tx.ArtOwnership.Id)
+		}
+		if tx.To != tx.From {
+			return reject(tx, ErrMalformedTransaction, "art update to another address")
+		}
+		existing, exists := s.ArtOwnership[tx.ArtID]
+		if !exists {
+			return reject(tx, ErrUnknownArt, "art %s", tx.ArtID)
+		}
+		if existing.ArtOwner != tx.From {
+			return reject(tx, ErrNotArtOwner, "art %s", tx.ArtID)
+		}
+		// Ownership and likes are not the owner's to edit.
+		art = tx.ArtOwnership
+		art.ArtOwner = existing.ArtOwner
+		art.ArtLikes = existing.ArtLikes
+	case structs.Stake:
+		if tx.ArtID != "" || !tx.ArtOwnership.IsArtOwnershipEmpty() {
+			return reject(tx, ErrMalformedTransaction, "art in stake")
+		}
+		if tx.To != tx.From {
+			return reject(tx, ErrMalformedTransaction, "stake for another address")
+		}
+		if tx.Amount == 0 {
+			return reject(tx, ErrMalformedTransaction, "stake of nothing")
+		}
+		if s.Stakes[tx.From] > math.MaxInt64-tx.Amount {
+			return reject(tx, ErrBalanceOverflow, "stake")
+		}
+	default:
+		return reject(tx, ErrMalformedTransaction, "unknown transaction type %d", tx.Type)
+	}
+
+	if tx.Amount > math.MaxInt64-tx.Fee {
+		return reject(tx, ErrBalanceOverflow, "amount plus fee")
+	}
+	cost := tx.Amount + tx.Fee
+	if tx.Type == structs.ArtTransfer {
+		// The buyer pays the price to the seller, who only pays the fee.
+		cost = tx.Fee
+		if balance := s.Balances[tx.To]; balance < tx.Amount {
+			return insufficient(tx, tx.To, tx.Amount, "buyer balance %s, needs %s", balance, tx.Amount)
+		}
+	}
+	if balance := s.Balances[tx.From]; balance < cost {
+		return insufficient(tx, tx.From, cost, "balance %s, needs %s", balance, cost)
+	}
+	switch {
+	case tx.Type == structs.ArtTransfer:
+		if s.Balances[tx.From]-cost > math.MaxInt64-tx.Amount {
+			return reject(tx, ErrBalanceOverflow, "seller balance")
+		}
+	case tx.Amount > 0 && tx.Type != structs.Stake && tx.To != tx.From:
+		if s.Balances[tx.To] > math.MaxInt64-tx.Amount {
+			return reject(tx, ErrBalanceOverflow, "recipient balance")
+		}
+	}
+
+	// Every check passed; nothing below can fail.
+	if s.Balances == nil {
+		s.Balances = make(map[string]structs.Amount)
+	}
+	s.Balances[tx.From] -= cost
+	switch {
+	case tx.Type == structs.ArtTransfer:
+		s.Balances[tx.To] -= tx.Amount
+		s.Balances[tx.From] += tx.Amount
+	case tx.Type == structs.Stake:
+		if s.Stakes == nil {
+			s.Stakes = make(map[string]structs.Amount)
+		}
+		s.Stakes[tx.From] += tx.Amount
+	case tx.To != "":
+		s.Balances[tx.To] += tx.Amount
+	}
+	if tx.Type != structs.CoinTransfer && tx.Type != structs.Stake {
+		if s.ArtOwnership == nil {
+			s.ArtOwnership = make(map[string]structs.ArtOwnership)
+		}
+		art.Status = structs.Confirmed
+		s.ArtOwnership[tx.ArtID] = art
+	}
+	if nonced {
+		if s.Nonces == nil {
+			s.Nonces = make(map[string]uint64)
+		}
+		s.Nonces[tx.From] = tx.Nonce + 1
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package state
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"indicartcoin/blockchain"
+	"indicartcoin/structs"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// testState holds alice's art-1, and 100 for alice, 50 for bob and 10
+// staked by carol.
+func testState() *State {
+	return &State{
+		Balances: map[string]structs.Amount{"alice": 100, "bob": 50},
+		ArtOwnership: map[string]structs.ArtOwnership{
+			"art-1": {Id: "art-1", ArtOwner: "alice", ArtName: "Tide", Price: 30, Status: structs.Confirmed},
+		},
+		Nonces:        map[string]uint64{"alice": 2},
+		PendingNonces: map[string]uint64{},
+		Stakes:        map[string]structs.Amount{"carol": 10},
+		ChainID:       "test",
+	}
+}
+
+func TestApplyRejects(t *testing.T) {
+	coins := func(amount, fee structs.Amount, nonce uint64) structs.Transaction {
+		return structs.Transaction{TransactionId: "tx", Type: structs.CoinTransfer, From: "alice", To: "bob", Amount: amount, Fee: fee, Nonce: nonce, Version: structs.NoncedTransactionVersion}
+	}
+	with := func(tx structs.Transaction, change func(*structs.Transaction)) structs.Transaction {
+		change(&tx)
+		return tx
+	}
+	tests := []struct {
+		name  string
+		setup func(*State)
+		tx    structs.Transaction
+		want  error
+	}{
+		{"negative amount", nil, coins(-1, 0, 2), ErrNegativeAmount},
+		{"negative fee", nil, coins(1, -1, 2), ErrNegativeAmount},
+		{"missing sender", nil, with(coins(1, 0, 2), func(tx *structs.Transaction) { tx.From = "" }), ErrMalformedTransaction},
+		{"missing recipient", nil, with(coins(1, 0, 2), func(tx *structs.Transaction) { tx.To = "" }), ErrMalformedTransaction},
+		{"art in coin transfer", nil, with(coins(1, 0, 2), func(tx *structs.Transaction) { tx.ArtID = "art-1" }), ErrMalformedTransaction},
+		{"unknown type", nil, with(coins(1, 0, 2), func(tx *structs.Transaction) { tx.Type = 9 }), ErrMalformedTransaction},
+		{"self transfer", nil, with(coins(1, 0, 2), func(tx *structs.Transaction) { tx.To = "alice" }), ErrSelfTransfer},
+		{"nonce used", nil, coins(1, 0, 1), ErrNonceUsed},
+		{"nonce gap", nil, coins(1, 0, 3), ErrNonceGap},
+		{"amount plus fee beyond balance", nil, coins(100, 1, 2), ErrInsufficientBalance},
+		{"amount plus fee overflows", nil, coins(math.MaxInt64, 1, 2), ErrBalanceOverflow},
+		{"recipient balance overflows", func(s *State) { s.Balances["bob"] = math.MaxInt64 }, coins(1, 0, 2), ErrBalanceOverflow},
+		{"stake overflows", func(s *State) { s.Stakes["alice"] = math.MaxInt64 }, with(coins(1, 0, 2), func(tx *structs.Transaction) { tx.Type, tx.To = structs.Stake, "alice" }), ErrBalanceOverflow},
+		{"stake for another address", nil, with(coins(1, 0, 2), func(tx *structs.Transaction) { tx.Type = structs.Stake }), ErrMalformedTransaction},
+		{"transfer of unknown art", nil, with(coins(0, 0, 2), func(tx *structs.Transaction) { tx.Type, tx.ArtID = structs.ArtTransfer, "art-2" }), ErrUnknownArt},
+		{"transfer of someone else's art", nil, with(coins(0, 0, 0), func(tx *structs.Transaction) {
+			tx.Type, tx.ArtID, tx.From, tx.To = structs.ArtTransfer, "art-1", "bob", "carol"
+		}), ErrNotArtOwner},
+		{"update of someone else's art", nil, with(coins(0, 0, 0), func(tx *structs.Transaction) {
+			tx.Type, tx.ArtID, tx.From, tx.To = structs.ArtUpdate, "art-1", "bob", "bob"
+			tx.ArtOwnership = structs.ArtOwnership{Id: "art-1", ArtName: "Mine now"}
+		}), ErrNotArtOwner},
+		{"upload over existing art", nil, with(coins(0, 0, 0), func(tx *structs.Transaction) {
+			tx.Type, tx.ArtID, tx.From, tx.To = structs.ArtUpload, "art-1", "bob", "bob"
+			tx.ArtOwnership = structs.ArtOwnership{Id: "art-1", ArtName: "Copy"}
+		}), ErrArtExists},
+		{"upload with mismatched IDs", nil, with(coins(0, 0, 2), func(tx *structs.Transaction) {
+			tx.Type, tx.ArtID, tx.To = structs.ArtUpload, "art-2", "alice"
+			tx.ArtOwnership = structs.ArtOwnership{Id: "art-3"}
+		}), ErrMalformedTransaction},
+		{"buyer short of the price", func(s *State) { s.Balances["bob"] = 29 }, with(coins(30, 1, 2), func(tx *structs.Transaction) { tx.Type, tx.ArtID = structs.ArtTransfer, "art-1" }), ErrInsufficientBalance},
+		{"seller balance overflows", func(s *State) { s.Balances["alice"] = math.MaxInt64 }, with(coins(30, 1, 2), func(tx *structs.Transaction) { tx.Type, tx.ArtID = structs.ArtTransfer, "art-1" }), ErrBalanceOverflow},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := testState()
+			if test.setup != nil {
+				test.setup(s)
+			}
+			before := s.Snapshot()
+			err := s.Apply(test.tx)
+			if !errors.Is(err, test.want) {
+				t.Fatalf("got error %v, want %v", err, test.want)
+			}
+			var txErr *TransactionError
+			if !errors.As(err, &txErr) || txErr.TransactionId != test.tx.TransactionId {
+				t.Errorf("got error %#v, want a *TransactionError for %s", err, test.tx.TransactionId)
+			}
+			if !reflect.DeepEqual(s, before) {
+				t.Errorf("state changed by a rejected transaction: %+v, was %+v", s, before)
+			}
+		})
+	}
+}
+
+func TestApplyArtTransferBuyerPays(t *testing.T) {
+	s := testState()
+	sale := structs.Transaction{TransactionId: "sale", Type: structs.ArtTransfer, From: "alice", To: "bob", ArtID: "art-1", Amount: 30, Fee: 2, Nonce: 2, Version: structs.NoncedTransactionVersion}
+	if err := s.Apply(sale); err != nil {
+		t.Fatal(err)
+	}
+	// The seller receives the price and pays only the fee.
+	if s.Balances["alice"] != 128 || s.Balances["bob"] != 20 {
+		t.Errorf("balances %v after the sale, want alice 128 and bob 20", s.Balances)
+	}
+	if owner := s.ArtOwnership["art-1"].ArtOwner; owner != "bob" {
+		t.Errorf("art-1 owned by %s after the sale, want bob", owner)
+	}
+	if s.Nonces["alice"] != 3 || s.Nonces["bob"] != 0 {
+		t.Errorf("nonces %v after the sale, want alice 3 and bob untouched", s.Nonces)
+	}
+
+	// Whichever side is short is the one the error names.
+	for _, test := range []struct {
+		name     string
+		balances map[string]structs.Amount
+		account  string
+		needs    structs.Amount
+	}{
+		{"buyer short", map[string]structs.Amount{"alice": 100, "bob": 29}, "bob", 30},
+		{"seller short of the fee", map[string]structs.Amount{"alice": 1, "bob": 50}, "alice", 2},
+	} {
+		s := testState()
+		s.Balances = test.balances
+		var txErr *TransactionError
+		if err := s.Apply(sale); !errors.As(err, &txErr) || !errors.Is(err, ErrInsufficientBalance) {
+			t.Errorf("%s: got error %v, want ErrInsufficientBalance", test.name, err)
+			continue
+		}
+		if txErr.Account != test.account || txErr.Needs != test.needs {
+			t.Errorf("%s: error names %q needing %s, want %q needing %s", test.name, txErr.Account, txErr.Needs, test.account, test.needs)
+		}
+	}
+}
+
+func TestIsValidTransactionSignatures(t *testing.T) {
+	sellerKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buyerKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seller, buyer := blockchain.PublicKeyPEM(sellerKey), blockchain.PublicKeyPEM(buyerKey)
+	sign := func(tx structs.Transaction, key *rsa.PrivateKey) string {
+		signature, err := blockchain.Sign(tx.SignedMessage("test"), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signature
+	}
+	sale := func(amount structs.Amount, change func(*structs.Transaction)) structs.Transaction {
+		tx := structs.Transaction{TransactionId: "sale", Type: structs.ArtTransfer, From: seller, To: buyer, ArtID: "art-1", Amount: amount, Fee: 1, Version: structs.NoncedTransactionVersion}
+		tx.Signature = sign(tx, sellerKey)
+		if amount > 0 {
+			tx.BuyerSignature = sign(tx, buyerKey)
+		}
+		if change != nil {
+			change(&tx)
+		}
+		return tx
+	}
+	tests := []struct {
+		name string
+		tx   structs.Transaction
+		want error // nil for a valid transaction
+	}{
+		{"signed by both", sale(30, nil), nil},
+		{"gift without buyer signature", sale(0, nil), nil},
+		{"version 1", sale(30, func(tx *structs.Transaction) { tx.Version = 1 }), ErrUnsupportedVersion},
+		{"unknown status", sale(30, func(tx *structs.Transaction) { tx.Status = 7 }), ErrMalformedTransaction},
+		{"unknown art status", sale(30, func(tx *structs.Transaction) { tx.ArtOwnership.Status = -1 }), ErrMalformedTransaction},
+		{"price changed after signing", sale(30, func(tx *structs.Transaction) { tx.Amount = 1 }), ErrInvalidSignature},
+		{"no buyer signature", sale(30, func(tx *structs.Transaction) { tx.BuyerSignature = "" }), ErrInvalidSignature},
+		{"buyer signature by the seller", sale(30, func(tx *structs.Transaction) { tx.BuyerSignature = tx.Signature }), ErrInvalidSignature},
+		{"signed by both, buyer short", sale(60, nil), ErrInsufficientBalance},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := &State{
+				Balances:     map[string]structs.Amount{seller: 10, buyer: 50},
+				ArtOwnership: map[string]structs.ArtOwnership{"art-1": {Id: "art-1", ArtOwner: seller, Status: structs.Confirmed}},
+				Nonces:       map[string]uint64{},
+				Stakes:       map[string]structs.Amount{},
+				ChainID:      "test",
+			}
+			valid, err := s.IsValidTransaction(test.tx)
+			if test.want == nil {
+				if !valid || err != nil {
+					t.Fatalf("got %v, %v, want the transaction accepted", valid, err)
+				}
+			} else if valid || !errors.Is(err, test.want) {
+				t.Fatalf("got %v, %v, want error %v", valid, err, test.want)
+			}
+			if s.Balances[seller] != 10 || s.Balances[buyer] != 50 || s.ArtOwnership["art-1"].ArtOwner != seller {
+				t.Errorf("checking the transaction changed the state: %v", s)
+			}
+		})
+	}
+}
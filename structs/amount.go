@@ -0,0 +1,156 @@
+package structs
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Amount is a quantity of coins counted in the smallest unit, so that
+// balances, fees, prices and stakes add up exactly. One coin is
+// 10^AmountDecimals units.
+type Amount int64
+
+// AmountDecimals is the number of decimal places one coin is divided into.
+// It is part of the chain's parameters: main sets it from the configuration
+// at startup and it must never change for an existing chain.
+var AmountDecimals = 9
+
+// MaxAmountDecimals keeps 10^AmountDecimals within an int64.
+const MaxAmountDecimals = 18
+
+var errAmountRange = errors.New("amount out of range")
+
+func unitsPerCoin() *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(AmountDecimals)), nil)
+}
+
+// ParseAmount parses a decimal coin value such as "12.5", "-3", "0.000000001"
+// or "1e-3" exactly. It fails if the value has more fractional digits than
+// AmountDecimals allows or does not fit in an Amount.
+func ParseAmount(s string) (Amount, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, errors.New("empty amount")
+	}
+	value, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	value.Mul(value, new(big.Rat).SetInt(unitsPerCoin()))
+	if !value.IsInt() {
+		return 0, fmt.Errorf("amount %q has more than %d decimal places", s, AmountDecimals)
+	}
+	units := value.Num()
+	if !units.IsInt64() {
+		return 0, errAmountRange
+	}
+	return Amount(units.Int64()), nil
+}
+
+// Format writes the amount in coins with exactly digits fractional digits.
+// Digits beyond AmountDecimals are zeros; fewer digits truncate.
+func (a Amount) Format(digits int) string {
+	units := new(big.Int).SetInt64(int64(a))
+	negative := units.Sign() < 0
+	units.Abs(units)
+
+	whole, frac := new(big.Int).QuoRem(units, unitsPerCoin(), new(big.Int))
+	fracDigits := ""
+	if AmountDecimals > 0 {
+		fracDigits = frac.String()
+		fracDigits = strings.Repeat("0", AmountDecimals-len(fracDigits)) + fracDigits
+	}
+	if digits > len(fracDigits) {
+		fracDigits += strings.Repeat("0", digits-len(fracDigits))
+	} else {
+		fracDigits = fracDigits[:digits]
+	}
+
+	var b strings.Builder
+	if negative {
+		b.WriteByte('-')
+	}
+	b.WriteString(whole.String())
+	if digits > 0 {
+		b.WriteByte('.')
+		b.WriteString(fracDigits)
+	}
+	return b.String()
+}
+
+// String formats the amount in coins without trailing zeros, e.g. "12.5".
+func (a Amount) String() string {
+	s := a.Format(AmountDecimals)
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	return s
+}
+
+// MarshalJSON writes the amount as an exact JSON number in coins.
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalJSON accepts a JSON number or a string holding a decimal number.
+// The literal text is parsed exactly; it never passes through a float64.
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	text := string(data)
+	if text == "null" {
+		return nil
+	}
+	if strings.HasPrefix(text, `"`) {
+		unquoted, err := strconv.Unquote(text)
+		if err != nil {
+			return fmt.Errorf("invalid amount %s", text)
+		}
+		text = unquoted
+	}
+	parsed, err := ParseAmount(text)
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
+
+// Value stores the amount as an integer number of units.
+func (a Amount) Value() (driver.Value, error) {
+	return int64(a), nil
+}
+
+// Scan reads an integer number of units.
+func (a *Amount) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*a = 0
+	case int64:
+		*a = Amount(v)
+	case float64:
+		if v != math.Trunc(v) || math.Abs(v) > math.MaxInt64 {
+			return fmt.Errorf("amount column holds non-integer %v", v)
+		}
+		*a = Amount(v)
+	case []byte:
+		return a.scanText(string(v))
+	case string:
+		return a.scanText(v)
+	default:
+		return fmt.Errorf("cannot scan %T into Amount", src)
+	}
+	return nil
+}
+
+func (a *Amount) scanText(text string) error {
+	units, err := strconv.ParseInt(text, 10, 64)
+	if err != nil {
+		return fmt.Errorf("amount column holds %q: %v", text, err)
+	}
+	*a = Amount(units)
+	return nil
+}
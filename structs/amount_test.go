@@ -0,0 +1,136 @@
+package structs
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
+
+// withDecimals runs f with AmountDecimals set to decimals.
+func withDecimals(t *testing.T, decimals int, f func()) {
+	t.Helper()
+	saved := AmountDecimals
+	AmountDecimals = decimals
+	defer func() { AmountDecimals = saved }()
+	f()
+}
+
+func TestParseAmountRoundTrip(t *testing.T) {
+	tests := []struct {
+		decimals int
+		text     string
+		units    Amount
+		formats  string // String's output, when it differs from text
+	}{
+		{9, "0", 0, ""},
+		{9, "12.5", 12500000000, ""},
+		{9, "0.000000001", 1, ""},
+		{9, "1e-3", 1000000, "0.001"},
+		{9, "12.500000000", 12500000000, "12.5"},
+		{9, "-3", -3000000000, ""},
+		{9, "-0.5", -500000000, ""},
+		{9, "9223372036.854775807", math.MaxInt64, ""},
+		{9, "-9223372036.854775808", math.MinInt64, ""},
+		{2, "19.99", 1999, ""},
+		{0, "42", 42, ""},
+		{18, "9.223372036854775807", math.MaxInt64, ""},
+	}
+	for _, test := range tests {
+		withDecimals(t, test.decimals, func() {
+			units, err := ParseAmount(test.text)
+			if err != nil {
+				t.Errorf("%d decimals, %q: %v", test.decimals, test.text, err)
+				return
+			}
+			if units != test.units {
+				t.Errorf("%d decimals, %q parsed to %d units, want %d", test.decimals, test.text, int64(units), int64(test.units))
+			}
+			want := test.formats
+			if want == "" {
+				want = test.text
+			}
+			if got := units.String(); got != want {
+				t.Errorf("%d decimals, %d units format as %q, want %q", test.decimals, int64(units), got, want)
+			}
+			if again, err := ParseAmount(units.String()); err != nil || again != units {
+				t.Errorf("%d decimals, %q parses back to %d, %v, want %d", test.decimals, units.String(), int64(again), err, int64(units))
+			}
+		})
+	}
+}
+
+func TestParseAmountRejects(t *testing.T) {
+	tests := []struct {
+		decimals int
+		text     string
+		problem  string // part of the error
+	}{
+		{9, "0.0000000001", "decimal places"},
+		{9, "-0.0000000001", "decimal places"},
+		{2, "19.999", "decimal places"},
+		{0, "0.5", "decimal places"},
+		{9, "9223372036.854775808", errAmountRange.Error()},
+		{9, "-9223372036.854775809", errAmountRange.Error()},
+		{9, "1e30", errAmountRange.Error()},
+		{18, "10", errAmountRange.Error()},
+		{9, "", "empty"},
+		{9, "twelve", "invalid"},
+	}
+	for _, test := range tests {
+		withDecimals(t, test.decimals, func() {
+			units, err := ParseAmount(test.text)
+			if err == nil {
+				t.Errorf("%d decimals, %q parsed to %d units, want an error", test.decimals, test.text, int64(units))
+			} else if !strings.Contains(err.Error(), test.problem) {
+				t.Errorf("%d decimals, %q: got error %v, want one about %q", test.decimals, test.text, err, test.problem)
+			}
+		})
+	}
+	if _, err := ParseAmount("9223372036.854775808"); !errors.Is(err, errAmountRange) {
+		t.Errorf("overflow: got error %v, want errAmountRange", err)
+	}
+}
+
+func TestAmountFormat(t *testing.T) {
+	tests := []struct {
+		units  Amount
+		digits int
+		want   string
+	}{
+		{12500000000, 9, "12.500000000"},
+		{12500000000, 2, "12.50"},
+		{12500000000, 0, "12"},
+		{1, 12, "0.000000001000"},
+		{1, 3, "0.000"},
+		{-1, 9, "-0.000000001"},
+		{math.MinInt64, 9, "-9223372036.854775808"},
+	}
+	for _, test := range tests {
+		if got := test.units.Format(test.digits); got != test.want {
+			t.Errorf("%d units with %d digits formatted as %q, want %q", int64(test.units), test.digits, got, test.want)
+		}
+	}
+}
+
+func TestAmountJSON(t *testing.T) {
+	var decoded struct{ Number, Text Amount }
+	if err := json.Unmarshal([]byte(`{"Number": 0.1, "Text": "0.2"}`), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	// 0.1 + 0.2 is exact, unlike in float64.
+	if sum := decoded.Number + decoded.Text; sum.String() != "0.3" {
+		t.Errorf("0.1 + 0.2 = %s, want 0.3", sum)
+	}
+	encoded, err := json.Marshal(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(encoded) != `{"Number":0.1,"Text":0.2}` {
+		t.Errorf("encoded as %s", encoded)
+	}
+	if err := json.Unmarshal([]byte(`{"Number": 0.0000000001}`), &decoded); err == nil {
+		t.Error("decoded an amount with too many decimal places")
+	}
+}
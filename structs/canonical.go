@@ -0,0 +1,177 @@
+package structs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Transaction encoding versions. Version 0 transactions are signed over
+// Serialize(); later versions are signed over SigningPayload(). Version 2
+// adds the sender's nonce to the canonical encoding and is the only version
+// new transactions may use; older ones are still verified inside blocks.
+const (
+	LegacyTransactionVersion    = 0
+	CanonicalTransactionVersion = 1
+	NoncedTransactionVersion    = 2
+)
+
+// signingDomain separates transaction signatures from any other message a key
+// might sign. The full signed message is
+//
+//	signingDomain || 0x00 || chain ID || 0x00 || CanonicalJSON()
+const signingDomain = "INDICARTCOIN_TX_V1"
+
+// CanonicalJSON returns the canonical encoding of the transaction: a JSON
+// object with keys in lexicographic order and no insignificant whitespace.
+// It covers every field a client sets, including the full ArtOwnership
+// payload and, from version 2 on, the nonce. Status and Signature are left
+// out because the node changes the former after acceptance and the latter is
+// computed over this encoding.
+//
+// Amounts are decimal strings in coins with exactly AmountDecimals fractional
+// digits. Strings are escaped as JSON.stringify does: only '"', '\\' and
+// control characters.
+func (tx *Transaction) CanonicalJSON() []byte {
+	var b strings.Builder
+	b.WriteString(`{"amount":`)
+	writeCanonicalString(&b, formatCanonicalAmount(tx.Amount))
+	b.WriteString(`,"artId":`)
+	writeCanonicalString(&b, tx.ArtID)
+	b.WriteString(`,"artOwnership":`)
+	writeCanonicalArtOwnership(&b, &tx.ArtOwnership)
+	b.WriteString(`,"fee":`)
+	writeCanonicalString(&b, formatCanonicalAmount(tx.Fee))
+	b.WriteString(`,"from":`)
+	writeCanonicalString(&b, tx.From)
+	b.WriteString(`,"id":`)
+	writeCanonicalString(&b, tx.TransactionId)
+	if tx.Version >= NoncedTransactionVersion {
+		b.WriteString(`,"nonce":`)
+		b.WriteString(strconv.FormatUint(tx.Nonce, 10))
+	}
+	b.WriteString(`,"to":`)
+	writeCanonicalString(&b, tx.To)
+	b.WriteString(`,"type":`)
+	b.WriteString(strconv.Itoa(int(tx.Type)))
+	b.WriteString(`,"version":`)
+	b.WriteString(strconv.Itoa(tx.Version))
+	b.WriteString(`}`)
+	return []byte(b.String())
+}
+
+// SigningPayload returns the exact message a version 1 or 2 transaction is
+// signed over for the given chain.
+func (tx *Transaction) SigningPayload(chainID string) string {
+	return signingDomain + "\x00" + chainID + "\x00" + string(tx.CanonicalJSON())
+}
+
+// SignedMessage returns the message the transaction's signature must cover,
+// according to its version.
+func (tx *Transaction) SignedMessage(chainID string) string {
+	if tx.Version == LegacyTransactionVersion {
+		return tx.Serialize()
+	}
+	return tx.SigningPayload(chainID)
+}
+
+// NeedsBuyerSignature reports whether tx must also carry BuyerSignature: a
+// version 2 or later ArtTransfer with an Amount, which the buyer pays. The
+// buyer signs SignedMessage, the same message as the seller.
+func (tx *Transaction) NeedsBuyerSignature() bool {
+	return tx.Type == ArtTransfer && tx.Amount > 0 && tx.Version >= NoncedTransactionVersion
+}
+
+// Hash returns the hex SHA-256 of the transaction's encoding: Serialize() for
+// version 0, CanonicalJSON() otherwise. It is the leaf used for the block's
+// Merkle tree.
+func (tx *Transaction) Hash() string {
+	var hashed [32]byte
+	if tx.Version == LegacyTransactionVersion {
+		hashed = sha256.Sum256([]byte(tx.Serialize()))
+	} else {
+		hashed = sha256.Sum256(tx.CanonicalJSON())
+	}
+	return hex.EncodeToString(hashed[:])
+}
+
+func writeCanonicalArtOwnership(b *strings.Builder, ao *ArtOwnership) {
+	b.WriteString(`{"art":`)
+	writeCanonicalString(b, ao.Art)
+	b.WriteString(`,"artLikes":`)
+	b.WriteString(strconv.Itoa(ao.ArtLikes))
+	b.WriteString(`,"artName":`)
+	writeCanonicalString(b, ao.ArtName)
+	b.WriteString(`,"artOwner":`)
+	writeCanonicalString(b, ao.ArtOwner)
+	b.WriteString(`,"description":`)
+	writeCanonicalString(b, ao.Description)
+	b.WriteString(`,"forSale":`)
+	b.WriteString(strconv.FormatBool(ao.ForSale))
+	b.WriteString(`,"format":`)
+	writeCanonicalString(b, ao.Format)
+	b.WriteString(`,"id":`)
+	writeCanonicalString(b, ao.Id)
+	b.WriteString(`,"price":`)
+	writeCanonicalString(b, formatCanonicalAmount(ao.Price))
+	b.WriteString(`,"relatedImages":`)
+	writeCanonicalStringList(b, ao.RelatedImages)
+	b.WriteString(`,"relatedVideos":`)
+	writeCanonicalStringList(b, ao.RelatedVideos)
+	b.WriteString(`,"status":`)
+	b.WriteString(strconv.Itoa(int(ao.Status)))
+	b.WriteString(`,"thumbnail":`)
+	writeCanonicalString(b, ao.Thumbnail)
+	b.WriteString(`}`)
+}
+
+func formatCanonicalAmount(amount Amount) string {
+	return amount.Format(AmountDecimals)
+}
+
+func writeCanonicalStringList(b *strings.Builder, list []string) {
+	b.WriteString("[")
+	for i, s := range list {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		writeCanonicalString(b, s)
+	}
+	b.WriteString("]")
+}
+
+// writeCanonicalString writes s as a JSON string the way JSON.stringify does.
+// Invalid UTF-8 is replaced with U+FFFD.
+func writeCanonicalString(b *strings.Builder, s string) {
+	const hexDigits = "0123456789abcdef"
+	b.WriteByte('"')
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		switch {
+		case r == '"':
+			b.WriteString(`\"`)
+		case r == '\\':
+			b.WriteString(`\\`)
+		case r == '\b':
+			b.WriteString(`\b`)
+		case r == '\f':
+			b.WriteString(`\f`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r < 0x20:
+			b.WriteString(`\u00`)
+			b.WriteByte(hexDigits[r>>4])
+			b.WriteByte(hexDigits[r&0xf])
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+}
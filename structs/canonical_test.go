@@ -0,0 +1,91 @@
+package structs_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"indicartcoin/blockchain"
+	"indicartcoin/structs"
+	"testing"
+)
+
+// The test vectors from the README's Signing Transactions section, for chain
+// indicartcoin-local with 9 decimal places.
+var signingVectors = []struct {
+	name      string
+	input     string // as submitted on /ws
+	canonical string
+	sha256    string // of the full signed message
+}{
+	{
+		name:      "coin transfer, version 1",
+		input:     `{"TransactionId": "tx-1", "Type": 0, "From": "alice", "To": "bob", "Amount": 12.5, "Fee": 0.01, "Version": 1}`,
+		canonical: `{"amount":"12.500000000","artId":"","artOwnership":{"art":"","artLikes":0,"artName":"","artOwner":"","description":"","forSale":false,"format":"","id":"","price":"0.000000000","relatedImages":[],"relatedVideos":[],"status":0,"thumbnail":""},"fee":"0.010000000","from":"alice","id":"tx-1","to":"bob","type":0,"version":1}`,
+		sha256:    "e808884c7842367b7cd85d9334804b54912c73a913d122dcc6fa3f4100c72650",
+	},
+	{
+		name:      "art upload, version 1",
+		input:     `{"TransactionId": "tx-2", "Type": 1, "ArtID": "art-7", "From": "alice", "To": "alice", "Fee": 0.1, "Version": 1, "ArtOwnership": {"id": "art-7", "artOwner": "alice", "price": 250, "description": "Ink on \"paper\"\n2023", "format": "png", "art": "media-1", "relatedImages": ["media-2", "media-3"], "artName": "Tide", "forSale": true, "thumbnail": "media-4"}}`,
+		canonical: `{"amount":"0.000000000","artId":"art-7","artOwnership":{"art":"media-1","artLikes":0,"artName":"Tide","artOwner":"alice","description":"Ink on \"paper\"\n2023","forSale":true,"format":"png","id":"art-7","price":"250.000000000","relatedImages":["media-2","media-3"],"relatedVideos":[],"status":0,"thumbnail":"media-4"},"fee":"0.100000000","from":"alice","id":"tx-2","to":"alice","type":1,"version":1}`,
+		sha256:    "d118fc06266efdf903e05e5193c169a6c78e272d0f5b9e31c48374033092bb49",
+	},
+	{
+		name:      "coin transfer, version 2",
+		input:     `{"TransactionId": "tx-3", "Type": 0, "From": "alice", "To": "bob", "Amount": 12.5, "Fee": 0.01, "Version": 2, "Nonce": 7}`,
+		canonical: `{"amount":"12.500000000","artId":"","artOwnership":{"art":"","artLikes":0,"artName":"","artOwner":"","description":"","forSale":false,"format":"","id":"","price":"0.000000000","relatedImages":[],"relatedVideos":[],"status":0,"thumbnail":""},"fee":"0.010000000","from":"alice","id":"tx-3","nonce":7,"to":"bob","type":0,"version":2}`,
+		sha256:    "84c90a7528da20317f5cbe68dc53b45b31fe2debd9b8d312b0c29bcd2437ed6b",
+	},
+}
+
+const vectorChainID = "indicartcoin-local"
+
+func TestSigningVectors(t *testing.T) {
+	if structs.AmountDecimals != 9 {
+		t.Fatalf("AmountDecimals is %d, the vectors use 9", structs.AmountDecimals)
+	}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := blockchain.PublicKeyPEM(key)
+
+	for _, vector := range signingVectors {
+		t.Run(vector.name, func(t *testing.T) {
+			var tx structs.Transaction
+			if err := json.Unmarshal([]byte(vector.input), &tx); err != nil {
+				t.Fatal(err)
+			}
+			if got := string(tx.CanonicalJSON()); got != vector.canonical {
+				t.Errorf("canonical JSON\n got %s\nwant %s", got, vector.canonical)
+			}
+			message := tx.SignedMessage(vectorChainID)
+			if want := "INDICARTCOIN_TX_V1\x00" + vectorChainID + "\x00" + vector.canonical; message != want {
+				t.Errorf("signed message %q, want %q", message, want)
+			}
+			sum := sha256.Sum256([]byte(message))
+			if got := hex.EncodeToString(sum[:]); got != vector.sha256 {
+				t.Errorf("sha256(message) = %s, want %s", got, vector.sha256)
+			}
+
+			// A signature over the message verifies, and only for this
+			// transaction on this chain.
+			signature, err := blockchain.Sign(message, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if valid, err := blockchain.VerifySignature(message, signature, address); err != nil || !valid {
+				t.Errorf("signature over the vector does not verify: %v", err)
+			}
+			if valid, _ := blockchain.VerifySignature(tx.SignedMessage("another-chain"), signature, address); valid {
+				t.Error("signature verifies on another chain")
+			}
+			tampered := tx
+			tampered.Amount++
+			if valid, _ := blockchain.VerifySignature(tampered.SignedMessage(vectorChainID), signature, address); valid {
+				t.Error("signature verifies for a changed amount")
+			}
+		})
+	}
+}
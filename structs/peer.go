@@ -0,0 +1,31 @@
+package structs
+
+import "time"
+
+// Where a KnownPeer's address was learned.
+const (
+	PeerSourceConfig   = "config"   // listed in p2p.peers
+	PeerSourceHello    = "hello"    // announced by the peer itself in the handshake
+	PeerSourceExchange = "exchange" // passed on by another peer
+)
+
+// KnownPeer is an entry in the peer book: the address of another node's /p2p
+// endpoint and how dialing it went.
+type KnownPeer struct {
+	Address       string    `json:"address"` // ws:// or wss:// URL
+	NodeID        string    `json:"nodeId,omitempty"`
+	Source        string    `json:"source"`
+	LastSeen      time.Time `json:"lastSeen"`    // last successful handshake, zero if never
+	LastAttempt   time.Time `json:"lastAttempt"` // last dial, successful or not
+	Failures      int       `json:"failures"`    // failed dials since the last success
+	LatencyMillis int64     `json:"latencyMillis"`
+}
+
+// PeerBan keeps a misbehaving peer away until BannedUntil. Address is the URL
+// the node dialed, or the remote host of a peer that connected to it.
+type PeerBan struct {
+	Address     string    `json:"address"`
+	NodeID      string    `json:"nodeId,omitempty"`
+	Reason      string    `json:"reason"`
+	BannedUntil time.Time `json:"bannedUntil"`
+}
@@ -0,0 +1,32 @@
+package structs
+
+// Receipt records what became of a transaction once it left the pool: the
+// block and position it was mined at and the state it changed, or why it was
+// dropped.
+type Receipt struct {
+	TransactionId string            `json:"transactionId"`
+	Status        TransactionStatus `json:"status"` // Completed or Failed
+	BlockIndex    int               `json:"blockIndex,omitempty"`
+	BlockHash     string            `json:"blockHash,omitempty"`
+	Position      int               `json:"position"`
+	Changes       []StateChange     `json:"changes,omitempty"`
+	Error         string            `json:"error,omitempty"`
+	RecordedAt    string            `json:"recordedAt"` // RFC 3339, UTC
+}
+
+// Kinds of StateChange.
+const (
+	BalanceChange  = "balance"
+	NonceChange    = "nonce"
+	ArtOwnerChange = "artOwner"
+	StakeChange    = "stake"
+)
+
+// StateChange is one value a transaction changed: the balance, nonce or stake
+// of the address in Key, or the owner of the art piece in Key.
+type StateChange struct {
+	Kind   string `json:"kind"`
+	Key    string `json:"key"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
@@ -2,8 +2,9 @@ package structs
 
 import (
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
-	"fmt"
+	"indicartcoin/merkle"
 	"strconv"
 	"strings"
 	"sync"
@@ -11,13 +12,47 @@ import (
 )
 
 type Block struct {
-	Index        int
-	Timestamp    string
-	Hash         string
-	PrevHash     string
-	Transactions []Transaction
+	Version        int // header format, one of the BlockVersion constants
+	Index          int
+	Timestamp      string // time.Time.String() text of blocks before HeaderBlockVersion, empty after
+	TimestampNanos int64  // Unix nanoseconds; hashed from HeaderBlockVersion on, derived from Timestamp before
+	Hash           string
+	PrevHash       string
+	MerkleRoot     string // empty for blocks created before Merkle roots were added
+	Proposer       string // address of the node that sealed the block, from SignedBlockVersion on
+	Signature      string // the proposer's Base64 signature over SigningPayload
+	VRFProof       string // the proposer's Base64 proof over VRFPayload, from VRFBlockVersion on
+	Transactions   []Transaction
 }
 
+// Block header versions. Each keeps the hashing scheme blocks of that version
+// were sealed with, so stored blocks never need to be rehashed.
+const (
+	// LegacyBlockVersion hashes the index as a rune, the Timestamp text, the
+	// previous hash and every serialized transaction.
+	LegacyBlockVersion = 0
+	// MerkleBlockVersion hashes the decimal index, the Timestamp text, the
+	// previous hash and the Merkle root.
+	MerkleBlockVersion = 1
+	// HeaderBlockVersion hashes the canonical header encoding (see
+	// CanonicalHeader).
+	HeaderBlockVersion = 2
+	// SignedBlockVersion adds the proposer to the canonical header and
+	// requires the proposer's signature over it.
+	SignedBlockVersion = 3
+	// VRFBlockVersion adds the proposer's VRF proof to the canonical header.
+	// Its output is the block's Seed, which ranks the proposers of the next
+	// block. New blocks use it.
+	VRFBlockVersion = 4
+)
+
+// NonceBlockVersion is the first header version whose blocks may only carry
+// NoncedTransactionVersion transactions, each ID at most once, so no
+// transaction can be replayed into them. The pool stopped taking older
+// transactions before VRFBlockVersion was introduced, so every block of that
+// version already meets the rule.
+const NonceBlockVersion = VRFBlockVersion
+
 type TransactionType int
 
 const (
@@ -25,6 +60,9 @@ const (
 	ArtUpload
 	ArtTransfer
 	ArtUpdate
+	// Stake locks Amount of the sender's coins as validator stake. Stakes
+	// only change on-chain, so every node ranks the same validators.
+	Stake
 )
 
 type TransactionStatus int
@@ -33,6 +71,7 @@ const (
 	Pending TransactionStatus = iota
 	Completed
 	Confirmed
+	Failed // dropped from the pool without being mined
 )
 
 type Transaction struct {
@@ -41,22 +80,28 @@ type Transaction struct {
 	ArtID         string
 	From          string
 	To            string
-	Amount        float64
-	Fee           float64
+	Amount        Amount
+	Fee           Amount
 	Signature     string
 	ArtOwnership  ArtOwnership
 	Status        TransactionStatus
+	Version       int    // encoding the signature covers, see canonical.go
+	Nonce         uint64 // per-sender sequence number, signed from version 2 on
+	// BuyerSignature is To's signature over the same message as Signature. A
+	// version 2 ArtTransfer with an Amount needs it, since the buyer pays.
+	BuyerSignature string
 }
 
 type Blockchain struct {
-	Blocks []*Block
-	Mutex  *sync.Mutex
+	Blocks  []*Block
+	Mutex   *sync.Mutex
+	Genesis *Block // block 0, not in Blocks; nil for chains started without a genesis file
 }
 
 type ArtOwnership struct {
 	Id            string            `json:"id"`
 	ArtOwner      string            `json:"artOwner"`
-	Price         float64           `json:"price"`
+	Price         Amount            `json:"price"`
 	Description   string            `json:"description"`
 	Format        string            `json:"format"`
 	Art           string            `json:"art"`           // ID or URL of the art media
@@ -69,14 +114,12 @@ type ArtOwnership struct {
 	Status        TransactionStatus `json:"status"`
 }
 
-
-
 type ArtOwnershipSummary struct {
 	Id        string
 	Thumbnail []byte
 	ArtLikes  int
 	ForSale   bool
-	Price     float64
+	Price     Amount
 	Status    TransactionStatus
 }
 
@@ -87,12 +130,13 @@ type ResponseMessage struct {
 
 type Validator struct {
 	Address string
-	Stake   float64
+	Stake   Amount
 }
 
 func (tx *Transaction) Serialize() string {
-	amount := strconv.FormatFloat(tx.Amount, 'f', 9, 64)
-	fee := strconv.FormatFloat(tx.Fee, 'f', 9, 64)
+	// Version 0 signatures were made over amounts printed with nine decimals.
+	amount := tx.Amount.Format(9)
+	fee := tx.Fee.Format(9)
 	fields := []string{
 		tx.TransactionId,
 		strconv.Itoa(int(tx.Type)),
@@ -107,10 +151,144 @@ func (tx *Transaction) Serialize() string {
 	return strings.Join(fields, "|")
 }
 
-func (bc *Blockchain) calculateHash(block *Block) string {
-	record := string(block.Index) + block.Timestamp + block.PrevHash
-	for _, tx := range block.Transactions {
-		record += tx.Serialize()
+func transactionLeaves(transactions []Transaction) [][]byte {
+	leaves := make([][]byte, len(transactions))
+	for i := range transactions {
+		leaf, _ := hex.DecodeString(transactions[i].Hash())
+		leaves[i] = leaf
+	}
+	return leaves
+}
+
+// ComputeMerkleRoot returns the hex Merkle root over the transaction hashes.
+func ComputeMerkleRoot(transactions []Transaction) string {
+	return hex.EncodeToString(merkle.Root(transactionLeaves(transactions)))
+}
+
+// TransactionProof returns the Merkle inclusion proof for the transaction with
+// the given ID, and false if the block does not contain it.
+func (block *Block) TransactionProof(transactionId string) ([]merkle.ProofStep, bool) {
+	for i, tx := range block.Transactions {
+		if tx.TransactionId == transactionId {
+			proof, err := merkle.Proof(transactionLeaves(block.Transactions), i)
+			return proof, err == nil
+		}
+	}
+	return nil, false
+}
+
+// headerDomain separates block hashes from every other hashed or signed
+// message. From HeaderBlockVersion on a block hash is SHA-256(headerDomain ||
+// 0x00 || CanonicalHeader()).
+const headerDomain = "INDICARTCOIN_BLOCK_V2"
+
+// blockSigningDomain separates proposer signatures from transaction and
+// certificate signatures. The signed message is
+//
+//	blockSigningDomain || 0x00 || chain ID || 0x00 || CanonicalHeader()
+const blockSigningDomain = "INDICARTCOIN_BLOCK_SIG_V1"
+
+// vrfDomain separates VRF proofs from every other signature. The proven
+// message is
+//
+//	vrfDomain || 0x00 || chain ID || 0x00 || parent seed || 0x00 || index
+const vrfDomain = "INDICARTCOIN_VRF_V1"
+
+// seedDomain separates block seeds from other hashes. A VRFBlockVersion
+// block's seed is SHA-256(seedDomain || 0x00 || proof bytes).
+const seedDomain = "INDICARTCOIN_SEED_V1"
+
+// CanonicalHeader returns the canonical encoding of the block header: a JSON
+// object with keys in lexicographic order and no insignificant whitespace,
+// holding the index, Merkle root, previous hash, proposer (from
+// SignedBlockVersion on), Unix-nanosecond timestamp, version and VRF proof
+// (from VRFBlockVersion on). The transactions are covered through the Merkle
+// root.
+func (block *Block) CanonicalHeader() []byte {
+	var b strings.Builder
+	b.WriteString(`{"index":`)
+	b.WriteString(strconv.Itoa(block.Index))
+	b.WriteString(`,"merkleRoot":`)
+	writeCanonicalString(&b, block.MerkleRoot)
+	b.WriteString(`,"prevHash":`)
+	writeCanonicalString(&b, block.PrevHash)
+	if block.Version >= SignedBlockVersion {
+		b.WriteString(`,"proposer":`)
+		writeCanonicalString(&b, block.Proposer)
+	}
+	b.WriteString(`,"timestamp":`)
+	b.WriteString(strconv.FormatInt(block.TimestampNanos, 10))
+	b.WriteString(`,"version":`)
+	b.WriteString(strconv.Itoa(block.Version))
+	if block.Version >= VRFBlockVersion {
+		b.WriteString(`,"vrfProof":`)
+		writeCanonicalString(&b, block.VRFProof)
+	}
+	b.WriteString(`}`)
+	return []byte(b.String())
+}
+
+// Header returns a copy of the block without its transactions, as sent to
+// nodes that download headers ahead of blocks.
+func (block *Block) Header() Block {
+	header := *block
+	header.Transactions = nil
+	return header
+}
+
+// SigningPayload returns the exact message the proposer of a
+// SignedBlockVersion block signs for the given chain.
+func (block *Block) SigningPayload(chainID string) string {
+	return blockSigningDomain + "\x00" + chainID + "\x00" + string(block.CanonicalHeader())
+}
+
+// VRFPayload returns the message the proposer of a VRFBlockVersion block
+// proves for the given chain: the parent's seed and the block's index, empty
+// seed for the first block of a chain without a genesis block. It leaves out
+// everything else the proposer chooses, such as the transactions, so each
+// proposer has exactly one proof, and so one seed, per parent.
+func (block *Block) VRFPayload(chainID string, parent *Block) string {
+	seed := ""
+	if parent != nil {
+		seed = parent.Seed()
+	}
+	return vrfDomain + "\x00" + chainID + "\x00" + seed + "\x00" + strconv.Itoa(block.Index)
+}
+
+// Seed returns the hex randomness the block hands on to choosing the next
+// proposer: the hash of its VRF proof from VRFBlockVersion on, its own hash
+// before.
+func (block *Block) Seed() string {
+	if block.Version < VRFBlockVersion {
+		return block.Hash
+	}
+	proof, err := base64.StdEncoding.DecodeString(block.VRFProof)
+	if err != nil {
+		// Such blocks fail verification; hashing the text keeps Seed total.
+		proof = []byte(block.VRFProof)
+	}
+	h := sha256.New()
+	h.Write([]byte(seedDomain + "\x00"))
+	h.Write(proof)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// CalculateHash returns the SHA-256 hash of the block header, computed the way
+// the block's Version prescribes. From MerkleBlockVersion on the transactions
+// are covered through the Merkle root, so a header and a Merkle proof are
+// enough to show a transaction is in the block.
+func (block *Block) CalculateHash() string {
+	var record string
+	if block.Version >= HeaderBlockVersion {
+		record = headerDomain + "\x00" + string(block.CanonicalHeader())
+	} else if block.Version == MerkleBlockVersion {
+		record = strconv.Itoa(block.Index) + block.Timestamp + block.PrevHash + block.MerkleRoot
+	} else {
+		// string(rune(...)) keeps the original encoding of the index.
+		record = string(rune(block.Index)) + block.Timestamp + block.PrevHash
+		for _, tx := range block.Transactions {
+			record += tx.Serialize()
+		}
 	}
 	h := sha256.New()
 	h.Write([]byte(record))
@@ -118,21 +296,83 @@ func (bc *Blockchain) calculateHash(block *Block) string {
 	return hex.EncodeToString(hashed)
 }
 
-func (bc Blockchain) AddBlock(transactions []Transaction, vals []Validator) *Block {
+func (bc *Blockchain) calculateHash(block *Block) string {
+	return block.CalculateHash()
+}
+
+// AddBlock seals the next block from transactions and appends it to the
+// chain. proposer is the address of the sealing node; prove returns its VRF
+// proof over the block's VRFPayload for the given parent and sign its
+// signature over the block's SigningPayload. If either fails nothing is
+// added.
+func (bc *Blockchain) AddBlock(transactions []Transaction, proposer string, prove func(block, parent *Block) (string, error), sign func(*Block) (string, error)) (*Block, error) {
+	bc.Mutex.Lock()
+	defer bc.Mutex.Unlock()
+
 	newBlock := &Block{
-		Index:        len(bc.Blocks) + 1,
-		Timestamp:    time.Now().String(),
-		Transactions: transactions, // Add this line
+		Version:        VRFBlockVersion,
+		Proposer:       proposer,
+		Index:          len(bc.Blocks) + 1,
+		TimestampNanos: time.Now().UnixNano(),
+		Transactions:   transactions, // Add this line
+		MerkleRoot:     ComputeMerkleRoot(transactions),
 	}
-	newBlock.Hash = bc.calculateHash(newBlock)
+	parent := bc.Genesis
 	if len(bc.Blocks) > 0 {
-		newBlock.PrevHash = bc.Blocks[len(bc.Blocks)-1].Hash
+		parent = bc.Blocks[len(bc.Blocks)-1]
 	}
-	bc.Mutex.Lock()
+	if parent != nil {
+		newBlock.PrevHash = parent.Hash
+		// Block times must increase even if the clock steps back.
+		if parentTime, ok := parent.Time(); ok && newBlock.TimestampNanos <= parentTime.UnixNano() {
+			newBlock.TimestampNanos = parentTime.UnixNano() + 1
+		}
+	}
+	proof, err := prove(newBlock, parent)
+	if err != nil {
+		return nil, err
+	}
+	newBlock.VRFProof = proof
+	// The previous hash must be set before hashing so the block commits to its parent.
+	newBlock.Hash = bc.calculateHash(newBlock)
+	signature, err := sign(newBlock)
+	if err != nil {
+		return nil, err
+	}
+	newBlock.Signature = signature
 	bc.Blocks = append(bc.Blocks, newBlock)
-	bc.Mutex.Unlock()
 
-	return newBlock
+	return newBlock, nil
+}
+
+// blockTimeLayout is the format time.Time.String uses, which blocks before
+// HeaderBlockVersion store in Timestamp.
+const blockTimeLayout = "2006-01-02 15:04:05.999999999 -0700 MST"
+
+// Time returns when the block was sealed. For blocks before HeaderBlockVersion
+// it parses Timestamp, ignoring the monotonic clock reading time.Time.String
+// appends, and reports false if the text is not in that format.
+func (block *Block) Time() (time.Time, bool) {
+	if block.Version >= HeaderBlockVersion {
+		return time.Unix(0, block.TimestampNanos).UTC(), true
+	}
+	timestamp := block.Timestamp
+	if i := strings.Index(timestamp, " m="); i >= 0 {
+		timestamp = timestamp[:i]
+	}
+	parsed, err := time.Parse(blockTimeLayout, timestamp)
+	return parsed, err == nil
+}
+
+// RemoveLastBlock takes block off the end of the chain, for example when it
+// could not be stored. It does nothing if block is no longer the last one.
+func (bc *Blockchain) RemoveLastBlock(block *Block) {
+	bc.Mutex.Lock()
+	defer bc.Mutex.Unlock()
+
+	if n := len(bc.Blocks); n > 0 && bc.Blocks[n-1] == block {
+		bc.Blocks = bc.Blocks[:n-1]
+	}
 }
 
 func (ao *ArtOwnership) IsArtOwnershipEmpty() bool {
@@ -169,11 +409,21 @@ func (ao *ArtOwnership) IsArtOwnershipEmpty() bool {
 	if len(ao.Thumbnail) != 0 {
 		return false
 	}
-	fmt.Println("Art is empty")
 	// Add more fields as needed
 	return true
 }
 
+var transactionStatusNames = [...]string{"Pending", "Completed", "Confirmed", "Failed"}
+
 func (s TransactionStatus) String() string {
-	return [...]string{"Pending", "Completed", "Confirmed"}[s]
+	if !s.Known() {
+		return "Unknown"
+	}
+	return transactionStatusNames[s]
+}
+
+// Known reports whether s is one of the TransactionStatus constants. Statuses
+// arrive from clients and peers, so any int can show up.
+func (s TransactionStatus) Known() bool {
+	return s >= 0 && int(s) < len(transactionStatusNames)
 }
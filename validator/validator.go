@@ -1,28 +1,138 @@
 package validator
 
 import (
-	"fmt"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
 	"indicartcoin/structs"
+	"math"
+	"math/big"
+	"sort"
 )
 
-// selectValidator selects a validator based on their stake, rewards, and penalties.
-func SelectValidator(validators []structs.Validator) (structs.Validator, error) {
-	// Calculate the total score
-	MaxScore := float64(0)
-	for _, validator := range validators {
-		if validator.Stake > MaxScore {
-			MaxScore = validator.Stake
+// rankDomain separates the draws Rank makes from every other hash.
+const rankDomain = "INDICARTCOIN_RANK_V1"
+
+// ErrNoValidators is returned by SelectProposer when no validator has stake.
+var ErrNoValidators = errors.New("no validators with stake")
+
+// Rank orders the validators with stake for the block whose parent has the
+// given seed (see structs.Block.Seed). Each place is drawn from the ones not
+// yet placed, with chances proportional to stake, so a validator holding a
+// quarter of the stake comes first in a quarter of the blocks. The draws are
+// SHA-256 hashes of the seed, so every node computes the same order, and
+// nobody knows it before the parent block exists. Validators without stake
+// are left out, and an address that signed up more than once counts with its
+// stakes added up; the input order does not matter.
+func Rank(validators []structs.Validator, seed string) []structs.Validator {
+	stakes := make(map[string]structs.Amount, len(validators))
+	for _, val := range validators {
+		if val.Stake > 0 {
+			stakes[val.Address] += val.Stake
 		}
+	}
+	remaining := make([]structs.Validator, 0, len(stakes))
+	total := new(big.Int)
+	for address, stake := range stakes {
+		remaining = append(remaining, structs.Validator{Address: address, Stake: stake})
+		total.Add(total, big.NewInt(int64(stake)))
+	}
+	sort.Slice(remaining, func(i, j int) bool { return remaining[i].Address < remaining[j].Address })
 
+	ranked := make([]structs.Validator, 0, len(remaining))
+	for draw := uint64(0); len(remaining) > 0; draw++ {
+		// A 256-bit draw reduced by the total stake, which fits in 63 bits,
+		// is as good as uniform.
+		target := new(big.Int).Mod(drawHash(seed, draw), total)
+		pick := 0
+		for cumulative := new(big.Int); pick < len(remaining); pick++ {
+			cumulative.Add(cumulative, big.NewInt(int64(remaining[pick].Stake)))
+			if target.Cmp(cumulative) < 0 {
+				break
+			}
+		}
+		ranked = append(ranked, remaining[pick])
+		total.Sub(total, big.NewInt(int64(remaining[pick].Stake)))
+		remaining = append(remaining[:pick], remaining[pick+1:]...)
 	}
+	return ranked
+}
+
+// drawHash returns SHA-256(rankDomain || 0x00 || seed || 0x00 || draw) as a
+// number, draw as 8 big-endian bytes.
+func drawHash(seed string, draw uint64) *big.Int {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], draw)
+	h := sha256.New()
+	h.Write([]byte(rankDomain + "\x00" + seed + "\x00"))
+	h.Write(counter[:])
+	return new(big.Int).SetBytes(h.Sum(nil))
+}
 
-	for _, validator := range validators {
-		if validator.Stake == MaxScore {
-			return validator, nil
+// SelectProposer returns the validator first in line to propose the block
+// whose parent has the given seed.
+func SelectProposer(validators []structs.Validator, seed string) (structs.Validator, error) {
+	ranked := Rank(validators, seed)
+	if len(ranked) == 0 {
+		return structs.Validator{}, ErrNoValidators
+	}
+	return ranked[0], nil
+}
+
+// Reward is the share of a block's fees paid to one address.
+type Reward struct {
+	Address string
+	Amount  structs.Amount
+}
+
+// Rewards splits a block's fees. The proposer that sealed the block comes
+// first and takes the largest share, whether or not it is a registered
+// validator; the validators with stake follow in their Rank order for the
+// block's seed, so every node pays the same shares. validators are the stakes
+// from before the block, and decayConstant is the chain's
+// rewardDecayConstant (see SplitFees).
+func Rewards(proposer string, seed string, validators []structs.Validator, totalFees structs.Amount, decayConstant float64) []Reward {
+	recipients := []string{proposer}
+	for _, val := range Rank(validators, seed) {
+		if val.Address != proposer {
+			recipients = append(recipients, val.Address)
 		}
+	}
+	shares := SplitFees(totalFees, len(recipients), decayConstant)
+	rewards := make([]Reward, len(recipients))
+	for i, address := range recipients {
+		rewards[i] = Reward{Address: address, Amount: shares[i]}
+	}
+	return rewards
+}
 
+// SplitFees divides totalFees between n validators by normalized exponential
+// decay. The weights are rounded to integers before dividing, so every node
+// computes the same shares; whatever integer division leaves over goes to the
+// first validator and the shares always add up to totalFees.
+func SplitFees(totalFees structs.Amount, n int, decayConstant float64) []structs.Amount {
+	if n == 0 {
+		return nil
+	}
+	weights := make([]*big.Int, n)
+	totalWeight := new(big.Int)
+	for i := range weights {
+		weights[i] = big.NewInt(int64(math.Round(1e9 * math.Exp(-decayConstant*float64(i)))))
+		totalWeight.Add(totalWeight, weights[i])
 	}
 
-	// This should never happen
-	return structs.Validator{}, fmt.Errorf("unexpected error in selecting validator")
+	shares := make([]structs.Amount, n)
+	if totalWeight.Sign() == 0 {
+		shares[0] = totalFees
+		return shares
+	}
+	distributed := structs.Amount(0)
+	for i, weight := range weights {
+		share := new(big.Int).Mul(big.NewInt(int64(totalFees)), weight)
+		share.Quo(share, totalWeight)
+		shares[i] = structs.Amount(share.Int64())
+		distributed += shares[i]
+	}
+	shares[0] += totalFees - distributed
+	return shares
 }
@@ -0,0 +1,122 @@
+package validator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"indicartcoin/structs"
+	"math"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// testSeed stands in for a block seed: the hex SHA-256 of i.
+func testSeed(i int) string {
+	sum := sha256.Sum256([]byte(strconv.Itoa(i)))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestRankFirstPlaceFollowsStake(t *testing.T) {
+	validators := []structs.Validator{
+		{Address: "a", Stake: 100},
+		{Address: "b", Stake: 200},
+		{Address: "c", Stake: 300},
+		{Address: "d", Stake: 400},
+	}
+	const rounds = 20000
+	first := make(map[string]int)
+	for i := 0; i < rounds; i++ {
+		first[Rank(validators, testSeed(i))[0].Address]++
+	}
+	for _, val := range validators {
+		want := float64(val.Stake) / 1000
+		got := float64(first[val.Address]) / rounds
+		// The standard deviation of each share is below 0.0035.
+		if math.Abs(got-want) > 0.015 {
+			t.Errorf("validator %s first in %.4f of rounds, want about %.2f", val.Address, got, want)
+		}
+	}
+}
+
+func TestRankIsDeterministic(t *testing.T) {
+	validators := []structs.Validator{
+		{Address: "a", Stake: 5},
+		{Address: "b", Stake: 1},
+		{Address: "c", Stake: 9},
+		{Address: "d", Stake: 3},
+	}
+	reversed := make([]structs.Validator, len(validators))
+	for i, val := range validators {
+		reversed[len(validators)-1-i] = val
+	}
+	orders := make(map[string]bool)
+	for i := 0; i < 50; i++ {
+		seed := testSeed(i)
+		ranked := Rank(validators, seed)
+		if again := Rank(validators, seed); !reflect.DeepEqual(ranked, again) {
+			t.Fatalf("seed %s ranked %v, then %v", seed, ranked, again)
+		}
+		if fromReversed := Rank(reversed, seed); !reflect.DeepEqual(ranked, fromReversed) {
+			t.Fatalf("seed %s ranked %v, but %v with the input reversed", seed, ranked, fromReversed)
+		}
+		if len(ranked) != len(validators) {
+			t.Fatalf("seed %s ranked %d validators, want %d", seed, len(ranked), len(validators))
+		}
+		order := ""
+		for _, val := range ranked {
+			order += val.Address
+		}
+		orders[order] = true
+	}
+	if len(orders) < 2 {
+		t.Errorf("50 seeds gave only the orders %v", orders)
+	}
+}
+
+func TestRankMergesDuplicatesAndSkipsZeroStake(t *testing.T) {
+	ranked := Rank([]structs.Validator{
+		{Address: "a", Stake: 2},
+		{Address: "idle", Stake: 0},
+		{Address: "a", Stake: 3},
+	}, testSeed(0))
+	want := []structs.Validator{{Address: "a", Stake: 5}}
+	if !reflect.DeepEqual(ranked, want) {
+		t.Errorf("ranked %v, want %v", ranked, want)
+	}
+}
+
+func TestSelectProposerWithoutStake(t *testing.T) {
+	_, err := SelectProposer([]structs.Validator{{Address: "idle", Stake: 0}}, testSeed(0))
+	if !errors.Is(err, ErrNoValidators) {
+		t.Errorf("got error %v, want ErrNoValidators", err)
+	}
+}
+
+func TestRewardsPayProposerFirstAndSplitExactly(t *testing.T) {
+	validators := []structs.Validator{
+		{Address: "a", Stake: 5},
+		{Address: "b", Stake: 1},
+		{Address: "c", Stake: 9},
+	}
+	for i := 0; i < 20; i++ {
+		seed := testSeed(i)
+		rewards := Rewards("b", seed, validators, 1000000007, 0.5)
+		if len(rewards) != 3 || rewards[0].Address != "b" {
+			t.Fatalf("seed %s paid %v, want proposer b first and each validator once", seed, rewards)
+		}
+		total := structs.Amount(0)
+		for j, reward := range rewards {
+			total += reward.Amount
+			if j > 0 && reward.Amount > rewards[j-1].Amount {
+				t.Errorf("seed %s paid place %d more than place %d: %v", seed, j, j-1, rewards)
+			}
+		}
+		if total != 1000000007 {
+			t.Errorf("seed %s paid %s in total, want 1.000000007", seed, total)
+		}
+	}
+	if rewards := Rewards("outsider", testSeed(0), validators, 10, 0.5); len(rewards) != 4 || rewards[0].Address != "outsider" {
+		t.Errorf("unstaked proposer paid %v, want it first ahead of the three validators", rewards)
+	}
+}